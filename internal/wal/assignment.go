@@ -0,0 +1,79 @@
+package wal
+
+import "time"
+
+// WorkerStats summarizes how much work a single worker was assigned
+// and how those assignments ended
+type WorkerStats struct {
+	LeasesGranted        int
+	Completions          int
+	Failures             int
+	AverageLeaseDuration time.Duration // mean of LeaseExpiry - GrantedAt, including extensions
+}
+
+// leaseSpan tracks a granted lease while aggregating assignment stats
+type leaseSpan struct {
+	workerID  string
+	grantedAt time.Time
+	expiry    time.Time
+}
+
+// AssignmentStats replays the WAL and aggregates per-worker lease grants,
+// completions, failures and average lease duration in a single pass
+// Leases without a GrantedAt timestamp are excluded from the duration average
+func (w *WAL) AssignmentStats() (map[string]WorkerStats, error) {
+	stats := make(map[string]WorkerStats)
+	leases := make(map[string]*leaseSpan)
+
+	err := w.Replay(func(record Record) error {
+		switch p := record.Payload.(type) {
+		case LeaseGrantedPayload:
+			s := stats[p.WorkerID]
+			s.LeasesGranted++
+			stats[p.WorkerID] = s
+			leases[p.LeaseID] = &leaseSpan{
+				workerID:  p.WorkerID,
+				grantedAt: p.GrantedAt,
+				expiry:    p.LeaseExpiry,
+			}
+		case LeaseExtendedPayload:
+			if l, ok := leases[p.LeaseID]; ok {
+				l.expiry = p.NewLeaseExpiry
+			}
+		case TaskCompletedPayload:
+			if l, ok := leases[p.LeaseID]; ok {
+				s := stats[l.workerID]
+				s.Completions++
+				stats[l.workerID] = s
+			}
+		case TaskFailedPayload:
+			if l, ok := leases[p.LeaseID]; ok {
+				s := stats[l.workerID]
+				s.Failures++
+				stats[l.workerID] = s
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Average lease duration is computed once all extensions are known
+	totals := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	for _, l := range leases {
+		if l.grantedAt.IsZero() {
+			continue
+		}
+		totals[l.workerID] += l.expiry.Sub(l.grantedAt)
+		counts[l.workerID]++
+	}
+	for workerID, n := range counts {
+		s := stats[workerID]
+		s.AverageLeaseDuration = totals[workerID] / time.Duration(n)
+		stats[workerID] = s
+	}
+
+	return stats, nil
+}
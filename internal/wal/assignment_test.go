@@ -0,0 +1,55 @@
+package wal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssignmentStatsSkewedWorkers(t *testing.T) {
+	w := openTestWAL(t, Config{})
+
+	// w1 takes four tasks, one of which fails; w2 takes one
+	for i := 1; i <= 5; i++ {
+		taskID, leaseID := fmt.Sprintf("t%d", i), fmt.Sprintf("l%d", i)
+		workerID := "w1"
+		if i == 5 {
+			workerID = "w2"
+		}
+		mustAppend(t, w, taskCreated(taskID), leaseGranted(taskID, leaseID, workerID, 1))
+	}
+	mustAppend(t, w,
+		leaseExtended("l1", testTime.Add(5*time.Minute)),
+		taskCompleted("t1", "l1"),
+		taskCompleted("t2", "l2"),
+		taskCompleted("t3", "l3"),
+		taskFailed("t4", "l4", "boom"),
+		taskCompleted("t5", "l5"),
+	)
+
+	stats, err := w.AssignmentStats()
+	if err != nil {
+		t.Fatalf("AssignmentStats: %v", err)
+	}
+
+	want := map[string]WorkerStats{
+		"w1": {LeasesGranted: 4, Completions: 3, Failures: 1, AverageLeaseDuration: 2 * time.Minute},
+		"w2": {LeasesGranted: 1, Completions: 1, AverageLeaseDuration: time.Minute},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("AssignmentStats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestAssignmentStatsEmptyLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+
+	stats, err := w.AssignmentStats()
+	if err != nil {
+		t.Fatalf("AssignmentStats: %v", err)
+	}
+	if len(stats) != 0 {
+		t.Fatalf("AssignmentStats = %+v, want none", stats)
+	}
+}
@@ -0,0 +1,54 @@
+package wal
+
+import (
+	"fmt"
+	"time"
+)
+
+// AppendAsync is Append that does not wait for durability: the record is
+// written to the log buffer before AppendAsync returns, without the sync
+// policy of Append, and the channel receives nil once an fsync covers it,
+// or the error that prevented either
+// Producers can fire many appends and await them in bulk; the syncs they
+// wait on are group committed, see Sync, so a single fsync usually covers
+// a whole burst. The channel is buffered and receives exactly one value
+func (w *WAL) AppendAsync(record Record) <-chan error {
+	start := time.Now()
+	done := make(chan error, 1)
+	if err := w.bufferAsync(record); err != nil {
+		done <- err
+		return done
+	}
+
+	go func() {
+		err := w.Sync()
+		if err == nil {
+			w.appendLatency.observe(time.Since(start))
+		}
+		done <- err
+	}()
+	return done
+}
+
+// bufferAsync validates and encodes record outside the lock, as AppendAt
+// does, then writes it to the buffer with bufferRecordLocked
+func (w *WAL) bufferAsync(record Record) error {
+	record = stampRecord(record, w.clock())
+	if err := w.validateRecord(record); err != nil {
+		return err
+	}
+
+	header, err := w.activeHeader()
+	if err != nil {
+		return err
+	}
+	data, err := w.encodeRecordWith(header, record)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.bufferRecordLocked(header, data, record)
+}
@@ -0,0 +1,104 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppendAsyncGroupCommits(t *testing.T) {
+	const n = 100
+	syncer := &countingSyncer{delay: time.Millisecond}
+	// Without SyncBatchSize, Append would fsync every record
+	w := openTestWAL(t, Config{syncer: syncer})
+
+	futures := make([]<-chan error, n)
+	for i := range futures {
+		futures[i] = w.AppendAsync(taskCreated(fmt.Sprintf("t%d", i)))
+	}
+	for i, future := range futures {
+		if err := <-future; err != nil {
+			t.Fatalf("AppendAsync %d: %v", i, err)
+		}
+	}
+
+	if got := syncer.count(); got > n/10 {
+		t.Fatalf("%d async appends took %d fsyncs, want them group committed", n, got)
+	}
+	if w.LastSyncedOffset() != w.Offset() {
+		t.Fatalf("synced to %d of %d after every future resolved", w.LastSyncedOffset(), w.Offset())
+	}
+
+	w = reopenTestWAL(t, w, Config{})
+	records := mustReplay(t, w)
+	want := make([]string, n)
+	for i := range want {
+		want[i] = fmt.Sprintf("t%d", i)
+	}
+	if got := taskIDs(records); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want t0 to t%d in order", got, n-1)
+	}
+}
+
+func TestAppendAsyncConcurrentProducers(t *testing.T) {
+	const producers, each = 4, 25
+	w := openTestWAL(t, Config{syncer: &countingSyncer{}})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, producers*each)
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			futures := make([]<-chan error, each)
+			for i := range futures {
+				futures[i] = w.AppendAsync(taskCreated(fmt.Sprintf("p%d-%d", p, i)))
+			}
+			for _, future := range futures {
+				errs <- <-future
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("AppendAsync: %v", err)
+		}
+	}
+
+	if got := len(mustReplay(t, w)); got != producers*each {
+		t.Fatalf("replayed %d records, want %d", got, producers*each)
+	}
+}
+
+func TestAppendAsyncErrors(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{syncer: syncer})
+
+	// An invalid record is rejected without being written
+	if err := <-w.AppendAsync(taskCreated("")); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("AppendAsync of an invalid record = %v, want ErrInvalidRecord", err)
+	}
+
+	// A failing fsync reaches the future, though the record was written
+	injected := errors.New("injected fsync failure")
+	syncer.fail(injected)
+	if err := <-w.AppendAsync(taskCreated("a")); !errors.Is(err, injected) {
+		t.Fatalf("AppendAsync with a failing fsync = %v, want it wrapped", err)
+	}
+	syncer.fail(nil)
+	if got := taskIDs(mustReplay(t, w)); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("replayed %v, want the record whose sync failed", got)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := <-w.AppendAsync(taskCreated("b")); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("AppendAsync to a closed log = %v, want ErrWALClosed", err)
+	}
+}
@@ -0,0 +1,39 @@
+package wal
+
+import "time"
+
+// startBackgroundSync starts a goroutine that syncs the log every interval
+// while it has unsynced records, until stopBackgroundSync is called
+func (w *WAL) startBackgroundSync(interval time.Duration) {
+	w.stopSync = make(chan struct{})
+	w.syncStopped = make(chan struct{})
+
+	go func() {
+		defer close(w.syncStopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// A failure leaves the records unsynced, so the next Sync
+				// retries and reports it
+				w.Sync()
+			case <-w.stopSync:
+				return
+			}
+		}
+	}()
+}
+
+// stopBackgroundSync stops the background sync goroutine, if any, and
+// waits for it to exit. It must be called without holding w.mu
+func (w *WAL) stopBackgroundSync() {
+	if w.stopSync == nil {
+		return
+	}
+
+	w.stopOnce.Do(func() { close(w.stopSync) })
+	<-w.syncStopped
+}
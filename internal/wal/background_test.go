@@ -0,0 +1,57 @@
+package wal
+
+import (
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a generous timeout passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSyncIntervalSyncsInBackground(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 1000, SyncInterval: 5 * time.Millisecond, syncer: syncer})
+
+	mustAppend(t, w, taskCreated("a"))
+	waitFor(t, "a background fsync", func() bool { return syncer.count() > 0 })
+	if got := w.LastSyncedOffset(); got != w.Offset() {
+		t.Fatalf("LastSyncedOffset = %d, want %d", got, w.Offset())
+	}
+
+	// An idle log is not fsynced again
+	syncs := syncer.count()
+	time.Sleep(50 * time.Millisecond)
+	if got := syncer.count(); got != syncs {
+		t.Fatalf("%d fsyncs of an idle log", got-syncs)
+	}
+}
+
+func TestSyncIntervalStopsOnClose(t *testing.T) {
+	w := openTestWAL(t, Config{SyncInterval: time.Millisecond})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case <-w.syncStopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background sync still running after Close")
+	}
+}
+
+func TestSyncIntervalNotStartedReadOnly(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true, SyncInterval: time.Millisecond})
+	if r.stopSync != nil {
+		t.Fatal("background sync started for a read-only log")
+	}
+}
@@ -0,0 +1,122 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyLog copies the active file of w as it is on disk, i.e. what a crash
+// at this point would leave behind, and returns the copy's path
+func copyLog(t testing.TB, w *WAL) string {
+	t.Helper()
+	data, err := os.ReadFile(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "crashed.wal")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCrashBeforeSyncRecoversFlushedRecords(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 1000})
+
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	flushed := w.Offset()
+	mustAppend(t, w, taskCreated("c"))
+
+	// The last record is still in the write buffer when the process dies
+	crashed := openTestWAL(t, Config{FilePath: copyLog(t, w)})
+	records := mustReplay(t, crashed)
+	if len(records) != 2 {
+		t.Fatalf("recovered %d records, want the 2 flushed", len(records))
+	}
+	if got := crashed.Offset(); got != flushed {
+		t.Fatalf("recovered log ends at %d, want %d", got, flushed)
+	}
+}
+
+func TestWriteBufferHoldsRecordsUntilFlush(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 1000})
+
+	mustAppend(t, w, taskCreated("a"))
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != headerSize {
+		t.Fatalf("file size before flush = %d, want the header only", stat.Size())
+	}
+
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if stat, err = os.Stat(w.filePath); err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != w.Offset() {
+		t.Fatalf("file size after Sync = %d, want %d", stat.Size(), w.Offset())
+	}
+}
+
+func TestFlushIsVisibleWithoutSync(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 1000, syncer: syncer})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if syncer.count() != 0 {
+		t.Fatalf("Flush ran %d fsyncs, want none", syncer.count())
+	}
+
+	reader := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if records := mustReplay(t, reader); len(records) != 2 {
+		t.Fatalf("separate handle read %d records, want 2", len(records))
+	}
+}
+
+func TestFlushClosed(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Flush(); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("Flush = %v, want ErrWALClosed", err)
+	}
+}
+
+// BenchmarkAppendBuffer compares appends through the default write buffer
+// with a buffer smaller than a record, which bufio bypasses, so every
+// append is its own write call as before the buffer was added
+// fsync is left out by never filling the sync batch
+func BenchmarkAppendBuffer(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		size int
+	}{
+		{"unbuffered", 16},
+		{"buffered", DefaultWriteBufferSize},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			w := openTestWAL(b, Config{SyncBatchSize: b.N + 1, WriteBufferSize: bench.size})
+			record := taskCreated("bench")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.Append(record); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
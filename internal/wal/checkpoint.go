@@ -0,0 +1,146 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// LastCheckpoint scans the active file for the most recent checkpoint
+// Resuming with ReplayFrom(checkpoint.Offset) on top of the persisted state
+// replays exactly the records the checkpoint does not cover
+// Returns false if the active file holds no checkpoint
+func (w *WAL) LastCheckpoint() (CheckpointPayload, bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var last CheckpointPayload
+	found := false
+	_, err := w.replayFromLocked(context.Background(), 0, func(record Record, _, _ int64) error {
+		if p, ok := record.Payload.(CheckpointPayload); ok {
+			last = p
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		return CheckpointPayload{}, false, err
+	}
+
+	return last, found, nil
+}
+
+// ReplayFromLastCheckpoint replays the records the most recent checkpoint
+// of the active file does not cover and returns the offset of that
+// checkpoint record, so recovery can start from persisted state instead of
+// the whole log. Replay resumes at the checkpoint's Offset, which precedes
+// the checkpoint record when records were appended between the snapshot
+// and the checkpoint; checkpoint records themselves are not replayed
+// Checkpoints are located from frame headers alone, without decoding the
+// records before them. Without a checkpoint in the active file the whole
+// log is replayed, sealed segments included, and 0 is returned
+func (w *WAL) ReplayFromLastCheckpoint(applyFn func(Record) error) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, ErrWALClosed
+	}
+	if err := w.flushLocked(); err != nil {
+		return 0, err
+	}
+
+	offset, size, err := w.lastFrameLocked(RecordTypeCheckpoint)
+	if err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		return 0, w.replayAllLocked(context.Background(), applyOnly(applyFn))
+	}
+
+	// The payload is decoded whatever Config.ReplayTypes selects
+	format := w.format(w.header)
+	format.types = nil
+	record, _, err := readRecord(io.NewSectionReader(w.readFile, offset, size), format)
+	if err != nil {
+		return offset, fmt.Errorf("failed to read checkpoint at offset %d: %w", offset, err)
+	}
+	start := min(record.Payload.(CheckpointPayload).Offset, offset+size)
+
+	scanFn, done := w.observeReplay(w.strictSequenceCheck(applyOnly(func(record Record) error {
+		if record.Type == RecordTypeCheckpoint {
+			return nil
+		}
+		return applyFn(record)
+	})))
+	defer done()
+	if _, err := w.replayFromLocked(context.Background(), start, scanFn); err != nil {
+		return offset, err
+	}
+
+	return offset, nil
+}
+
+// lastFrameLocked returns the offset and size of the last valid frame of
+// record type t in the active file, or a zero size if there is none
+// Only frame headers and checksums are read
+// The write buffer must have been flushed by the caller
+// Callers must hold w.mu
+func (w *WAL) lastFrameLocked(t RecordType) (int64, int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(w.readFile, headerSize, w.offset-headerSize))
+	format := w.format(w.header)
+	tail := w.activeTailPolicy()
+
+	var lastOffset, lastSize int64
+	offset := int64(headerSize)
+	for index := 0; ; index++ {
+		body, size, err := readFrame(r, format)
+		if err == io.EOF {
+			return lastOffset, lastSize, nil
+		}
+		if err != nil {
+			if tail.tolerates(err) && atEOF(r) {
+				return lastOffset, lastSize, nil
+			}
+			if errors.Is(err, ErrPartialWrite) || errors.Is(err, ErrInvalidChecksum) {
+				err = fmt.Errorf("%w: bad record: %w", ErrCorruptedLog, err)
+			}
+			return 0, 0, &ReplayError{Offset: offset, RecordIndex: index, Err: fmt.Errorf("failed to read record: %w", err)}
+		}
+
+		if RecordType(body[0]&^recordFlagMask) == t {
+			lastOffset, lastSize = offset, size
+		}
+		offset += size
+	}
+}
+
+// Hash returns a deterministic CRC32 over the authoritative parts of the
+// state, for comparison against CheckpointPayload.StateHash
+func (s *State) Hash() uint32 {
+	ids := make([]string, 0, len(s.Tasks))
+	for id := range s.Tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e := &encoder{}
+	for _, id := range ids {
+		task := s.Tasks[id]
+		e.putString(task.TaskID)
+		e.buf = append(e.buf, byte(task.Status))
+		e.putInt(task.Attempt)
+		e.putInt(task.Failures)
+		e.putString(task.CurrentLeaseID)
+		if lease, ok := s.Leases[task.CurrentLeaseID]; ok {
+			e.putString(lease.WorkerID)
+			e.putTime(lease.Expiry)
+		}
+	}
+
+	return crc32.Checksum(e.buf, crc32.IEEETable)
+}
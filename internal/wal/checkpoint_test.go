@@ -0,0 +1,230 @@
+package wal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// appendCheckpoint snapshots the state of w and appends a checkpoint
+// covering it, returning the checkpoint and the state it covers
+func appendCheckpoint(t *testing.T, w *WAL) (CheckpointPayload, *State) {
+	t.Helper()
+	state, offset, err := w.SnapshotState()
+	if err != nil {
+		t.Fatalf("SnapshotState: %v", err)
+	}
+	cp := CheckpointPayload{Offset: offset, Timestamp: testTime, StateHash: state.Hash()}
+	mustAppend(t, w, Record{Type: RecordTypeCheckpoint, Payload: cp})
+	return cp, state
+}
+
+func TestLastCheckpointIsLatest(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if _, found, err := w.LastCheckpoint(); err != nil || found {
+		t.Fatalf("LastCheckpoint of a log without one = %t, %v", found, err)
+	}
+
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	first, _ := appendCheckpoint(t, w)
+	mustAppend(t, w, taskCompleted("a", "l1"), taskCreated("b"))
+	second, _ := appendCheckpoint(t, w)
+	mustAppend(t, w, leaseGranted("b", "l2", "w", 1))
+
+	got, found, err := w.LastCheckpoint()
+	if err != nil || !found {
+		t.Fatalf("LastCheckpoint = %t, %v", found, err)
+	}
+	if got != second || got == first {
+		t.Fatalf("LastCheckpoint = %+v, want %+v", got, second)
+	}
+}
+
+func TestRecoverFromCheckpoint(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1), taskCreated("b"))
+	cp, persisted := appendCheckpoint(t, w)
+	mustAppend(t, w, taskCompleted("a", "l1"), leaseGranted("b", "l2", "w", 1))
+
+	if persisted.Hash() != cp.StateHash {
+		t.Fatal("persisted state does not match the checkpoint hash")
+	}
+
+	latest, _, err := w.LastCheckpoint()
+	if err != nil {
+		t.Fatalf("LastCheckpoint: %v", err)
+	}
+	if _, err := w.ReplayFrom(latest.Offset, func(record Record) error {
+		return ApplyRecord(record, persisted)
+	}); err != nil {
+		t.Fatalf("ReplayFrom: %v", err)
+	}
+
+	full, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if persisted.Hash() != full.Hash() {
+		t.Fatal("state recovered from the checkpoint differs from a full replay")
+	}
+	if persisted.Tasks["a"].Status != TaskCompleted || persisted.Tasks["b"].Status != TaskLeased {
+		t.Fatalf("recovered statuses = %s, %s", persisted.Tasks["a"].Status, persisted.Tasks["b"].Status)
+	}
+}
+
+func TestStateHashDetectsDivergence(t *testing.T) {
+	a := applyAll(t, taskCreated("t"), leaseGranted("t", "l1", "w", 1))
+	b := applyAll(t, taskCreated("t"), leaseGranted("t", "l1", "w", 1))
+	if a.Hash() != b.Hash() {
+		t.Fatal("equal states hash differently")
+	}
+
+	if err := ApplyRecord(taskCompleted("t", "l1"), b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Hash() == b.Hash() {
+		t.Fatal("diverged states hash the same")
+	}
+}
+
+// replayFromLastCheckpoint returns the checkpoint offset and the records
+// ReplayFromLastCheckpoint replays
+func replayFromLastCheckpoint(t *testing.T, w *WAL) (int64, []Record) {
+	t.Helper()
+	var records []Record
+	offset, err := w.ReplayFromLastCheckpoint(func(record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayFromLastCheckpoint: %v", err)
+	}
+	return offset, records
+}
+
+func TestReplayFromLastCheckpointWithoutCheckpoint(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	mustAppend(t, w, taskCreated("b"))
+
+	// The whole log is replayed, sealed segments included
+	offset, records := replayFromLastCheckpoint(t, w)
+	if offset != 0 {
+		t.Fatalf("offset = %d without a checkpoint, want 0", offset)
+	}
+	if got, want := taskIDs(records), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestReplayFromLastCheckpointSkipsCoveredRecords(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	at := w.Offset()
+	appendCheckpoint(t, w)
+	mustAppend(t, w, taskCreated("c"), taskCreated("d"))
+
+	offset, records := replayFromLastCheckpoint(t, w)
+	if offset != at {
+		t.Fatalf("offset = %d, want the checkpoint's %d", offset, at)
+	}
+	if got, want := taskIDs(records), []string{"c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestReplayFromLastCheckpointUsesLatest(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	appendCheckpoint(t, w)
+	mustAppend(t, w, taskCreated("b"))
+	appendCheckpoint(t, w)
+	mustAppend(t, w, taskCreated("c"))
+	at := w.Offset()
+	appendCheckpoint(t, w)
+
+	// A checkpoint that ends the log leaves nothing to replay
+	offset, records := replayFromLastCheckpoint(t, w)
+	if offset != at || len(records) != 0 {
+		t.Fatalf("ReplayFromLastCheckpoint = %d, %v, want %d and no records", offset, recordTypes(records), at)
+	}
+
+	mustAppend(t, w, taskCreated("d"))
+	w = reopenTestWAL(t, w, Config{})
+	offset, records = replayFromLastCheckpoint(t, w)
+	if offset != at {
+		t.Fatalf("offset after reopen = %d, want %d", offset, at)
+	}
+	if got, want := taskIDs(records), []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v after reopen, want %v", got, want)
+	}
+}
+
+func TestReplayFromLastCheckpointRestoresState(t *testing.T) {
+	w := openTestWAL(t, Config{EncryptionKey: testKey(7)})
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1), taskCreated("b"))
+	_, persisted := appendCheckpoint(t, w)
+	mustAppend(t, w, taskCompleted("a", "l1"), leaseGranted("b", "l2", "w", 1))
+
+	if _, err := w.ReplayFromLastCheckpoint(func(record Record) error {
+		return ApplyRecord(record, persisted)
+	}); err != nil {
+		t.Fatalf("ReplayFromLastCheckpoint: %v", err)
+	}
+	full, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if persisted.Hash() != full.Hash() {
+		t.Fatal("state recovered from the last checkpoint differs from a full replay")
+	}
+}
+
+func TestReplayFromLastCheckpointClosedLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := w.ReplayFromLastCheckpoint(func(Record) error { return nil }); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("ReplayFromLastCheckpoint on a closed log = %v, want ErrWALClosed", err)
+	}
+}
+
+func TestReplayFromLastCheckpointReplaysRecordsAfterSnapshot(t *testing.T) {
+	w := openTestWAL(t, Config{SequenceNumbers: true, StrictSequence: true})
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	persisted, covered, err := w.SnapshotState()
+	if err != nil {
+		t.Fatalf("SnapshotState: %v", err)
+	}
+
+	// Appended after the snapshot but before the checkpoint, so the
+	// persisted state lacks them
+	mustAppend(t, w, taskCreated("b"), taskCompleted("a", "l1"))
+	at := w.Offset()
+	mustAppend(t, w, Record{Type: RecordTypeCheckpoint, Payload: CheckpointPayload{Offset: covered, Timestamp: testTime, StateHash: persisted.Hash()}})
+	mustAppend(t, w, taskCreated("c"))
+
+	offset, records := replayFromLastCheckpoint(t, w)
+	if offset != at {
+		t.Fatalf("offset = %d, want the checkpoint's %d", offset, at)
+	}
+	if got, want := recordTypes(records), []RecordType{RecordTypeTaskCreated, RecordTypeTaskCompleted, RecordTypeTaskCreated}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+	for _, record := range records {
+		if err := ApplyRecord(record, persisted); err != nil {
+			t.Fatalf("ApplyRecord: %v", err)
+		}
+	}
+	full, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if persisted.Hash() != full.Hash() {
+		t.Fatal("state recovered from the checkpoint differs from a full replay")
+	}
+}
@@ -0,0 +1,258 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ChecksumType selects the algorithm protecting each record frame
+// The algorithm is recorded in the file header, so a log is always verified
+// with the algorithm it was written with
+type ChecksumType uint8
+
+const (
+	ChecksumCRC32    ChecksumType = iota + 1 // CRC32 (IEEE), 4 bytes; the default
+	ChecksumCRC32C                           // CRC32 (Castagnoli), 4 bytes
+	ChecksumXXHash64                         // xxHash64 with seed 0, 8 bytes
+)
+
+// ChecksumScope selects which part of each record frame the checksum
+// covers. The scope is recorded in the file header
+type ChecksumScope uint8
+
+const (
+	// ChecksumScopePayload covers the type byte, sequence number and
+	// payload; the default
+	ChecksumScopePayload ChecksumScope = iota
+	// ChecksumScopeFrame also covers the length prefix, so a corrupted
+	// length fails the checksum instead of only being bounds-checked
+	ChecksumScopeFrame
+)
+
+// valid reports whether s is a known scope
+func (s ChecksumScope) valid() bool {
+	return s == ChecksumScopePayload || s == ChecksumScopeFrame
+}
+
+// covered returns the part of frame the checksum is computed over, given
+// the frame from its length prefix up to, not including, the checksum
+func (s ChecksumScope) covered(frame []byte) []byte {
+	if s == ChecksumScopeFrame {
+		return frame
+	}
+	return frame[lengthPrefixSize:]
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// valid reports whether c is a known algorithm
+func (c ChecksumType) valid() bool {
+	return c >= ChecksumCRC32 && c <= ChecksumXXHash64
+}
+
+// size returns the width of the checksum field in bytes
+func (c ChecksumType) size() int {
+	if c == ChecksumXXHash64 {
+		return 8
+	}
+	return 4
+}
+
+// appendSum appends the little-endian checksum of data to dst
+func (c ChecksumType) appendSum(dst, data []byte) []byte {
+	switch c {
+	case ChecksumCRC32C:
+		return binary.LittleEndian.AppendUint32(dst, crc32.Checksum(data, castagnoliTable))
+	case ChecksumXXHash64:
+		return binary.LittleEndian.AppendUint64(dst, xxhash64(data))
+	default:
+		return binary.LittleEndian.AppendUint32(dst, crc32.ChecksumIEEE(data))
+	}
+}
+
+// digest returns a hash computing the same checksum as appendSum, for
+// data written in several pieces
+func (c ChecksumType) digest() hash.Hash {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoliTable)
+	case ChecksumXXHash64:
+		return &xxDigest{}
+	default:
+		return crc32.NewIEEE()
+	}
+}
+
+// appendDigest appends the little-endian checksum held by h, a hash
+// returned by digest, to dst
+func appendDigest(dst []byte, h hash.Hash) []byte {
+	if h64, ok := h.(hash.Hash64); ok {
+		return binary.LittleEndian.AppendUint64(dst, h64.Sum64())
+	}
+	return binary.LittleEndian.AppendUint32(dst, h.(hash.Hash32).Sum32())
+}
+
+// verify reports whether sum is the checksum of data
+func (c ChecksumType) verify(data, sum []byte) bool {
+	var buf [8]byte
+	return bytes.Equal(c.appendSum(buf[:0], data), sum)
+}
+
+// xxHash64 primes
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 computes xxHash64 of b with seed 0
+func xxhash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+
+	if n >= 32 {
+		// The seeded accumulators wrap around, so they are computed at runtime
+		prime1, prime2 := xxPrime1, xxPrime2
+		v1 := prime1 + prime2
+		v2 := prime2
+		v3 := uint64(0)
+		v4 := -prime1
+		for len(b) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+			b = b[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+
+	h += uint64(n)
+
+	return xxFinalize(h, b)
+}
+
+// xxFinalize mixes the final, fewer than 32, bytes b into h and applies
+// the avalanche
+func xxFinalize(h uint64, b []byte) uint64 {
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+
+	return h
+}
+
+// xxDigest computes xxHash64 with seed 0 incrementally, as hash.Hash64
+type xxDigest struct {
+	v1, v2, v3, v4 uint64
+	total          uint64   // bytes written
+	mem            [32]byte // bytes not yet consumed by a round
+	n              int      // valid bytes in mem
+}
+
+// Reset restores the digest to its initial state
+func (d *xxDigest) Reset() {
+	*d = xxDigest{}
+}
+
+// Size returns the number of bytes Sum appends
+func (d *xxDigest) Size() int { return 8 }
+
+// BlockSize returns the size of a round
+func (d *xxDigest) BlockSize() int { return 32 }
+
+// Write adds b to the running hash. It never fails
+func (d *xxDigest) Write(b []byte) (int, error) {
+	n := len(b)
+	if d.total == 0 && d.n == 0 {
+		prime1, prime2 := xxPrime1, xxPrime2
+		d.v1, d.v2, d.v3, d.v4 = prime1+prime2, prime2, 0, -prime1
+	}
+	d.total += uint64(n)
+
+	if d.n+len(b) < 32 {
+		d.n += copy(d.mem[d.n:], b)
+		return n, nil
+	}
+	if d.n > 0 {
+		b = b[copy(d.mem[d.n:], b):]
+		d.round(d.mem[:])
+		d.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		d.round(b)
+	}
+	d.n = copy(d.mem[:], b)
+
+	return n, nil
+}
+
+// round consumes the first 32 bytes of b
+func (d *xxDigest) round(b []byte) {
+	d.v1 = xxRound(d.v1, binary.LittleEndian.Uint64(b[0:8]))
+	d.v2 = xxRound(d.v2, binary.LittleEndian.Uint64(b[8:16]))
+	d.v3 = xxRound(d.v3, binary.LittleEndian.Uint64(b[16:24]))
+	d.v4 = xxRound(d.v4, binary.LittleEndian.Uint64(b[24:32]))
+}
+
+// Sum64 returns the hash of the bytes written so far
+func (d *xxDigest) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v1, 1) + bits.RotateLeft64(d.v2, 7) +
+			bits.RotateLeft64(d.v3, 12) + bits.RotateLeft64(d.v4, 18)
+		h = xxMergeRound(h, d.v1)
+		h = xxMergeRound(h, d.v2)
+		h = xxMergeRound(h, d.v3)
+		h = xxMergeRound(h, d.v4)
+	} else {
+		h = xxPrime5
+	}
+	h += d.total
+
+	return xxFinalize(h, d.mem[:d.n])
+}
+
+// Sum appends the big-endian hash to b, as hash.Hash requires
+func (d *xxDigest) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, d.Sum64())
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
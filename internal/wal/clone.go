@@ -0,0 +1,53 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// CloneTo copies the log, sealed segments included, to a new log at path,
+// which must not exist, so that it can be opened as an independent WAL
+// The copy is taken with the lock held after flushing, so it holds exactly
+// the records appended so far; records still unsynced in this log are
+// durable in the copy. Archived segments are not copied
+func (w *WAL) CloneTo(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("clone: %s already exists", filepath.Base(path))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("clone: %w", err)
+	}
+
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		seq, err := segmentSeq(segment)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(segment, segmentPath(path, seq)); err != nil {
+			return fmt.Errorf("clone: segment %s: %w", filepath.Base(segment), err)
+		}
+	}
+
+	if err := writeFileAtomic(path, io.NewSectionReader(w.readFile, 0, w.offset)); err != nil {
+		return fmt.Errorf("clone: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package wal
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCloneToForksIndependentLogs(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("shared"))
+
+	clonePath := filepath.Join(t.TempDir(), "clone.wal")
+	if err := w.CloneTo(clonePath); err != nil {
+		t.Fatalf("CloneTo: %v", err)
+	}
+	clone := openTestWAL(t, Config{FilePath: clonePath})
+
+	mustAppend(t, w, taskCreated("original"))
+	mustAppend(t, clone, taskCreated("clone"), taskCreated("clone-2"))
+
+	if got, want := taskIDs(mustReplay(t, w)), []string{"shared", "original"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("original holds %v, want %v", got, want)
+	}
+	if got, want := taskIDs(mustReplay(t, clone)), []string{"shared", "clone", "clone-2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("clone holds %v, want %v", got, want)
+	}
+
+	// Each fork survives a reopen on its own
+	w = reopenTestWAL(t, w, Config{})
+	clone = reopenTestWAL(t, clone, Config{})
+	if got := len(mustReplay(t, w)); got != 2 {
+		t.Fatalf("original replayed %d records after reopen, want 2", got)
+	}
+	if got := len(mustReplay(t, clone)); got != 3 {
+		t.Fatalf("clone replayed %d records after reopen, want 3", got)
+	}
+}
+
+func TestCloneToIncludesUnsyncedRecords(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, syncer: syncer})
+	mustAppend(t, w, taskCreated("t0"), taskCreated("t1"), taskCreated("t2"))
+	if syncer.count() != 0 {
+		t.Fatalf("log synced %d times before the clone, want 0", syncer.count())
+	}
+
+	clonePath := filepath.Join(t.TempDir(), "clone.wal")
+	if err := w.CloneTo(clonePath); err != nil {
+		t.Fatalf("CloneTo: %v", err)
+	}
+	clone := openTestWAL(t, Config{FilePath: clonePath})
+	if got, want := taskIDs(mustReplay(t, clone)), []string{"t0", "t1", "t2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("clone holds %v, want %v", got, want)
+	}
+}
+
+func TestCloneToCopiesSealedSegments(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("t0"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	mustAppend(t, w, taskCreated("t1"))
+
+	clonePath := filepath.Join(t.TempDir(), "clone.wal")
+	if err := w.CloneTo(clonePath); err != nil {
+		t.Fatalf("CloneTo: %v", err)
+	}
+	clone := openTestWAL(t, Config{FilePath: clonePath})
+	if got, want := taskIDs(mustReplay(t, clone)), []string{"t0", "t1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("clone holds %v, want %v", got, want)
+	}
+}
+
+func TestCloneToRejectsExistingPath(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("t0"))
+
+	other := openTestWAL(t, Config{})
+	mustAppend(t, other, taskCreated("other"))
+
+	if err := w.CloneTo(other.filePath); err == nil {
+		t.Fatal("CloneTo over an existing log succeeded")
+	}
+	if got, want := taskIDs(mustReplay(t, other)), []string{"other"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("existing log holds %v after a rejected clone, want %v", got, want)
+	}
+}
+
+func TestCloneToClosedLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.CloneTo(filepath.Join(t.TempDir(), "clone.wal")); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("CloneTo on a closed log = %v, want ErrWALClosed", err)
+	}
+}
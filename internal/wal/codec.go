@@ -0,0 +1,40 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// defaultFrameHeader is the framing of a file written with a default Config:
+// CRC32 checksums and binary payloads
+var defaultFrameHeader = newFileHeader(ChecksumCRC32, EncodingBinary, false, ChecksumScopePayload)
+
+// EncodeRecord frames a single record as Append would with a default Config:
+// binary payload, no compression or encryption, CRC32 checksum
+// The result can be parsed by DecodeRecord without a WAL
+func EncodeRecord(record Record) ([]byte, error) {
+	if err := ValidateRecord(record); err != nil {
+		return nil, err
+	}
+
+	data, err := (&WAL{maxRecordSize: DefaultMaxRecordSize}).encodeRecordWith(defaultFrameHeader, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	return data, nil
+}
+
+// DecodeRecord parses the record framed at the front of data, as written by
+// EncodeRecord, and verifies its checksum
+// Returns the record and the number of bytes consumed, so a buffer of
+// concatenated frames can be decoded in a loop. Empty data returns io.EOF
+// and a frame cut short returns ErrPartialWrite
+func DecodeRecord(data []byte) (Record, int, error) {
+	record, size, err := readRecord(bytes.NewReader(data), defaultFrameHeader.frameFormat())
+	if err != nil {
+		return Record{}, int(size), err
+	}
+
+	return record, int(size), nil
+}
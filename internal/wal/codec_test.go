@@ -0,0 +1,75 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeRecordConcatenated(t *testing.T) {
+	records := sampleRecords()
+	var buf []byte
+	for _, record := range records {
+		data, err := EncodeRecord(record)
+		if err != nil {
+			t.Fatalf("EncodeRecord %s: %v", record.Type, err)
+		}
+		buf = append(buf, data...)
+	}
+
+	var decoded []Record
+	for data := buf; ; {
+		record, n, err := DecodeRecord(data)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("DecodeRecord after %d records: %v", len(decoded), err)
+		}
+		decoded = append(decoded, record)
+		data = data[n:]
+	}
+	if !reflect.DeepEqual(decoded, records) {
+		t.Fatalf("decoded %v, want %v", decoded, records)
+	}
+}
+
+func TestDecodeRecordMatchesLogFrames(t *testing.T) {
+	record := taskCreated("a")
+	data, err := EncodeRecord(stampRecord(record, testTime))
+	if err != nil {
+		t.Fatalf("EncodeRecord: %v", err)
+	}
+
+	// A default log frames records the same way
+	w := openTestWAL(t, Config{})
+	offset, err := w.AppendAt(record)
+	if err != nil {
+		t.Fatalf("AppendAt: %v", err)
+	}
+	if w.Offset()-offset != int64(len(data)) {
+		t.Fatalf("log frame is %d bytes, EncodeRecord %d", w.Offset()-offset, len(data))
+	}
+}
+
+func TestDecodeRecordErrors(t *testing.T) {
+	data, err := EncodeRecord(taskCreated("a"))
+	if err != nil {
+		t.Fatalf("EncodeRecord: %v", err)
+	}
+
+	if _, _, err := DecodeRecord(data[:len(data)-1]); !errors.Is(err, ErrPartialWrite) {
+		t.Fatalf("DecodeRecord of a cut frame = %v, want ErrPartialWrite", err)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[lengthPrefixSize+recordTypeSize+1] ^= 0xff
+	if _, _, err := DecodeRecord(corrupt); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("DecodeRecord of a flipped byte = %v, want ErrInvalidChecksum", err)
+	}
+
+	if _, err := EncodeRecord(taskCompleted("", "")); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("EncodeRecord of an invalid record = %v, want ErrInvalidRecord", err)
+	}
+}
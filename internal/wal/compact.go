@@ -0,0 +1,271 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Compact rewrites the log without the records of tasks that reached a
+// terminal state (completed, failed with no retries left, or dead)
+// Surviving records keep their relative order. The new log is written to a
+// temporary file and atomically renamed over the original, with the lock
+// held throughout so no append can interleave
+// TaskCancelled is not terminal: it records authority loss only and leaves
+// the task live
+// Records from the offset the latest checkpoint covers on are all kept,
+// along with the earlier records of their tasks, and checkpoint offsets are
+// moved to where those records land, so ReplayFrom(checkpoint.Offset) still
+// recovers from that checkpoint
+// Sequence numbers of the kept records are renumbered densely from the
+// first one in the log, so StrictSequence replay sees no gap
+// Compaction of a log with sealed segments is rejected, since replacing
+// several files cannot be made atomic
+func (w *WAL) Compact() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waitSyncLocked()
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		return ErrSegmentedLog
+	}
+
+	var records []Record
+	var offsets []int64
+	if _, err := w.replayFromLocked(context.Background(), 0, func(record Record, offset, _ int64) error {
+		records = append(records, record)
+		offsets = append(offsets, offset)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to read log for compaction: %w", err)
+	}
+
+	terminal := terminalTasks(records)
+	for taskID := range checkpointTasks(records, recoveryStart(records, offsets)) {
+		delete(terminal, taskID)
+	}
+	leaseTasks := make(map[string]string) // lease ID -> task ID
+	moved := make(map[int64]int64)        // old record offset -> new one
+
+	tmpPath := w.filePath + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create compaction file: %w", err)
+	}
+	if _, err := tmp.Write(w.header.encode()); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write compacted header: %w", err)
+	}
+
+	offset := int64(headerSize)
+	var seq uint64 // next sequence number, 0 until the first sequenced record
+	for i, record := range records {
+		if seq == 0 {
+			seq = record.Seq
+		}
+		// A dropped record's offset moves to the next record kept
+		moved[offsets[i]] = offset
+		taskID, ok := recordTaskID(record, leaseTasks)
+		if ok && terminal[taskID] {
+			continue
+		}
+
+		if p, ok := record.Payload.(CheckpointPayload); ok {
+			if newOffset, ok := moved[p.Offset]; ok {
+				p.Offset = newOffset
+				record.Payload = p
+			}
+		}
+		if record.Seq != 0 {
+			record.Seq = seq
+			seq++
+		}
+		data, err := w.encodeRecord(record)
+		if err == nil {
+			_, err = tmp.Write(data)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to write compacted record: %w", err)
+		}
+		offset += int64(len(data))
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync compacted log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close compacted log: %w", err)
+	}
+
+	if err := w.replaceActiveLocked(tmpPath); err != nil {
+		return err
+	}
+	if seq != 0 {
+		w.nextSeq = seq
+	}
+	// Dropped tasks must be forgotten as they would be on reopen
+	if w.requestIDs != nil {
+		if err := w.loadRequestIDsLocked(); err != nil {
+			return err
+		}
+	}
+	if w.state != nil {
+		return w.loadStateLocked()
+	}
+
+	return nil
+}
+
+// terminalTasks returns the IDs of tasks that reached a terminal state
+func terminalTasks(records []Record) map[string]bool {
+	terminal := make(map[string]bool)
+	maxRetries := make(map[string]int)
+	failures := make(map[string]int)
+
+	for _, record := range records {
+		switch p := record.Payload.(type) {
+		case TaskCreatedPayload:
+			maxRetries[p.TaskID] = p.RetryPolicy.MaxRetries
+		case TaskCompletedPayload:
+			terminal[p.TaskID] = true
+		case TaskFailedPayload:
+			failures[p.TaskID]++
+			if failures[p.TaskID] > maxRetries[p.TaskID] {
+				terminal[p.TaskID] = true
+			}
+		case TaskDeadPayload:
+			terminal[p.TaskID] = true
+		}
+	}
+
+	return terminal
+}
+
+// recoveryStart returns the index of the first record that recovery from
+// the latest checkpoint replays: the first one at or after the offset the
+// checkpoint covers, or the one after the checkpoint if that comes first
+// Without a checkpoint it returns len(records)
+func recoveryStart(records []Record, offsets []int64) int {
+	last := -1
+	for i, record := range records {
+		if record.Type == RecordTypeCheckpoint {
+			last = i
+		}
+	}
+	if last < 0 {
+		return len(records)
+	}
+
+	covered := records[last].Payload.(CheckpointPayload).Offset
+	for i := 0; i <= last; i++ {
+		if offsets[i] >= covered {
+			return i
+		}
+	}
+	return last + 1
+}
+
+// checkpointTasks returns the tasks with records from index start on
+func checkpointTasks(records []Record, start int) map[string]bool {
+	// Every record is resolved, so lease IDs granted before start are known
+	tasks := make(map[string]bool)
+	leaseTasks := make(map[string]string)
+	for i, record := range records {
+		taskID, ok := recordTaskID(record, leaseTasks)
+		if ok && i >= start {
+			tasks[taskID] = true
+		}
+	}
+
+	return tasks
+}
+
+// recordTaskID returns the task a record belongs to
+// LeaseExtended carries only a lease ID, so leaseTasks is filled in from
+// LeaseGranted records as they are seen and used to resolve it
+func recordTaskID(record Record, leaseTasks map[string]string) (string, bool) {
+	switch p := record.Payload.(type) {
+	case TaskCreatedPayload:
+		return p.TaskID, true
+	case TaskCompletedPayload:
+		return p.TaskID, true
+	case TaskFailedPayload:
+		return p.TaskID, true
+	case TaskCancelledPayload:
+		return p.TaskID, true
+	case TaskDeadPayload:
+		return p.TaskID, true
+	case TaskRetriedPayload:
+		return p.TaskID, true
+	case TaskRescheduledPayload:
+		return p.TaskID, true
+	case LeaseGrantedPayload:
+		leaseTasks[p.LeaseID] = p.TaskID
+		return p.TaskID, true
+	case LeaseExpiredPayload:
+		return p.TaskID, true
+	case LeaseRenewalDeniedPayload:
+		return p.TaskID, true
+	case LeaseExtendedPayload:
+		taskID, ok := leaseTasks[p.LeaseID]
+		return taskID, ok
+	}
+
+	return "", false
+}
+
+// replaceActiveLocked atomically renames path over the active file and
+// reopens it for appending. The previous handle is closed
+// Any buffered records must have been flushed by the caller
+// Callers must hold w.mu
+func (w *WAL) replaceActiveLocked(path string) error {
+	if err := os.Rename(path, w.filePath); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to install new log: %w", err)
+	}
+	if err := syncDir(filepath.Dir(w.filePath)); err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+
+	file, err := os.OpenFile(w.filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	header, size, err := initFile(file, w.header)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file.Close()
+	w.file = file
+	w.writer.Reset(file)
+	w.offset = size
+	w.header = header
+	w.synced = w.appended
+	w.syncedOffset = size
+	w.decodeCache.clear()
+	if err := w.saveManifestLocked(); err != nil {
+		return err
+	}
+
+	if err := w.openReadFileLocked(); err != nil {
+		return err
+	}
+	return w.preallocateLocked()
+}
@@ -0,0 +1,205 @@
+package wal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompactDropsTerminalTasks(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("done1"),
+		taskCreated("live"),
+		leaseGranted("done1", "l1", "w1", 1),
+		taskCreated("done2"),
+		leaseGranted("live", "l2", "w1", 1),
+		taskCompleted("done1", "l1"),
+		leaseGranted("done2", "l3", "w2", 1),
+		leaseExtended("l3", testTime.Add(5*time.Minute)),
+		taskCompleted("done2", "l3"),
+		leaseExtended("l2", testTime.Add(5*time.Minute)),
+	)
+	before := w.Offset()
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	records := mustReplay(t, w)
+	want := []RecordType{RecordTypeTaskCreated, RecordTypeLeaseGranted, RecordTypeLeaseExtended}
+	if got := recordTypes(records); !reflect.DeepEqual(got, want) {
+		t.Fatalf("compacted log holds %v, want %v", got, want)
+	}
+	if got := records[0].Payload.(TaskCreatedPayload).TaskID; got != "live" {
+		t.Fatalf("surviving task = %s, want live", got)
+	}
+	if got := records[2].Payload.(LeaseExtendedPayload).LeaseID; got != "l2" {
+		t.Fatalf("surviving extension is for lease %s, want l2", got)
+	}
+	if w.Offset() >= before {
+		t.Fatalf("offset %d after compaction, was %d", w.Offset(), before)
+	}
+
+	// Appends continue on the compacted log, and it survives reopening
+	mustAppend(t, w, taskCompleted("live", "l2"))
+	w = reopenTestWAL(t, w, Config{})
+	if got := len(mustReplay(t, w)); got != 4 {
+		t.Fatalf("replayed %d records after reopening, want 4", got)
+	}
+}
+
+func TestCompactKeepsRetryableAndCancelledTasks(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("retry"), // MaxRetries 1
+		leaseGranted("retry", "l1", "w1", 1),
+		taskFailed("retry", "l1", "boom"),
+		taskCreated("cancel"),
+		leaseGranted("cancel", "l2", "w1", 1),
+		taskCancelled("cancel", "l2"),
+		taskCreated("dead"),
+		taskDead("dead", "poison"),
+	)
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got := len(mustReplay(t, w)); got != 6 {
+		t.Fatalf("compacted log holds %d records, want the 6 of the retryable and cancelled tasks", got)
+	}
+}
+
+func TestCompactRejectsSegmentedLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	if err := w.Compact(); !errors.Is(err, ErrSegmentedLog) {
+		t.Fatalf("Compact = %v, want ErrSegmentedLog", err)
+	}
+}
+
+// recoverFrom applies the records from the latest checkpoint on to state
+// and returns them, less checkpoints, whose offsets compaction moves
+func recoverFrom(t *testing.T, w *WAL, state *State) []Record {
+	t.Helper()
+	cp, found, err := w.LastCheckpoint()
+	if err != nil || !found {
+		t.Fatalf("LastCheckpoint = %t, %v", found, err)
+	}
+	var records []Record
+	if _, err := w.ReplayFrom(cp.Offset, func(record Record) error {
+		if record.Type != RecordTypeCheckpoint {
+			records = append(records, record)
+		}
+		return ApplyRecord(record, state)
+	}); err != nil {
+		t.Fatalf("ReplayFrom(%d): %v", cp.Offset, err)
+	}
+	return records
+}
+
+func TestCompactKeepsRecoveryFromCheckpoint(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	covered := []Record{
+		taskCreated("old"),
+		leaseGranted("old", "l0", "w", 1),
+		taskCompleted("old", "l0"),
+		taskCreated("a"),
+		leaseGranted("a", "l1", "w", 1),
+	}
+	mustAppend(t, w, covered...)
+	appendCheckpoint(t, w)
+	mustAppend(t, w,
+		taskCreated("b"),
+		leaseGranted("b", "l2", "w", 1),
+		taskCompleted("b", "l2"),
+		taskCompleted("a", "l1"),
+	)
+	want := recoverFrom(t, w, applyAll(t, covered...))
+	full, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	before := w.Offset()
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if w.Offset() >= before {
+		t.Fatal("compaction dropped nothing before the checkpoint")
+	}
+
+	// The terminal tasks after the checkpoint survive, and the moved
+	// checkpoint offset still leads to them, also after reopening
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			w = reopenTestWAL(t, w, Config{})
+		}
+		persisted := applyAll(t, covered...)
+		if got := recoverFrom(t, w, persisted); !reflect.DeepEqual(got, want) || len(got) != 4 {
+			t.Fatalf("reopened %t: recovered %v after compaction, want %v", reopen, recordTypes(got), recordTypes(want))
+		}
+		if persisted.Hash() != full.Hash() {
+			t.Fatalf("reopened %t: state recovered from the checkpoint differs from a full replay before compaction", reopen)
+		}
+	}
+}
+
+func TestCompactKeepsRecordsCoveredByCheckpointOffset(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	covered := []Record{taskCreated("old"), taskDead("old", "poison"), taskCreated("a")}
+	mustAppend(t, w, covered...)
+
+	// Records appended between the snapshot and its checkpoint record are
+	// replayed on recovery, so they are kept even for terminal tasks
+	offset := w.Offset()
+	mustAppend(t, w, taskCreated("late"), taskDead("late", "poison"), checkpoint(offset))
+	want := recoverFrom(t, w, applyAll(t, covered...))
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	got := recoverFrom(t, w, applyAll(t, covered...))
+	if !reflect.DeepEqual(got, want) || len(got) != 2 {
+		t.Fatalf("recovered %v after compaction, want %v", recordTypes(got), recordTypes(want))
+	}
+	if first := mustReplay(t, w)[0].Payload.(TaskCreatedPayload).TaskID; first != "a" {
+		t.Fatalf("compacted log starts with task %s, want the terminal task before the checkpoint dropped", first)
+	}
+}
+
+func TestCompactRenumbersSequence(t *testing.T) {
+	config := Config{SequenceNumbers: true, StrictSequence: true}
+	w := openTestWAL(t, config)
+	mustAppend(t, w,
+		taskCreated("done"),
+		taskCreated("live"),
+		leaseGranted("done", "l1", "w1", 1),
+		taskCompleted("done", "l1"),
+		leaseGranted("live", "l2", "w1", 1),
+	)
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got, want := seqs(mustReplay(t, w)), []uint64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sequence numbers after Compact = %v, want %v", got, want)
+	}
+
+	// Appends continue the dense numbering, and a strict replay of the
+	// reopened log finds no gap
+	mustAppend(t, w, taskCompleted("live", "l2"))
+	w = reopenTestWAL(t, w, config)
+	records := mustReplay(t, w)
+	if got, want := seqs(records), []uint64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sequence numbers after reopening = %v, want %v", got, want)
+	}
+	if gaps, err := w.DetectGaps(); err != nil || len(gaps) != 0 {
+		t.Fatalf("DetectGaps after Compact = %v, %v", gaps, err)
+	}
+}
@@ -0,0 +1,107 @@
+package wal
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// CompareLogs reports whether the logs at a and b, sealed segments
+// included, rebuild the same live coordinator state, e.g. before and after
+// Compact or a migration. Tasks in a terminal state are ignored, since
+// compaction drops them, as are creation and grant timestamps
+// When the logs differ, the string describes the first divergence found,
+// in task ID and then lease ID order
+func CompareLogs(a, b string) (bool, string, error) {
+	stateA, err := RebuildState(a)
+	if err != nil {
+		return false, "", fmt.Errorf("compare: %s: %w", filepath.Base(a), err)
+	}
+	stateB, err := RebuildState(b)
+	if err != nil {
+		return false, "", fmt.Errorf("compare: %s: %w", filepath.Base(b), err)
+	}
+
+	if diff := compareStates(stateA, stateB); diff != "" {
+		return false, diff, nil
+	}
+	return true, "", nil
+}
+
+// compareStates describes the first difference between the live tasks and
+// leases of a and b, or returns "" if there is none
+func compareStates(a, b *State) string {
+	tasksA, tasksB := liveTasks(a), liveTasks(b)
+	for _, id := range unionKeys(tasksA, tasksB) {
+		taskA, okA := tasksA[id]
+		taskB, okB := tasksB[id]
+		switch {
+		case !okA:
+			return fmt.Sprintf("task %s is live only in the second log", id)
+		case !okB:
+			return fmt.Sprintf("task %s is live only in the first log", id)
+		case !reflect.DeepEqual(taskA, taskB):
+			return fmt.Sprintf("task %s differs: %+v vs %+v", id, taskA, taskB)
+		}
+	}
+
+	leasesA, leasesB := normalizeLeases(a), normalizeLeases(b)
+	for _, id := range unionKeys(leasesA, leasesB) {
+		leaseA, okA := leasesA[id]
+		leaseB, okB := leasesB[id]
+		switch {
+		case !okA:
+			return fmt.Sprintf("lease %s is active only in the second log", id)
+		case !okB:
+			return fmt.Sprintf("lease %s is active only in the first log", id)
+		case !reflect.DeepEqual(leaseA, leaseB):
+			return fmt.Sprintf("lease %s differs: %+v vs %+v", id, leaseA, leaseB)
+		}
+	}
+
+	return ""
+}
+
+// liveTasks returns copies of the non-terminal tasks of state, without
+// their creation time
+func liveTasks(state *State) map[string]Task {
+	tasks := make(map[string]Task)
+	for id, task := range state.Tasks {
+		if task.Status.Terminal() {
+			continue
+		}
+		t := *task
+		t.CreatedAt = time.Time{}
+		tasks[id] = t
+	}
+	return tasks
+}
+
+// normalizeLeases returns copies of the leases of state, without their
+// grant time
+func normalizeLeases(state *State) map[string]Lease {
+	leases := make(map[string]Lease, len(state.Leases))
+	for id, lease := range state.Leases {
+		l := *lease
+		l.GrantedAt = time.Time{}
+		leases[id] = l
+	}
+	return leases
+}
+
+// unionKeys returns the keys of a and b, sorted
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,121 @@
+package wal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// compareLogs returns the result of CompareLogs, failing on an error
+func compareLogs(t *testing.T, a, b string) (bool, string) {
+	t.Helper()
+	equal, diff, err := CompareLogs(a, b)
+	if err != nil {
+		t.Fatalf("CompareLogs: %v", err)
+	}
+	return equal, diff
+}
+
+// mixedLog appends live and terminal tasks to w
+func mixedLog(t *testing.T, w *WAL) {
+	t.Helper()
+	mustAppend(t, w,
+		taskCreated("done"),
+		leaseGranted("done", "l1", "w", 1),
+		taskCompleted("done", "l1"),
+		taskCreated("leased"),
+		leaseGranted("leased", "l2", "w", 1),
+		leaseExtended("l2", testTime.Add(time.Hour)),
+		taskCreated("dead"),
+		taskDead("dead", "operator"),
+		taskCreated("waiting"),
+	)
+}
+
+func TestCompareLogsIdentical(t *testing.T) {
+	a := closedTestLog(t, Config{}, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	b := closedTestLog(t, Config{}, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	if equal, diff := compareLogs(t, a, b); !equal || diff != "" {
+		t.Fatalf("identical logs compare unequal: %s", diff)
+	}
+	if equal, _ := compareLogs(t, a, a); !equal {
+		t.Fatal("a log compares unequal to itself")
+	}
+}
+
+func TestCompareLogsCompactedEqualsOriginal(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mixedLog(t, w)
+	original := copyLog(t, w)
+	before, err := w.CountRecords()
+	if err != nil {
+		t.Fatalf("CountRecords: %v", err)
+	}
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	after, err := w.CountRecords()
+	if err != nil {
+		t.Fatalf("CountRecords: %v", err)
+	}
+	if after >= before {
+		t.Fatalf("compaction kept %d of %d records", after, before)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	compacted := w.filePath
+
+	if equal, diff := compareLogs(t, original, compacted); !equal {
+		t.Fatalf("compacted log differs from the original: %s", diff)
+	}
+}
+
+func TestCompareLogsIgnoresTimestamps(t *testing.T) {
+	a := closedTestLog(t, Config{}, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	later := func() time.Time { return testTime.Add(time.Hour) }
+	b := closedTestLog(t, Config{Clock: later}, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	if equal, diff := compareLogs(t, a, b); !equal {
+		t.Fatalf("logs differing only in timestamps compare unequal: %s", diff)
+	}
+}
+
+func TestCompareLogsReportsDivergence(t *testing.T) {
+	base := []Record{taskCreated("a"), taskCreated("b"), leaseGranted("a", "l1", "w", 1)}
+	tests := []struct {
+		name string
+		b    []Record
+		want string // in the divergence
+	}{
+		{"missing task", base[:2], "task a differs"},
+		{"extra task", append(base[:3:3], taskCreated("c")), "task c is live only in the second log"},
+		{"other lease", []Record{taskCreated("a"), taskCreated("b"), leaseGranted("a", "l9", "w", 1)}, "task a differs"},
+		{"other worker", []Record{taskCreated("a"), taskCreated("b"), leaseGranted("a", "l1", "w2", 1)}, "lease l1 differs"},
+	}
+	a := closedTestLog(t, Config{}, base...)
+	for _, tt := range tests {
+		b := closedTestLog(t, Config{}, tt.b...)
+		equal, diff := compareLogs(t, a, b)
+		if equal || !strings.Contains(diff, tt.want) {
+			t.Errorf("%s: CompareLogs = %t, %q, want a divergence containing %q", tt.name, equal, diff, tt.want)
+		}
+	}
+
+	// A task finished in one log only is live in the other
+	done := closedTestLog(t, Config{}, append(base[:3:3], taskCompleted("a", "l1"))...)
+	if equal, diff := compareLogs(t, a, done); equal || !strings.Contains(diff, "task a is live only in the first log") {
+		t.Errorf("CompareLogs against a completed task = %t, %q", equal, diff)
+	}
+}
+
+func TestCompareLogsErrors(t *testing.T) {
+	a := closedTestLog(t, Config{}, taskCreated("a"))
+	bad := closedTestLog(t, Config{}, taskCompleted("ghost", "l1"))
+	if _, _, err := CompareLogs(a, bad); err == nil || !strings.Contains(err.Error(), "compare") {
+		t.Fatalf("CompareLogs with an invalid log = %v, want an error", err)
+	}
+	if _, _, err := CompareLogs(a, testPath(t)); err == nil {
+		t.Fatal("CompareLogs with a missing log succeeded")
+	}
+}
@@ -0,0 +1,67 @@
+package wal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Record flags
+//
+// The high bits of the record type byte carry per-record encoding flags, so
+// a single log may mix plain and transformed records. The checksum covers
+// the payload bytes as stored, after any transformation
+const (
+	recordFlagCompressed = 0x80 // payload is gzip-compressed
+	recordFlagMask       = recordFlagCompressed | recordFlagEncrypted
+)
+
+// DefaultCompressMinBytes is the task payload size above which payloads are
+// compressed when Config.CompressPayloads is set and no threshold is given
+const DefaultCompressMinBytes = 4 * 1024
+
+// shouldCompress reports whether the record's payload is worth compressing
+func (w *WAL) shouldCompress(record Record) bool {
+	if !w.compressPayloads {
+		return false
+	}
+
+	p, ok := record.Payload.(TaskCreatedPayload)
+	return ok && len(p.Payload) >= w.compressMinBytes
+}
+
+// compressPayload gzips a serialized payload
+func compressPayload(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressPayload reverses compressPayload
+// Output beyond limit bytes fails with ErrRecordTooLarge, so a corrupt or
+// hostile record that passes its checksum cannot inflate without bound
+func decompressPayload(data []byte, limit int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress payload: %v", ErrCorruptedLog, err)
+	}
+	defer zr.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress payload: %v", ErrCorruptedLog, err)
+	}
+	if len(payload) > limit {
+		return nil, fmt.Errorf("%w: decompressed payload exceeds limit %d", ErrRecordTooLarge, limit)
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,129 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// bigTask returns a TaskCreated record with a compressible payload of n bytes
+func bigTask(taskID string, n int) Record {
+	record := taskCreated(taskID)
+	p := record.Payload.(TaskCreatedPayload)
+	p.Payload = bytes.Repeat([]byte("scheduled work item "), n/20+1)[:n]
+	record.Payload = p
+	return record
+}
+
+func TestCompressPayloadsShrinksAndRoundTrips(t *testing.T) {
+	const size = 1 << 20
+	record := stampRecord(bigTask("big", size), testTime)
+
+	w := openTestWAL(t, Config{CompressPayloads: true})
+	mustAppend(t, w, record)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() >= size/10 {
+		t.Fatalf("compressed log is %d bytes for a %d byte payload", stat.Size(), size)
+	}
+
+	w = reopenTestWAL(t, w, Config{})
+	records := mustReplay(t, w)
+	if len(records) != 1 {
+		t.Fatalf("replayed %d records, want 1", len(records))
+	}
+	got := records[0].Payload.(TaskCreatedPayload).Payload
+	if !bytes.Equal(got, record.Payload.(TaskCreatedPayload).Payload) {
+		t.Fatalf("decoded payload differs: %d bytes, want %d", len(got), size)
+	}
+}
+
+func TestCompressPayloadsBelowThreshold(t *testing.T) {
+	w := openTestWAL(t, Config{CompressPayloads: true, CompressMinBytes: 1024})
+
+	small, err := w.encodeRecord(bigTask("small", 1023))
+	if err != nil {
+		t.Fatalf("encodeRecord: %v", err)
+	}
+	if small[lengthPrefixSize]&recordFlagCompressed != 0 {
+		t.Fatal("payload below the threshold was compressed")
+	}
+	large, err := w.encodeRecord(bigTask("large", 1024))
+	if err != nil {
+		t.Fatalf("encodeRecord: %v", err)
+	}
+	if large[lengthPrefixSize]&recordFlagCompressed == 0 {
+		t.Fatal("payload at the threshold was not compressed")
+	}
+}
+
+func TestCompressedPayloadCorruptionDetected(t *testing.T) {
+	w := openTestWAL(t, Config{CompressPayloads: true, CompressMinBytes: 1})
+	offset, err := w.AppendAt(bigTask("a", 4096))
+	if err != nil {
+		t.Fatalf("AppendAt: %v", err)
+	}
+	mustAppend(t, w, taskCreated("b"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The checksum covers the compressed bytes
+	flipByte(t, w.filePath, offset+lengthPrefixSize+recordTypeSize+20)
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if err := r.Replay(func(Record) error { return nil }); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("Replay = %v, want ErrInvalidChecksum", err)
+	}
+}
+
+func TestDecompressPayloadBounded(t *testing.T) {
+	// A megabyte of zeros compresses to about a kilobyte
+	bomb, err := compressPayload(make([]byte, 1<<20))
+	if err != nil {
+		t.Fatalf("compressPayload: %v", err)
+	}
+	if len(bomb) >= 4096 {
+		t.Fatalf("compressed payload is %d bytes", len(bomb))
+	}
+
+	if _, err := decompressPayload(bomb, 4096); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("decompressPayload over the limit = %v, want ErrRecordTooLarge", err)
+	}
+	payload, err := decompressPayload(bomb, 1<<20)
+	if err != nil || len(payload) != 1<<20 {
+		t.Fatalf("decompressPayload at the limit = %d bytes, %v", len(payload), err)
+	}
+}
+
+func TestCompressedRecordBoundedByMaxRecordSize(t *testing.T) {
+	const limit = 4096
+	record := bigTask("big", 64*1024)
+
+	// The compressed frame would fit, the payload it inflates to would not
+	w := openTestWAL(t, Config{CompressPayloads: true, MaxRecordSize: limit})
+	if err := w.Append(record); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Append = %v, want ErrRecordTooLarge", err)
+	}
+
+	// A log written under a larger limit is refused by a reader with the
+	// smaller one rather than inflated
+	w = reopenTestWAL(t, w, Config{CompressPayloads: true})
+	mustAppend(t, w, record)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if size, _ := w.Size(); size >= limit {
+		t.Fatalf("compressed log is %d bytes, want it under %d", size, limit)
+	}
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true, MaxRecordSize: limit})
+	if err := r.Replay(func(Record) error { return nil }); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Replay = %v, want ErrRecordTooLarge", err)
+	}
+}
@@ -0,0 +1,94 @@
+package wal
+
+import (
+	"container/list"
+	"sync"
+)
+
+// decodeCache is an LRU cache of records decoded by Index reads, see
+// Config.DecodeCacheSize. A nil cache holds nothing
+// Indexes read without w.mu, so the cache has its own lock
+type decodeCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *decodeEntry, most recently used first
+	entries map[decodeKey]*list.Element
+}
+
+// decodeKey locates a record frame: a file of the log and an offset in it
+type decodeKey struct {
+	path   string
+	offset int64
+}
+
+type decodeEntry struct {
+	key    decodeKey
+	record Record
+}
+
+// newDecodeCache returns a cache holding up to size records, or nil if
+// size is not positive
+func newDecodeCache(size int) *decodeCache {
+	if size <= 0 {
+		return nil
+	}
+	return &decodeCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[decodeKey]*list.Element),
+	}
+}
+
+// get returns the cached record at key and marks it most recently used
+func (c *decodeCache) get(key decodeKey) (Record, bool) {
+	if c == nil {
+		return Record{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return Record{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*decodeEntry).record, true
+}
+
+// put caches the record at key, evicting the least recently used record
+// if the cache is full
+func (c *decodeCache) put(key decodeKey, record Record) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*decodeEntry).record = record
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&decodeEntry{key: key, record: record})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*decodeEntry).key)
+	}
+}
+
+// clear drops every cached record, e.g. once the bytes at cached offsets
+// of the active file may have changed
+func (c *decodeCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.entries)
+}
@@ -0,0 +1,163 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// countingIndex builds an index of w whose disk reads are counted in reads
+func countingIndex(t *testing.T, w *WAL, reads *int) *Index {
+	t.Helper()
+	idx, err := w.BuildIndex()
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	idx.open = func(name string) (*os.File, error) {
+		*reads++
+		return os.Open(name)
+	}
+	return idx
+}
+
+// readAt returns record n of idx
+func readAt(t *testing.T, idx *Index, n int) Record {
+	t.Helper()
+	record, err := idx.ReadAt(n)
+	if err != nil {
+		t.Fatalf("ReadAt(%d): %v", n, err)
+	}
+	return record
+}
+
+func TestDecodeCacheServesRepeatedReads(t *testing.T) {
+	w := openTestWAL(t, Config{DecodeCacheSize: 4})
+	mustAppend(t, w, sampleRecords()...)
+	reads := 0
+	idx := countingIndex(t, w, &reads)
+
+	first := readAt(t, idx, 2)
+	if second := readAt(t, idx, 2); !reflect.DeepEqual(second, first) || reads != 1 {
+		t.Fatalf("second ReadAt took %d disk reads in all, want it served from the cache", reads)
+	}
+
+	// Indexes of the same WAL share the cache
+	other := 0
+	if readAt(t, countingIndex(t, w, &other), 2); other != 0 {
+		t.Fatalf("another index read the cached record from disk %d times", other)
+	}
+
+	// ReadByType goes through the cache as well
+	reads = 0
+	records, err := idx.ReadByType(sampleRecords()[2].Type)
+	if err != nil || len(records) != 1 || reads != 0 {
+		t.Fatalf("ReadByType = %v, %v after %d disk reads, want the cached record", recordTypes(records), err, reads)
+	}
+}
+
+func TestDecodeCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	w := openTestWAL(t, Config{DecodeCacheSize: 2})
+	appendTasks(t, w, 4)
+	reads := 0
+	idx := countingIndex(t, w, &reads)
+
+	for _, n := range []int{0, 1, 0, 2} {
+		readAt(t, idx, n)
+	}
+	if reads != 3 {
+		t.Fatalf("%d disk reads, want 3", reads)
+	}
+
+	// Record 1 was evicted for record 2, record 0 was used since
+	readAt(t, idx, 0)
+	if reads != 3 {
+		t.Fatal("recently used record 0 was evicted")
+	}
+	readAt(t, idx, 1)
+	if reads != 4 {
+		t.Fatal("least recently used record 1 was not evicted")
+	}
+}
+
+func TestDecodeCacheDisabled(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 2)
+	reads := 0
+	idx := countingIndex(t, w, &reads)
+
+	for i := 0; i < 3; i++ {
+		readAt(t, idx, 1)
+	}
+	if reads != 3 {
+		t.Fatalf("%d disk reads without a cache, want 3", reads)
+	}
+}
+
+func TestDecodeCacheInvalidated(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite func(w *WAL) error
+	}{
+		{"truncate", func(w *WAL) error { return w.Truncate(headerSize) }},
+		{"reset", func(w *WAL) error { return w.Reset() }},
+		{"rotate", func(w *WAL) error { return w.Rotate() }},
+		{"compact", func(w *WAL) error {
+			if err := w.Truncate(headerSize); err != nil {
+				return err
+			}
+			mustAppend(t, w, taskCreated("done"), leaseGranted("done", "l1", "w", 1), taskCompleted("done", "l1"))
+			return w.Compact()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := openTestWAL(t, Config{DecodeCacheSize: 8})
+			mustAppend(t, w, taskCreated("old"))
+			reads := 0
+			readAt(t, countingIndex(t, w, &reads), 0)
+
+			// A new record lands at the cached offset of the active file
+			if err := tt.rewrite(w); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			mustAppend(t, w, taskCreated("new"))
+			idx := countingIndex(t, w, &reads)
+			got := readAt(t, idx, idx.Len()-1)
+			if id := got.Payload.(TaskCreatedPayload).TaskID; id != "new" {
+				t.Fatalf("ReadAt after %s = task %s, want new", tt.name, id)
+			}
+		})
+	}
+}
+
+func TestDecodeCacheUnit(t *testing.T) {
+	var disabled *decodeCache
+	disabled.put(decodeKey{"a", 1}, taskCreated("a"))
+	disabled.clear()
+	if _, ok := disabled.get(decodeKey{"a", 1}); ok || newDecodeCache(0) != nil {
+		t.Fatal("a disabled cache holds records")
+	}
+
+	c := newDecodeCache(2)
+	for i := 0; i < 3; i++ {
+		c.put(decodeKey{"a", int64(i)}, taskCreated(fmt.Sprint(i)))
+	}
+	// Putting an existing key replaces its record and refreshes it
+	c.put(decodeKey{"a", 1}, taskCreated("replaced"))
+	c.put(decodeKey{"a", 3}, taskCreated("3"))
+	if _, ok := c.get(decodeKey{"a", 2}); ok {
+		t.Fatal("least recently used key survived")
+	}
+	if record, ok := c.get(decodeKey{"a", 1}); !ok || record.Payload.(TaskCreatedPayload).TaskID != "replaced" {
+		t.Fatalf("get = %+v, %t, want the replaced record", record, ok)
+	}
+	if _, ok := c.get(decodeKey{"b", 1}); ok {
+		t.Fatal("key of another file hit")
+	}
+
+	c.clear()
+	if _, ok := c.get(decodeKey{"a", 1}); ok || c.order.Len() != 0 {
+		t.Fatal("clear kept records")
+	}
+}
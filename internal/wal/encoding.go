@@ -0,0 +1,488 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Payload encoding
+//
+// Payload fields are written in struct declaration order using a fixed,
+// deterministic layout so that the same record always encodes to the same
+// bytes:
+// - uint32:        4 bytes
+// - string:        uint32 length + bytes
+// - []byte:        uint32 length + bytes (nilBytesLength marks a nil slice)
+// - int, Duration: int64
+// - time.Time:     int64 Unix nanoseconds (zeroTimeNanos marks the zero time)
+// All integers are little-endian
+// From versionVarint on, uint32 fields and lengths are written as unsigned
+// varints and int, int64 and Duration fields as zig-zag varints, as in
+// encoding/binary, so small counts and short strings take a byte or two
+// Times stay fixed-width, since Unix nanoseconds never fit in fewer bytes
+// Fields added in a later format version are only present in files of that
+// version or newer, see formatVersion
+
+const (
+	nilBytesLength = math.MaxUint32
+	zeroTimeNanos  = math.MinInt64
+)
+
+// encoder appends fixed-layout fields to a byte slice
+type encoder struct {
+	buf    []byte
+	varint bool // integers are varints, see versionVarint
+}
+
+// newEncoder returns an encoder for payloads of the given format version
+func newEncoder(version uint8) *encoder {
+	return &encoder{varint: version >= versionVarint}
+}
+
+func (e *encoder) putUint32(v uint32) {
+	if e.varint {
+		e.buf = binary.AppendUvarint(e.buf, uint64(v))
+		return
+	}
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) putInt64(v int64) {
+	if e.varint {
+		e.buf = binary.AppendVarint(e.buf, v)
+		return
+	}
+	e.putFixed64(v)
+}
+
+func (e *encoder) putFixed64(v int64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) putString(s string) {
+	e.putUint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) putBytes(b []byte) {
+	if b == nil {
+		e.putUint32(nilBytesLength)
+		return
+	}
+	e.putUint32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) putInt(v int) {
+	e.putInt64(int64(v))
+}
+
+func (e *encoder) putDuration(d time.Duration) {
+	e.putInt64(int64(d))
+}
+
+func (e *encoder) putTime(t time.Time) {
+	if t.IsZero() {
+		e.putFixed64(zeroTimeNanos)
+		return
+	}
+	e.putFixed64(t.UnixNano())
+}
+
+// putTaskCreatedTail appends the fields of p that follow its task payload
+func putTaskCreatedTail(e *encoder, p TaskCreatedPayload) {
+	e.putDuration(p.ExecutionWindow)
+	e.putInt(p.RetryPolicy.MaxRetries)
+	e.putString(p.RequestID)
+	e.putTime(p.CreatedAt)
+}
+
+// encodePayload serializes the payload of a record for a file of the given
+// format version
+// Returns ErrInvalidRecord if the payload type does not match the record type
+func encodePayload(record Record, version uint8) ([]byte, error) {
+	e := newEncoder(version)
+
+	switch record.Type {
+	case RecordTypeTaskCreated:
+		p, ok := record.Payload.(TaskCreatedPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putBytes(p.Payload)
+		putTaskCreatedTail(e, p)
+
+	case RecordTypeTaskCompleted:
+		p, ok := record.Payload.(TaskCompletedPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putString(p.LeaseID)
+
+	case RecordTypeTaskFailed:
+		p, ok := record.Payload.(TaskFailedPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putString(p.LeaseID)
+		e.putString(p.FailureReason)
+
+	case RecordTypeTaskCancelled:
+		p, ok := record.Payload.(TaskCancelledPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putString(p.LeaseID)
+
+	case RecordTypeLeaseGranted:
+		p, ok := record.Payload.(LeaseGrantedPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putString(p.LeaseID)
+		e.putString(p.WorkerID)
+		e.putInt(p.Attempt)
+		e.putTime(p.LeaseExpiry)
+		e.putTime(p.GrantedAt)
+
+	case RecordTypeLeaseExtended:
+		p, ok := record.Payload.(LeaseExtendedPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.LeaseID)
+		e.putTime(p.NewLeaseExpiry)
+		if version >= versionLeaseTimes {
+			e.putTime(p.At)
+		}
+
+	case RecordTypeLeaseExpired:
+		p, ok := record.Payload.(LeaseExpiredPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putString(p.LeaseID)
+		if version >= versionLeaseTimes {
+			e.putTime(p.At)
+		}
+
+	case RecordTypeTaskDead:
+		p, ok := record.Payload.(TaskDeadPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putString(p.Reason)
+
+	case RecordTypeTaskRetried:
+		p, ok := record.Payload.(TaskRetriedPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putString(p.PreviousLeaseID)
+		e.putInt(p.Attempt)
+		e.putString(p.Reason)
+
+	case RecordTypeTaskRescheduled:
+		p, ok := record.Payload.(TaskRescheduledPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.TaskID)
+		e.putTime(p.NextAttemptAt)
+		e.putInt(p.Attempt)
+		e.putDuration(p.Backoff)
+
+	case RecordTypeCheckpoint:
+		p, ok := record.Payload.(CheckpointPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putInt64(p.Offset)
+		e.putTime(p.Timestamp)
+		e.putUint32(p.StateHash)
+
+	case RecordTypeLeaseRenewalDenied:
+		p, ok := record.Payload.(LeaseRenewalDeniedPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.LeaseID)
+		e.putString(p.TaskID)
+		e.putString(p.Reason)
+		e.putTime(p.At)
+
+	case RecordTypeAnnotation:
+		p, ok := record.Payload.(AnnotationPayload)
+		if !ok {
+			return nil, payloadMismatch(record)
+		}
+		e.putString(p.Text)
+		e.putString(p.Author)
+		e.putTime(p.At)
+
+	default:
+		if data, ok, err := encodeCustomPayload(record); ok {
+			return data, err
+		}
+		return nil, fmt.Errorf("%w: unknown record type %d", ErrInvalidRecord, record.Type)
+	}
+
+	return e.buf, nil
+}
+
+// payloadMismatch reports a payload whose Go type does not match the record type
+func payloadMismatch(record Record) error {
+	return fmt.Errorf("%w: payload %T does not match record type %d", ErrInvalidRecord, record.Payload, record.Type)
+}
+
+// decoder reads fixed-layout fields from a byte slice
+// The first short read is latched in err and all later reads return zero values
+type decoder struct {
+	data   []byte
+	off    int
+	err    error
+	varint bool // integers are varints, see versionVarint
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data)-d.off < n {
+		d.err = fmt.Errorf("%w: payload truncated at byte %d", ErrCorruptedLog, d.off)
+		return nil
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	if d.varint {
+		v, n := binary.Uvarint(d.rest())
+		if n <= 0 || v > math.MaxUint32 {
+			d.fail("bad varint")
+			return 0
+		}
+		d.off += n
+		return uint32(v)
+	}
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) int64() int64 {
+	if d.varint {
+		v, n := binary.Varint(d.rest())
+		if n <= 0 {
+			d.fail("bad varint")
+			return 0
+		}
+		d.off += n
+		return v
+	}
+	return d.fixed64()
+}
+
+func (d *decoder) fixed64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+// rest returns the unread bytes, or nil once a read failed
+func (d *decoder) rest() []byte {
+	if d.err != nil {
+		return nil
+	}
+	return d.data[d.off:]
+}
+
+// fail latches a decode error at the current byte, unless one is latched
+func (d *decoder) fail(reason string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s at payload byte %d", ErrCorruptedLog, reason, d.off)
+	}
+}
+
+func (d *decoder) string() string {
+	return string(d.next(int(d.uint32())))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err != nil || n == nilBytesLength {
+		return nil
+	}
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+func (d *decoder) int() int {
+	return int(d.int64())
+}
+
+func (d *decoder) duration() time.Duration {
+	return time.Duration(d.int64())
+}
+
+func (d *decoder) time() time.Time {
+	n := d.fixed64()
+	if d.err != nil || n == zeroTimeNanos {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// finish reports any decode error, including unconsumed trailing bytes
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.off != len(d.data) {
+		return fmt.Errorf("%w: %d trailing payload bytes", ErrCorruptedLog, len(d.data)-d.off)
+	}
+	return nil
+}
+
+// decodePayload deserializes a payload of the given record type read from
+// a file of the given format version. It is the inverse of encodePayload
+func decodePayload(recordType RecordType, data []byte, version uint8) (interface{}, error) {
+	d := &decoder{data: data, varint: version >= versionVarint}
+
+	var payload interface{}
+	switch recordType {
+	case RecordTypeTaskCreated:
+		payload = TaskCreatedPayload{
+			TaskID:          d.string(),
+			Payload:         d.bytes(),
+			ExecutionWindow: d.duration(),
+			RetryPolicy:     RetryPolicy{MaxRetries: d.int()},
+			RequestID:       d.string(),
+			CreatedAt:       d.time(),
+		}
+
+	case RecordTypeTaskCompleted:
+		payload = TaskCompletedPayload{
+			TaskID:  d.string(),
+			LeaseID: d.string(),
+		}
+
+	case RecordTypeTaskFailed:
+		payload = TaskFailedPayload{
+			TaskID:        d.string(),
+			LeaseID:       d.string(),
+			FailureReason: d.string(),
+		}
+
+	case RecordTypeTaskCancelled:
+		payload = TaskCancelledPayload{
+			TaskID:  d.string(),
+			LeaseID: d.string(),
+		}
+
+	case RecordTypeLeaseGranted:
+		payload = LeaseGrantedPayload{
+			TaskID:      d.string(),
+			LeaseID:     d.string(),
+			WorkerID:    d.string(),
+			Attempt:     d.int(),
+			LeaseExpiry: d.time(),
+			GrantedAt:   d.time(),
+		}
+
+	case RecordTypeLeaseExtended:
+		p := LeaseExtendedPayload{
+			LeaseID:        d.string(),
+			NewLeaseExpiry: d.time(),
+		}
+		if version >= versionLeaseTimes {
+			p.At = d.time()
+		}
+		payload = p
+
+	case RecordTypeLeaseExpired:
+		p := LeaseExpiredPayload{
+			TaskID:  d.string(),
+			LeaseID: d.string(),
+		}
+		if version >= versionLeaseTimes {
+			p.At = d.time()
+		}
+		payload = p
+
+	case RecordTypeTaskDead:
+		payload = TaskDeadPayload{
+			TaskID: d.string(),
+			Reason: d.string(),
+		}
+
+	case RecordTypeTaskRetried:
+		payload = TaskRetriedPayload{
+			TaskID:          d.string(),
+			PreviousLeaseID: d.string(),
+			Attempt:         d.int(),
+			Reason:          d.string(),
+		}
+
+	case RecordTypeTaskRescheduled:
+		payload = TaskRescheduledPayload{
+			TaskID:        d.string(),
+			NextAttemptAt: d.time(),
+			Attempt:       d.int(),
+			Backoff:       d.duration(),
+		}
+
+	case RecordTypeCheckpoint:
+		payload = CheckpointPayload{
+			Offset:    d.int64(),
+			Timestamp: d.time(),
+			StateHash: d.uint32(),
+		}
+
+	case RecordTypeLeaseRenewalDenied:
+		payload = LeaseRenewalDeniedPayload{
+			LeaseID: d.string(),
+			TaskID:  d.string(),
+			Reason:  d.string(),
+			At:      d.time(),
+		}
+
+	case RecordTypeAnnotation:
+		payload = AnnotationPayload{
+			Text:   d.string(),
+			Author: d.string(),
+			At:     d.time(),
+		}
+
+	default:
+		if payload, ok, err := decodeCustomPayload(recordType, data); ok {
+			return payload, err
+		}
+		return nil, fmt.Errorf("%w: unknown record type %d", ErrCorruptedLog, recordType)
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,81 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, EncryptionKeySize)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key := testKey(1)
+	w := openTestWAL(t, Config{EncryptionKey: key})
+	mustAppend(t, w, sampleRecords()...)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	data, err := os.ReadFile(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("manual failover")) || bytes.Contains(data, []byte("worker-1")) {
+		t.Fatal("plaintext found in the encrypted log")
+	}
+
+	w = reopenTestWAL(t, w, Config{EncryptionKey: key})
+	if got := mustReplay(t, w); !reflect.DeepEqual(got, sampleRecords()) {
+		t.Fatalf("replayed %+v, want %+v", got, sampleRecords())
+	}
+}
+
+func TestEncryptionMixedLog(t *testing.T) {
+	key := testKey(2)
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("plain"))
+
+	w = reopenTestWAL(t, w, Config{EncryptionKey: key})
+	mustAppend(t, w, taskCreated("secret"))
+
+	records := mustReplay(t, w)
+	if len(records) != 2 || records[1].Payload.(TaskCreatedPayload).TaskID != "secret" {
+		t.Fatalf("replayed %+v", records)
+	}
+}
+
+func TestEncryptionKeyRequired(t *testing.T) {
+	w := openTestWAL(t, Config{EncryptionKey: testKey(3)})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		want error
+	}{
+		{"no key", nil, ErrNoEncryptionKey},
+		{"wrong key", testKey(4), ErrDecryptionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true, EncryptionKey: tt.key})
+			if err := r.Replay(func(Record) error { return nil }); !errors.Is(err, tt.want) {
+				t.Fatalf("Replay = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncryptionKeySize(t *testing.T) {
+	_, err := Open(Config{FilePath: testPath(t), EncryptionKey: []byte("short")})
+	if err == nil {
+		t.Fatal("Open accepted a short key")
+	}
+}
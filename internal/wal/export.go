@@ -0,0 +1,83 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Export writes a transformed copy of the whole log, sealed segments
+// included, to a new log file at dst, which must not exist
+// transform is called with each record in order and returns the record to
+// write, or false to drop it, e.g. to redact task payloads or keep a subset
+// Transformed records are not validated, so a redacted payload may be nil
+// The copy gets a fresh header from the WAL's configuration and is encoded
+// accordingly; in sequenced logs, records are renumbered from 1
+func (w *WAL) Export(dst string, transform func(Record) (Record, bool)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("export: failed to create %s: %w", filepath.Base(dst), err)
+	}
+	if err := w.exportLocked(out, transform); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("export: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("export: failed to close %s: %w", filepath.Base(dst), err)
+	}
+
+	return syncDir(filepath.Dir(dst))
+}
+
+// exportLocked writes the header and the transformed records to out
+// Callers must hold w.mu
+func (w *WAL) exportLocked(out *os.File, transform func(Record) (Record, bool)) error {
+	header := w.newHeader()
+	if err := writeHeader(out, header); err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(out)
+	seq := uint64(0)
+	err := w.replayAllLocked(context.Background(), applyOnly(func(record Record) error {
+		record, keep := transform(record)
+		if !keep {
+			return nil
+		}
+
+		record.Seq = 0
+		if header.sequenced() {
+			seq++
+			record.Seq = seq
+		}
+		data, err := w.encodeRecordWith(header, record)
+		if err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+		_, err = buf.Write(data)
+		return err
+	}))
+	if err != nil {
+		return err
+	}
+
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write exported log: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync exported log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// redact strips task payloads
+func redact(record Record) (Record, bool) {
+	if p, ok := record.Payload.(TaskCreatedPayload); ok {
+		p.Payload = nil
+		record.Payload = p
+	}
+	return record, true
+}
+
+func TestExportRedactsPayloads(t *testing.T) {
+	w := openTestWAL(t, Config{MaxSegmentBytes: 512})
+	mustAppend(t, w, bigTask("a", 300), leaseGranted("a", "l1", "w", 1), bigTask("b", 300), taskCompleted("a", "l1"))
+	if segments, _ := w.sealedSegments(); len(segments) == 0 {
+		t.Fatal("log did not rotate")
+	}
+	original := mustReplay(t, w)
+
+	dst := mergedPath(t)
+	if err := w.Export(dst, redact); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	exported := mustReplay(t, openTestWAL(t, Config{FilePath: dst, ReadOnly: true}))
+	if got, want := recordTypes(exported), recordTypes(original); !reflect.DeepEqual(got, want) {
+		t.Fatalf("exported %v, want %v", got, want)
+	}
+	for i, record := range exported {
+		p, ok := record.Payload.(TaskCreatedPayload)
+		if !ok {
+			if !reflect.DeepEqual(record, original[i]) {
+				t.Fatalf("record %d = %+v, want it unchanged", i, record)
+			}
+			continue
+		}
+		if len(p.Payload) != 0 || p.TaskID != original[i].Payload.(TaskCreatedPayload).TaskID {
+			t.Fatalf("exported task %+v, want its payload stripped", p)
+		}
+	}
+
+	// The source log is untouched
+	if got := mustReplay(t, w); !reflect.DeepEqual(got, original) {
+		t.Fatal("Export changed the source log")
+	}
+}
+
+func TestExportSubsetRenumbers(t *testing.T) {
+	w := openTestWAL(t, Config{SequenceNumbers: true})
+	appendTasks(t, w, 6)
+
+	dst := mergedPath(t)
+	if err := w.Export(dst, func(record Record) (Record, bool) {
+		id := record.Payload.(TaskCreatedPayload).TaskID
+		return record, id == "t1" || id == "t4"
+	}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	exported := mustReplay(t, openTestWAL(t, Config{FilePath: dst, ReadOnly: true}))
+	if got, want := taskIDs(exported), []string{"t1", "t4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("exported %v, want %v", got, want)
+	}
+	if got, want := seqs(exported), []uint64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("exported sequence numbers = %v, want %v", got, want)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+
+	// An existing destination is not overwritten
+	dst := mergedPath(t)
+	if err := os.WriteFile(dst, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Export(dst, redact); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Export over an existing file = %v, want os.ErrExist", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "keep" {
+		t.Fatal("Export overwrote an existing file")
+	}
+
+	// A record that cannot be encoded leaves no partial export
+	dst = mergedPath(t) + ".bad"
+	err := w.Export(dst, func(record Record) (Record, bool) {
+		record.Payload = AnnotationPayload{Text: "mismatch"}
+		return record, true
+	})
+	if err == nil {
+		t.Fatal("Export of an unencodable record succeeded")
+	}
+	if _, statErr := os.Stat(dst); !os.IsNotExist(statErr) {
+		t.Fatalf("failed export left %s behind: %v", dst, statErr)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Export(mergedPath(t)+".closed", redact); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("Export of a closed log = %v, want ErrWALClosed", err)
+	}
+}
@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"errors"
+	"io"
+)
+
+// errCrashed is returned by the append that hit Config.crashAt
+var errCrashed = errors.New("wal: simulated crash")
+
+// crashDue reports whether writing n more bytes to the active file reaches
+// the failpoint set by Config.crashAt
+func (w *WAL) crashDue(n int) bool {
+	return w.crashAt > 0 && w.offset+int64(n) > w.crashAt
+}
+
+// crashLocked simulates the process dying part way through writing data:
+// buffered records and the head of data up to Config.crashAt reach the
+// file, then the log halts as in haltLocked
+// Callers must hold w.mu
+func (w *WAL) crashLocked(data []byte) error {
+	w.writer.Write(data[:max(0, w.crashAt-w.offset)])
+	return w.haltLocked()
+}
+
+// haltLocked flushes what was written so far, then closes every handle
+// without an fsync or a rollback, so the file ends in a torn record as
+// after a real crash. The WAL is left closed; reopening it runs recovery
+// as usual
+// Callers must hold w.mu
+func (w *WAL) haltLocked() error {
+	w.writer.Flush()
+
+	w.file.Close()
+	w.file = nil
+	w.releaseLocked()
+
+	return errCrashed
+}
+
+// crashWriter passes writes on to w until n bytes went through, then
+// fails with errCrashed, so a streamed frame is torn at Config.crashAt
+type crashWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *crashWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= c.n {
+		n, err := c.w.Write(p)
+		c.n -= int64(n)
+		return n, err
+	}
+
+	n, _ := c.w.Write(p[:c.n])
+	c.n = 0
+	return n, errCrashed
+}
@@ -0,0 +1,126 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// crashingAppend appends records to a fresh log that crashes at crashAt,
+// expecting the crash on the last one. Returns the log's path
+func crashingAppend(t *testing.T, config Config, crashAt int64, records ...Record) string {
+	t.Helper()
+	config.crashAt = crashAt
+	w := openTestWAL(t, config)
+	last := len(records) - 1
+	mustAppend(t, w, records[:last]...)
+	if err := w.Append(records[last]); !errors.Is(err, errCrashed) {
+		t.Fatalf("Append across offset %d = %v, want errCrashed", crashAt, err)
+	}
+	if err := w.Append(taskCreated("after")); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("Append after the crash = %v, want ErrWALClosed", err)
+	}
+
+	// The file ends exactly at the failpoint
+	if size := fileSize(t, w.filePath); size != crashAt {
+		t.Fatalf("crashed log is %d bytes, want %d", size, crashAt)
+	}
+	return w.filePath
+}
+
+// checkRecovery checks that the torn log at path recovers to records
+func checkRecovery(t *testing.T, path string, records []Record) {
+	t.Helper()
+	report, err := Verify(path)
+	if err != nil || !report.TornTail || !report.Healthy() || report.ValidRecords != len(records) {
+		t.Fatalf("Verify of the torn log = %+v, %v", report, err)
+	}
+	if got := mustReplay(t, openTestWAL(t, Config{FilePath: path, ReadOnly: true})); !reflect.DeepEqual(recordTypes(got), recordTypes(records)) {
+		t.Fatalf("replayed %v from the torn log, want %v", recordTypes(got), recordTypes(records))
+	}
+
+	// Repair trims the torn record, so appends continue on a boundary
+	w := openTestWAL(t, Config{FilePath: path, RepairOnOpen: true})
+	if w.Offset() != report.ValidBytes {
+		t.Fatalf("offset %d after repair, want %d", w.Offset(), report.ValidBytes)
+	}
+	mustAppend(t, w, taskCreated("after"))
+	w = reopenTestWAL(t, w, Config{})
+	if got, want := len(mustReplay(t, w)), len(records)+1; got != want {
+		t.Fatalf("replayed %d records after repair and an append, want %d", got, want)
+	}
+}
+
+func TestCrashAtTearsRecord(t *testing.T) {
+	// The offsets of t0 to t2, written without a failpoint
+	offsets := appendTasksAt(t, openTestWAL(t, Config{}), 3)
+	start, end := offsets[2], offsets[2]+(offsets[1]-offsets[0])
+
+	tests := []struct {
+		name    string
+		crashAt int64
+		kept    int
+	}{
+		{"first record", headerSize + 3, 0},
+		{"length prefix", start + 1, 2},
+		{"after length prefix", start + lengthPrefixSize, 2},
+		{"record type", start + lengthPrefixSize + recordTypeSize, 2},
+		{"payload", start + lengthPrefixSize + recordTypeSize + 5, 2},
+		{"checksum", end - 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncer := &countingSyncer{}
+			var records []Record
+			for i := 0; i <= tt.kept; i++ {
+				records = append(records, taskCreated(fmt.Sprintf("t%d", i)))
+			}
+			path := crashingAppend(t, Config{syncer: syncer}, tt.crashAt, records...)
+
+			// The crashing append returned without an fsync
+			if got := syncer.count(); got != tt.kept {
+				t.Fatalf("%d fsyncs, want one for each of the %d complete records", got, tt.kept)
+			}
+			checkRecovery(t, path, records[:tt.kept])
+		})
+	}
+}
+
+func TestCrashAtFlushesBufferedRecords(t *testing.T) {
+	// Records still in the write buffer reach the file before the torn one
+	probe := openTestWAL(t, Config{SyncBatchSize: 100})
+	offsets := appendTasksAt(t, probe, 4)
+
+	records := []Record{taskCreated("t0"), taskCreated("t1"), taskCreated("t2"), taskCreated("t3")}
+	path := crashingAppend(t, Config{SyncBatchSize: 100}, offsets[3]+lengthPrefixSize+2, records...)
+	checkRecovery(t, path, records[:3])
+}
+
+func TestCrashAtTearsStreamedRecord(t *testing.T) {
+	big := bigTask("big", streamMinBytes+streamChunkSize/2)
+	records := []Record{taskCreated("t0"), big}
+
+	for _, config := range []Config{{}, {SequenceNumbers: true, ChecksumScope: ChecksumScopeFrame}} {
+		// The frame of big after t0, written without a failpoint
+		probe := openTestWAL(t, config)
+		mustAppend(t, probe, records[0])
+		start := probe.Offset()
+		if !probe.streamable(probe.header, big) {
+			t.Fatal("record is not streamed")
+		}
+		mustAppend(t, probe, big)
+		end := probe.Offset()
+
+		for _, crashAt := range []int64{
+			start + 2,               // length prefix
+			start + 100,             // head of the payload
+			start + streamChunkSize, // in the second chunk of the payload
+			(start + end) / 2,       // mid payload
+			end - 2,                 // checksum
+		} {
+			path := crashingAppend(t, config, crashAt, records...)
+			checkRecovery(t, path, records[:1])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package wal
+
+import "context"
+
+// ReplayFiltered is Replay that only calls applyFn for records whose type
+// is in types, sealed segments included. Every record is still read and
+// its checksum verified, so corruption is reported as in Replay, but the
+// payloads of the other records are never decoded
+func (w *WAL) ReplayFiltered(types []RecordType, applyFn func(Record) error) error {
+	set := make(map[RecordType]bool, len(types))
+	for _, t := range types {
+		set[t] = true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+	// Flush first: a failed flush rolls back and reloads state, which
+	// needs every payload
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+
+	w.replayTypes = set
+	defer func() { w.replayTypes = nil }()
+
+	return w.replayAllLocked(context.Background(), func(record Record, _, _ int64) error {
+		if !set[record.Type] {
+			return nil
+		}
+		return applyFn(record)
+	})
+}
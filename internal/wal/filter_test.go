@@ -0,0 +1,110 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// replayFiltered returns the records ReplayFiltered passes on for types
+func replayFiltered(t *testing.T, w *WAL, types ...RecordType) []Record {
+	t.Helper()
+	var records []Record
+	if err := w.ReplayFiltered(types, func(record Record) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReplayFiltered: %v", err)
+	}
+	return records
+}
+
+func TestReplayFilteredLeaseRecords(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("a"),
+		leaseGranted("a", "l1", "w", 1),
+		leaseExtended("l1", testTime.Add(time.Hour)),
+		taskCompleted("a", "l1"),
+	)
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	mustAppend(t, w, taskCreated("b"), leaseGranted("b", "l2", "w", 1), leaseExpired("b", "l2"))
+
+	records := replayFiltered(t, w, RecordTypeLeaseGranted, RecordTypeLeaseExtended, RecordTypeLeaseExpired)
+	want := []RecordType{RecordTypeLeaseGranted, RecordTypeLeaseExtended, RecordTypeLeaseGranted, RecordTypeLeaseExpired}
+	if got := recordTypes(records); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReplayFiltered = %v, want %v", got, want)
+	}
+	all := mustReplay(t, w)
+	if !reflect.DeepEqual(records[0], all[1]) || !reflect.DeepEqual(records[3], all[6]) {
+		t.Fatal("filtered records differ from the replayed ones")
+	}
+
+	if got := replayFiltered(t, w); len(got) != 0 {
+		t.Fatalf("ReplayFiltered with no types = %v, want none", recordTypes(got))
+	}
+}
+
+func TestReplayFilteredSkipsPayloadDecoding(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	end := w.Offset()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A task record whose checksum holds but whose payload cannot decode
+	frame, err := w.frameRecord(w.header, byte(RecordTypeTaskCreated), 0, []byte{0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(w.filePath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(frame, end); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	w = openTestWAL(t, Config{FilePath: w.filePath})
+	mustAppend(t, w, annotation("after"))
+	if err := w.Replay(func(Record) error { return nil }); err == nil {
+		t.Fatal("Replay decoded the undecodable task record")
+	}
+	records := replayFiltered(t, w, RecordTypeAnnotation)
+	if len(records) != 1 || records[0].Payload.(AnnotationPayload).Text != "after" {
+		t.Fatalf("ReplayFiltered = %v, want the annotation only", recordTypes(records))
+	}
+
+	// The filter is dropped afterwards, so replay decodes everything again
+	if err := w.Replay(func(Record) error { return nil }); err == nil {
+		t.Fatal("Replay after ReplayFiltered skipped decoding")
+	}
+}
+
+func TestReplayFilteredVerifiesSkippedRecords(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("a"), taskCreated("b"), leaseGranted("a", "l1", "w", 1))
+	flipByte(t, path, offsets[1]+lengthPrefixSize+recordTypeSize+1)
+
+	w := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+	err := w.ReplayFiltered([]RecordType{RecordTypeLeaseGranted}, func(Record) error { return nil })
+	if !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("ReplayFiltered over a corrupt skipped record = %v, want ErrCorruptedLog", err)
+	}
+}
+
+func TestReplayFilteredCallbackError(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+
+	stop := errors.New("stop")
+	err := w.ReplayFiltered([]RecordType{RecordTypeLeaseGranted}, func(Record) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Fatalf("ReplayFiltered = %v, want the callback's error", err)
+	}
+}
@@ -0,0 +1,179 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// File header
+//
+// Every log file (active or sealed) starts with a fixed header that
+// identifies it as a WAL and describes how its records are framed:
+// - Magic (4 bytes): "SWAL"
+// - Version (1 byte): format version, see formatVersion
+// - Checksum (1 byte): ChecksumType of every record in the file
+// - Flags (1 byte): optional encoding features, see headerFlagJSON
+//
+// Byte order is not configurable: every multi-byte field, in frames and
+// binary payloads alike, is little-endian whatever the host, so a log
+// written on one architecture reads back unchanged on any other
+const headerSize = 7
+
+// formatVersion is the on-disk format written by this package
+// Files with a newer version are rejected with ErrUnsupportedVersion
+// Older files are still read, and appended to, in their own version
+const formatVersion = versionVarint
+
+// Format versions
+const (
+	// versionInitial is the original format
+	versionInitial = 1
+	// versionLeaseTimes adds At to LeaseExtended and LeaseExpired payloads
+	versionLeaseTimes = 2
+	// versionVarint writes binary payload integers and lengths as varints
+	versionVarint = 3
+)
+
+var headerMagic = [4]byte{'S', 'W', 'A', 'L'}
+
+// Header flags
+const (
+	// headerFlagJSON marks a file whose payloads use EncodingJSON
+	headerFlagJSON = 0x01
+	// headerFlagSequence marks a file whose frames carry a sequence number
+	headerFlagSequence = 0x02
+	// headerFlagFrameChecksum marks a file whose checksums cover the length
+	// prefix, see ChecksumScopeFrame
+	headerFlagFrameChecksum = 0x04
+)
+
+// fileHeader describes the framing of the records in one log file
+type fileHeader struct {
+	version  uint8
+	checksum ChecksumType
+	flags    uint8
+}
+
+// newFileHeader returns the header for a new file written by this package
+func newFileHeader(checksum ChecksumType, encoding Encoding, sequenced bool, scope ChecksumScope) fileHeader {
+	h := fileHeader{version: formatVersion, checksum: checksum}
+	if encoding == EncodingJSON {
+		h.flags |= headerFlagJSON
+	}
+	if sequenced {
+		h.flags |= headerFlagSequence
+	}
+	if scope == ChecksumScopeFrame {
+		h.flags |= headerFlagFrameChecksum
+	}
+	return h
+}
+
+// checksumScope returns what the record checksums of the file cover
+func (h fileHeader) checksumScope() ChecksumScope {
+	if h.flags&headerFlagFrameChecksum != 0 {
+		return ChecksumScopeFrame
+	}
+	return ChecksumScopePayload
+}
+
+// sequenced reports whether the frames of the file carry sequence numbers
+func (h fileHeader) sequenced() bool {
+	return h.flags&headerFlagSequence != 0
+}
+
+// frameFormat returns the parts of the frame format fixed by the header
+func (h fileHeader) frameFormat() frameFormat {
+	return frameFormat{
+		version:   h.version,
+		checksum:  h.checksum,
+		scope:     h.checksumScope(),
+		encoding:  h.encoding(),
+		sequenced: h.sequenced(),
+	}
+}
+
+// encoding returns the payload encoding of the file
+func (h fileHeader) encoding() Encoding {
+	if h.flags&headerFlagJSON != 0 {
+		return EncodingJSON
+	}
+	return EncodingBinary
+}
+
+// encode serializes the header
+func (h fileHeader) encode() []byte {
+	buf := make([]byte, 0, headerSize)
+	buf = append(buf, headerMagic[:]...)
+	return append(buf, h.version, byte(h.checksum), h.flags)
+}
+
+// readHeader reads and validates the header at the start of r
+func readHeader(r io.Reader) (fileHeader, error) {
+	var buf [headerSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return fileHeader{}, fmt.Errorf("%w: failed to read header: %v", ErrCorruptedLog, err)
+	}
+
+	if [4]byte(buf[:4]) != headerMagic {
+		return fileHeader{}, fmt.Errorf("%w: bad magic %q", ErrCorruptedLog, buf[:4])
+	}
+
+	h := fileHeader{version: buf[4], checksum: ChecksumType(buf[5]), flags: buf[6]}
+	if h.version < versionInitial || h.version > formatVersion {
+		return fileHeader{}, fmt.Errorf("%w: version %d", ErrUnsupportedVersion, h.version)
+	}
+	if !h.checksum.valid() {
+		return fileHeader{}, fmt.Errorf("%w: unknown checksum type %d", ErrCorruptedLog, h.checksum)
+	}
+
+	return h, nil
+}
+
+// writeHeader writes the header to an empty file and makes it durable
+func writeHeader(file *os.File, h fileHeader) error {
+	if _, err := file.Write(h.encode()); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync header: %w", err)
+	}
+
+	return nil
+}
+
+// initFile writes a header to a freshly created file, or reads the header
+// of an existing one. The file position is left at the end
+// Returns the header in effect and the file size
+func initFile(file *os.File, h fileHeader) (fileHeader, int64, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return fileHeader{}, 0, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+
+	if stat.Size() == 0 {
+		if err := writeHeader(file, h); err != nil {
+			return fileHeader{}, 0, err
+		}
+		return h, headerSize, nil
+	}
+
+	return readFileHeader(file)
+}
+
+// readFileHeader reads the header of an existing file without moving its
+// position. Returns the header and the file size
+func readFileHeader(file *os.File) (fileHeader, int64, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return fileHeader{}, 0, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+
+	h, err := readHeader(io.NewSectionReader(file, 0, stat.Size()))
+	if err != nil {
+		return fileHeader{}, 0, err
+	}
+
+	return h, stat.Size(), nil
+}
@@ -0,0 +1,70 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestHeaderWrittenToNewFile(t *testing.T) {
+	w := openTestWAL(t, Config{Checksum: ChecksumCRC32C, SequenceNumbers: true})
+
+	data, err := os.ReadFile(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{'S', 'W', 'A', 'L', formatVersion, byte(ChecksumCRC32C), headerFlagSequence}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("new file holds % x, want % x", data, want)
+	}
+	if w.Offset() != headerSize {
+		t.Fatalf("Offset = %d, want %d", w.Offset(), headerSize)
+	}
+}
+
+func TestHeaderReopen(t *testing.T) {
+	w := openTestWAL(t, Config{Checksum: ChecksumXXHash64})
+	mustAppend(t, w, taskCreated("a"))
+
+	// The file keeps its own header whatever is configured on reopen
+	w = reopenTestWAL(t, w, Config{Checksum: ChecksumCRC32, Encoding: EncodingJSON})
+	if w.header != newFileHeader(ChecksumXXHash64, EncodingBinary, false, ChecksumScopePayload) {
+		t.Fatalf("header after reopen = %+v", w.header)
+	}
+	mustAppend(t, w, taskCreated("b"))
+	if got := len(mustReplay(t, w)); got != 2 {
+		t.Fatalf("replayed %d records, want 2", got)
+	}
+}
+
+func TestHeaderRejected(t *testing.T) {
+	valid := newFileHeader(ChecksumCRC32, EncodingBinary, false, ChecksumScopePayload).encode()
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"truncated", valid[:5], ErrCorruptedLog},
+		{"garbage", []byte("not a write-ahead log"), ErrCorruptedLog},
+		{"future version", []byte{'S', 'W', 'A', 'L', formatVersion + 1, byte(ChecksumCRC32), 0}, ErrUnsupportedVersion},
+		{"version 0", []byte{'S', 'W', 'A', 'L', 0, byte(ChecksumCRC32), 0}, ErrUnsupportedVersion},
+		{"unknown checksum", []byte{'S', 'W', 'A', 'L', formatVersion, 99, 0}, ErrCorruptedLog},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := testPath(t)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			w, err := Open(Config{FilePath: path})
+			if err == nil {
+				w.Close()
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Open = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHealthCheckHealthy(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, syncer: syncer})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	end := w.Offset()
+
+	if err := w.HealthCheck(); err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+
+	// Pending records and the probe were both synced
+	if got := syncer.count(); got != 2 {
+		t.Fatalf("HealthCheck synced %d times, want 2", got)
+	}
+	if w.synced != w.appended {
+		t.Fatalf("HealthCheck left %d records unsynced", w.appended-w.synced)
+	}
+
+	// The log gains no record and the probe file is gone
+	if w.Offset() != end {
+		t.Fatalf("offset = %d after HealthCheck, want %d", w.Offset(), end)
+	}
+	if got, want := taskIDs(mustReplay(t, w)), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v after HealthCheck, want %v", got, want)
+	}
+	entries, err := os.ReadDir(filepath.Dir(w.filePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".probe" {
+			t.Fatalf("probe file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestHealthCheckFailingSync(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{syncer: syncer})
+	mustAppend(t, w, taskCreated("a"))
+
+	injected := errors.New("injected fsync failure")
+	syncer.fail(injected)
+	if err := w.HealthCheck(); !errors.Is(err, injected) {
+		t.Fatalf("HealthCheck with a failing fsync = %v, want it wrapped", err)
+	}
+
+	syncer.fail(nil)
+	if err := w.HealthCheck(); err != nil {
+		t.Fatalf("HealthCheck after fsync recovered: %v", err)
+	}
+}
+
+func TestHealthCheckUnwritableLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if err := r.HealthCheck(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("HealthCheck of a read-only log = %v, want ErrReadOnly", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.HealthCheck(); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("HealthCheck of a closed log = %v, want ErrWALClosed", err)
+	}
+}
+
+func TestHealthCheckProbeNotCreatable(t *testing.T) {
+	w := openTestWAL(t, Config{})
+
+	// A directory in the probe's place keeps it from being created
+	if err := os.Mkdir(w.filePath+".probe", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.HealthCheck(); err == nil {
+		t.Fatal("HealthCheck succeeded without a writable probe file")
+	}
+}
@@ -0,0 +1,182 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTime is the fixed time tests stamp records with
+var testTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// testClock always returns testTime, so stamped records compare equal
+func testClock() time.Time {
+	return testTime
+}
+
+// testPath returns the path of a log in a fresh temporary directory
+func testPath(t testing.TB) string {
+	t.Helper()
+	return filepath.Join(t.TempDir(), "test.wal")
+}
+
+// openTestWAL opens a log with config, defaulting FilePath to a fresh
+// temporary file and Clock to testClock. The log is closed on cleanup
+func openTestWAL(t testing.TB, config Config) *WAL {
+	t.Helper()
+	if config.FilePath == "" {
+		config.FilePath = testPath(t)
+	}
+	if config.Clock == nil {
+		config.Clock = testClock
+	}
+
+	w, err := Open(config)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// reopenTestWAL closes w and opens the same log again with config
+func reopenTestWAL(t testing.TB, w *WAL, config Config) *WAL {
+	t.Helper()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	config.FilePath = w.filePath
+	return openTestWAL(t, config)
+}
+
+// mustAppend appends records one by one
+func mustAppend(t testing.TB, w *WAL, records ...Record) {
+	t.Helper()
+	for _, record := range records {
+		if err := w.Append(record); err != nil {
+			t.Fatalf("Append %s: %v", record.Type, err)
+		}
+	}
+}
+
+// mustReplay returns every record of the log
+func mustReplay(t testing.TB, w *WAL) []Record {
+	t.Helper()
+	var records []Record
+	if err := w.Replay(func(record Record) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return records
+}
+
+// recordTypes returns the type of each record
+func recordTypes(records []Record) []RecordType {
+	types := make([]RecordType, len(records))
+	for i, record := range records {
+		types[i] = record.Type
+	}
+	return types
+}
+
+// countingSyncer counts fsyncs and optionally slows or fails them
+type countingSyncer struct {
+	mu    sync.Mutex
+	syncs int
+	delay time.Duration // added to every sync, so concurrent callers overlap
+	err   error         // returned instead of syncing when set
+}
+
+func (s *countingSyncer) Sync(file *os.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.syncs++
+	time.Sleep(s.delay)
+	return file.Sync()
+}
+
+// fail makes later syncs return err, or succeed again if err is nil
+func (s *countingSyncer) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *countingSyncer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncs
+}
+
+// Record builders for tests
+
+func taskCreated(taskID string) Record {
+	return Record{Type: RecordTypeTaskCreated, Payload: TaskCreatedPayload{
+		TaskID:          taskID,
+		Payload:         []byte("payload-" + taskID),
+		ExecutionWindow: time.Minute,
+		RetryPolicy:     RetryPolicy{MaxRetries: 1},
+	}}
+}
+
+func leaseGranted(taskID, leaseID, workerID string, attempt int) Record {
+	return Record{Type: RecordTypeLeaseGranted, Payload: LeaseGrantedPayload{
+		TaskID:      taskID,
+		LeaseID:     leaseID,
+		WorkerID:    workerID,
+		Attempt:     attempt,
+		LeaseExpiry: testTime.Add(time.Minute),
+	}}
+}
+
+func leaseExtended(leaseID string, expiry time.Time) Record {
+	return Record{Type: RecordTypeLeaseExtended, Payload: LeaseExtendedPayload{
+		LeaseID:        leaseID,
+		NewLeaseExpiry: expiry,
+	}}
+}
+
+func leaseExpired(taskID, leaseID string) Record {
+	return Record{Type: RecordTypeLeaseExpired, Payload: LeaseExpiredPayload{TaskID: taskID, LeaseID: leaseID}}
+}
+
+func taskCompleted(taskID, leaseID string) Record {
+	return Record{Type: RecordTypeTaskCompleted, Payload: TaskCompletedPayload{TaskID: taskID, LeaseID: leaseID}}
+}
+
+func taskFailed(taskID, leaseID, reason string) Record {
+	return Record{Type: RecordTypeTaskFailed, Payload: TaskFailedPayload{TaskID: taskID, LeaseID: leaseID, FailureReason: reason}}
+}
+
+func taskCancelled(taskID, leaseID string) Record {
+	return Record{Type: RecordTypeTaskCancelled, Payload: TaskCancelledPayload{TaskID: taskID, LeaseID: leaseID}}
+}
+
+func taskDead(taskID, reason string) Record {
+	return Record{Type: RecordTypeTaskDead, Payload: TaskDeadPayload{TaskID: taskID, Reason: reason}}
+}
+
+func taskRetried(taskID, previousLeaseID string, attempt int) Record {
+	return Record{Type: RecordTypeTaskRetried, Payload: TaskRetriedPayload{
+		TaskID:          taskID,
+		PreviousLeaseID: previousLeaseID,
+		Attempt:         attempt,
+		Reason:          "retry",
+	}}
+}
+
+func checkpoint(offset int64) Record {
+	return Record{Type: RecordTypeCheckpoint, Payload: CheckpointPayload{Offset: offset, Timestamp: testTime}}
+}
+
+func annotation(text string) Record {
+	return Record{Type: RecordTypeAnnotation, Payload: AnnotationPayload{Text: text, Author: "test"}}
+}
@@ -0,0 +1,79 @@
+package wal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexReadAtOutOfOrder(t *testing.T) {
+	w := openTestWAL(t, Config{MaxSegmentBytes: 300})
+	records := sampleRecords()
+	mustAppend(t, w, records...)
+
+	idx, err := w.BuildIndex()
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	if idx.Len() != len(records) {
+		t.Fatalf("Len = %d, want %d", idx.Len(), len(records))
+	}
+
+	for _, n := range []int{7, 0, len(records) - 1, 3, 3} {
+		record, err := idx.ReadAt(n)
+		if err != nil {
+			t.Fatalf("ReadAt(%d): %v", n, err)
+		}
+		if !reflect.DeepEqual(record, records[n]) {
+			t.Fatalf("ReadAt(%d) = %+v, want %+v", n, record, records[n])
+		}
+	}
+	for _, n := range []int{-1, len(records)} {
+		if _, err := idx.ReadAt(n); err == nil {
+			t.Fatalf("ReadAt(%d) succeeded", n)
+		}
+	}
+}
+
+func TestIndexReadByType(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("a"),
+		leaseGranted("a", "l1", "w", 1),
+		taskCreated("b"),
+		taskCompleted("a", "l1"),
+		taskCreated("c"),
+	)
+
+	idx, err := w.BuildIndex()
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	created, err := idx.ReadByType(RecordTypeTaskCreated)
+	if err != nil {
+		t.Fatalf("ReadByType: %v", err)
+	}
+	var ids []string
+	for _, record := range created {
+		ids = append(ids, record.Payload.(TaskCreatedPayload).TaskID)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b", "c"}) {
+		t.Fatalf("TaskCreated records = %v, want [a b c]", ids)
+	}
+	if dead, err := idx.ReadByType(RecordTypeTaskDead); err != nil || len(dead) != 0 {
+		t.Fatalf("ReadByType(TaskDead) = %v, %v", dead, err)
+	}
+}
+
+func TestIndexIsSnapshot(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+
+	idx, err := w.BuildIndex()
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	mustAppend(t, w, taskCreated("b"))
+	if idx.Len() != 1 {
+		t.Fatalf("Len = %d after an append, want the snapshot's 1", idx.Len())
+	}
+}
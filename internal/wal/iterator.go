@@ -0,0 +1,205 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Iterator streams records from the start of the log, sealed segments
+// first, decoding one record per call to Next
+// It reads through its own file handles, so it never moves the append
+// position and may be used while appends continue
+//
+//	it, err := w.NewIterator()
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		record := it.Record()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Unlike Replay, a torn final record is not silently discarded: it ends
+// iteration and is reported by Err as ErrPartialWrite or ErrInvalidChecksum
+type Iterator struct {
+	paths  []string // sealed segments not yet opened, oldest first
+	active *os.File // the active file, opened up front since Rotate renames it
+	file   *os.File
+	reader *bufio.Reader
+	format frameFormat
+	aead   cipher.AEAD
+	limit  int    // maximum record size
+	mmap   bool   // map sealed segments, see Config.UseMmap
+	mapped []byte // mapping of the current file, if any
+	record Record
+	err    error
+}
+
+// NewIterator returns an iterator positioned before the first record
+// Records appended before the call are guaranteed to be visible, even if
+// the log is rotated before the iterator reaches them
+func (w *WAL) NewIterator() (*Iterator, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil, ErrWALClosed
+	}
+
+	// Buffered records must be visible to the reader
+	if err := w.flushLocked(); err != nil {
+		return nil, err
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := os.Open(w.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", w.filePath, err)
+	}
+
+	return &Iterator{
+		paths:  segments,
+		active: active,
+		aead:   w.aead,
+		limit:  w.maxRecordSize,
+		mmap:   w.useMmap,
+	}, nil
+}
+
+// Next advances to the next record, which is then available via Record
+// It returns false at the end of the log or on error; check Err to tell
+// them apart
+func (it *Iterator) Next() bool {
+	for it.err == nil {
+		if it.reader == nil && !it.openNext() {
+			return false
+		}
+
+		record, _, err := readRecord(it.reader, it.format)
+		if err == io.EOF {
+			it.closeFile()
+			continue
+		}
+		if err != nil {
+			it.err = fmt.Errorf("%s: %w", it.file.Name(), err)
+			return false
+		}
+
+		it.record = record
+		return true
+	}
+
+	return false
+}
+
+// Record returns the record read by the last successful call to Next
+func (it *Iterator) Record() Record {
+	return it.record
+}
+
+// Err returns the first error encountered during iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator's file handles
+// It is safe to call Close before iteration completes, and more than once
+func (it *Iterator) Close() error {
+	it.paths = nil
+	if it.active != nil {
+		it.active.Close()
+		it.active = nil
+	}
+	return it.closeFile()
+}
+
+// openNext opens the next file and reads its header
+// Returns false when there are no files left or on error
+func (it *Iterator) openNext() bool {
+	// The active file, always last, is never mapped: it may be truncated
+	// while the iterator is open
+	var file *os.File
+	sealed := len(it.paths) > 0
+	switch {
+	case sealed:
+		path := it.paths[0]
+		it.paths = it.paths[1:]
+
+		var err error
+		if file, err = os.Open(path); err != nil {
+			it.err = fmt.Errorf("failed to open %s: %w", path, err)
+			return false
+		}
+	case it.active != nil:
+		file, it.active = it.active, nil
+	default:
+		return false
+	}
+	path := file.Name()
+
+	var in io.Reader = file
+	if it.mmap && sealed {
+		mapped, err := mapSegment(file)
+		if err != nil && !errors.Is(err, errMmapUnsupported) {
+			file.Close()
+			it.err = err
+			return false
+		}
+		if err == nil {
+			it.mapped = mapped
+			in = bytes.NewReader(mapped)
+		}
+	}
+
+	reader := bufio.NewReader(in)
+	header, err := readHeader(reader)
+	if err != nil {
+		unmapFile(it.mapped)
+		it.mapped = nil
+		file.Close()
+		it.err = fmt.Errorf("%s: %w", path, err)
+		return false
+	}
+
+	it.file = file
+	it.reader = reader
+	it.format = header.frameFormat()
+	it.format.aead = it.aead
+	it.format.maxRecordSize = it.limit
+	return true
+}
+
+// closeFile closes the current file, if any
+func (it *Iterator) closeFile() error {
+	if it.file == nil {
+		return nil
+	}
+
+	unmapFile(it.mapped)
+	it.mapped = nil
+
+	err := it.file.Close()
+	it.file = nil
+	it.reader = nil
+	return err
+}
+
+// mapSegment maps the whole of a sealed segment file
+func mapSegment(file *os.File) ([]byte, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", file.Name(), err)
+	}
+	return mapFile(file, stat.Size())
+}
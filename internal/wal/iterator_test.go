@@ -0,0 +1,93 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestIteratorFullIteration(t *testing.T) {
+	w := openTestWAL(t, Config{MaxSegmentBytes: 200})
+	appendTasks(t, w, 10)
+
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	defer it.Close()
+
+	n := 0
+	for it.Next() {
+		if got, want := it.Record().Payload.(TaskCreatedPayload).TaskID, fmt.Sprintf("t%d", n); got != want {
+			t.Fatalf("record %d is task %s, want %s", n, got, want)
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("iterated %d records, want 10", n)
+	}
+}
+
+func TestIteratorEarlyTermination(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 5)
+
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if !it.Next() {
+			t.Fatalf("Next %d: %v", i, it.Err())
+		}
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	// Stopping early leaves the append position alone
+	mustAppend(t, w, taskCreated("t5"))
+	if got := len(mustReplay(t, w)); got != 6 {
+		t.Fatalf("replayed %d records, want 6", got)
+	}
+}
+
+func TestIteratorTornFinalRecord(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(w.filePath, stat.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	it, err := r.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	defer it.Close()
+
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("iterated %d records, want 2", n)
+	}
+	if err := it.Err(); !errors.Is(err, ErrPartialWrite) {
+		t.Fatalf("Err = %v, want ErrPartialWrite", err)
+	}
+}
@@ -0,0 +1,135 @@
+package wal
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Encoding selects how record payloads are serialized
+type Encoding uint8
+
+const (
+	// EncodingBinary is the compact format described by encodePayload
+	EncodingBinary Encoding = iota
+	// EncodingJSON writes each payload as a JSON object tagged with its
+	// record type, for logs that need to be readable with standard tools
+	// Framing and checksums are unchanged
+	EncodingJSON
+)
+
+// valid reports whether e is a known encoding
+func (e Encoding) valid() bool {
+	return e == EncodingBinary || e == EncodingJSON
+}
+
+var recordTypeNames = map[RecordType]string{
+	RecordTypeTaskCreated:        "TaskCreated",
+	RecordTypeTaskCompleted:      "TaskCompleted",
+	RecordTypeTaskFailed:         "TaskFailed",
+	RecordTypeTaskCancelled:      "TaskCancelled",
+	RecordTypeLeaseGranted:       "LeaseGranted",
+	RecordTypeLeaseExtended:      "LeaseExtended",
+	RecordTypeLeaseExpired:       "LeaseExpired",
+	RecordTypeTaskDead:           "TaskDead",
+	RecordTypeTaskRetried:        "TaskRetried",
+	RecordTypeCheckpoint:         "Checkpoint",
+	RecordTypeLeaseRenewalDenied: "LeaseRenewalDenied",
+	RecordTypeAnnotation:         "Annotation",
+	RecordTypeTaskRescheduled:    "TaskRescheduled",
+}
+
+// String returns the name of the record type as used in the design docs
+func (t RecordType) String() string {
+	if name, ok := recordTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("RecordType(%d)", uint8(t))
+}
+
+// jsonRecord is the JSON form of a record payload
+type jsonRecord struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// encodePayloadAs serializes the payload of a record with the given encoding
+// for a file of the given format version
+func encodePayloadAs(encoding Encoding, version uint8, record Record) ([]byte, error) {
+	if encoding != EncodingJSON {
+		return encodePayload(record, version)
+	}
+
+	// The binary encoder doubles as the type check
+	data, err := encodePayload(record, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Custom payloads are only known to their codec, so their bytes are
+	// embedded as is
+	var payload []byte
+	if record.Type.custom() {
+		payload, err = json.Marshal(data)
+	} else {
+		payload, err = json.Marshal(record.Payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	return json.Marshal(jsonRecord{Type: record.Type.String(), Payload: payload})
+}
+
+// decodePayloadAs deserializes a payload written with the given encoding
+// to a file of the given format version
+// JSON payloads need no version: fields they lack keep their zero value
+func decodePayloadAs(encoding Encoding, version uint8, recordType RecordType, data []byte) (interface{}, error) {
+	if encoding != EncodingJSON {
+		return decodePayload(recordType, data, version)
+	}
+
+	var jr jsonRecord
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptedLog, err)
+	}
+	if jr.Type != recordType.String() {
+		return nil, fmt.Errorf("%w: JSON type %q does not match record type %s", ErrCorruptedLog, jr.Type, recordType)
+	}
+
+	if recordType.custom() {
+		var data []byte
+		if err := json.Unmarshal(jr.Payload, &data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptedLog, err)
+		}
+		return decodePayload(recordType, data, version)
+	}
+
+	target, ok := jsonPayloadTypes[recordType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown record type %d", ErrCorruptedLog, recordType)
+	}
+	payload := reflect.New(target)
+	if err := json.Unmarshal(jr.Payload, payload.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptedLog, err)
+	}
+
+	return payload.Elem().Interface(), nil
+}
+
+// jsonPayloadTypes maps each record type to its payload type
+var jsonPayloadTypes = map[RecordType]reflect.Type{
+	RecordTypeTaskCreated:        reflect.TypeOf(TaskCreatedPayload{}),
+	RecordTypeTaskCompleted:      reflect.TypeOf(TaskCompletedPayload{}),
+	RecordTypeTaskFailed:         reflect.TypeOf(TaskFailedPayload{}),
+	RecordTypeTaskCancelled:      reflect.TypeOf(TaskCancelledPayload{}),
+	RecordTypeLeaseGranted:       reflect.TypeOf(LeaseGrantedPayload{}),
+	RecordTypeLeaseExtended:      reflect.TypeOf(LeaseExtendedPayload{}),
+	RecordTypeLeaseExpired:       reflect.TypeOf(LeaseExpiredPayload{}),
+	RecordTypeTaskDead:           reflect.TypeOf(TaskDeadPayload{}),
+	RecordTypeTaskRetried:        reflect.TypeOf(TaskRetriedPayload{}),
+	RecordTypeCheckpoint:         reflect.TypeOf(CheckpointPayload{}),
+	RecordTypeLeaseRenewalDenied: reflect.TypeOf(LeaseRenewalDeniedPayload{}),
+	RecordTypeAnnotation:         reflect.TypeOf(AnnotationPayload{}),
+	RecordTypeTaskRescheduled:    reflect.TypeOf(TaskRescheduledPayload{}),
+}
@@ -0,0 +1,65 @@
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestJSONEncodingRoundTrip(t *testing.T) {
+	w := openTestWAL(t, Config{Encoding: EncodingJSON})
+	mustAppend(t, w, sampleRecords()...)
+
+	w = reopenTestWAL(t, w, Config{})
+	if w.header.encoding() != EncodingJSON {
+		t.Fatal("reopened log is not JSON-encoded")
+	}
+	records := mustReplay(t, w)
+	for i, want := range sampleRecords() {
+		if !reflect.DeepEqual(records[i], want) {
+			t.Fatalf("%s decoded as %+v, want %+v", want.Type, records[i], want)
+		}
+	}
+}
+
+func TestJSONEncodingIsReadable(t *testing.T) {
+	w := openTestWAL(t, Config{Encoding: EncodingJSON})
+	mustAppend(t, w, sampleRecords()...)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Strip the framing: length prefix and type byte before the payload,
+	// checksum after it
+	var types []string
+	for rest := data[headerSize:]; len(rest) > 0; {
+		length := int(binary.LittleEndian.Uint32(rest))
+		frame := rest[lengthPrefixSize : lengthPrefixSize+length]
+		payload := frame[recordTypeSize : length-ChecksumCRC32.size()]
+		rest = rest[lengthPrefixSize+length:]
+
+		var object struct {
+			Type    string                 `json:"type"`
+			Payload map[string]interface{} `json:"payload"`
+		}
+		if err := json.Unmarshal(payload, &object); err != nil {
+			t.Fatalf("payload %q is not JSON: %v", payload, err)
+		}
+		types = append(types, object.Type)
+	}
+
+	var want []string
+	for _, record := range sampleRecords() {
+		want = append(want, record.Type.String())
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("JSON type tags = %v, want %v", types, want)
+	}
+}
@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of histogram buckets. Bucket i counts
+// durations below 2^i nanoseconds that did not fit bucket i-1; the last
+// bucket, from about 4.6 minutes, takes everything longer
+const latencyBuckets = 40
+
+// latencyHistogram is an exponentially bucketed histogram of durations
+// Observations only increment an atomic counter, so it is safe to update
+// without w.mu and never allocates
+type latencyHistogram struct {
+	buckets [latencyBuckets]atomic.Uint64
+}
+
+// observe records one duration
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	if d > 0 {
+		i = min(bits.Len64(uint64(d)), latencyBuckets-1)
+	}
+	h.buckets[i].Add(1)
+}
+
+// summary returns the count and percentiles of the recorded durations
+// Percentiles are reported as the upper bound of their bucket, so they are
+// accurate to within a factor of two
+func (h *latencyHistogram) summary() LatencySummary {
+	var counts [latencyBuckets]uint64
+	var total uint64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+
+	return LatencySummary{
+		Count: total,
+		P50:   percentile(&counts, total, 50),
+		P99:   percentile(&counts, total, 99),
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the pth
+// percentile of total observations, or 0 if there are none
+func percentile(counts *[latencyBuckets]uint64, total uint64, p uint64) time.Duration {
+	if total == 0 {
+		return 0
+	}
+
+	rank := (total*p + 99) / 100 // observations at or below the percentile
+	var seen uint64
+	for i, n := range counts {
+		if seen += n; seen >= rank {
+			return time.Duration(1) << i
+		}
+	}
+	return time.Duration(1) << (latencyBuckets - 1)
+}
+
+// LatencySummary describes a latency distribution
+type LatencySummary struct {
+	Count uint64
+	P50   time.Duration
+	P99   time.Duration
+}
+
+// LatencyStats reports the latency of successful appends and fsyncs since
+// Open. Append latency spans the whole call, including any fsync the sync
+// policy triggers; a batch counts once
+type LatencyStats struct {
+	Append LatencySummary
+	Sync   LatencySummary
+}
+
+// LatencyStats returns the append and sync latency distributions
+// It does not take the lock
+func (w *WAL) LatencyStats() LatencyStats {
+	return LatencyStats{
+		Append: w.appendLatency.summary(),
+		Sync:   w.syncLatency.summary(),
+	}
+}
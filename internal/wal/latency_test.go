@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	var h latencyHistogram
+	if got := h.summary(); got != (LatencySummary{}) {
+		t.Fatalf("empty summary = %+v, want zero", got)
+	}
+
+	// 98 fast observations and two slow ones: p50 is fast, p99 is slow
+	for i := 0; i < 98; i++ {
+		h.observe(100 * time.Microsecond)
+	}
+	h.observe(50 * time.Millisecond)
+	h.observe(50 * time.Millisecond)
+
+	got := h.summary()
+	if got.Count != 100 {
+		t.Fatalf("count = %d, want 100", got.Count)
+	}
+	// Percentiles are bucket upper bounds, so within a factor of two above
+	if got.P50 < 100*time.Microsecond || got.P50 >= 200*time.Microsecond {
+		t.Fatalf("p50 = %v, want in [100µs, 200µs)", got.P50)
+	}
+	if got.P99 < 50*time.Millisecond || got.P99 >= 100*time.Millisecond {
+		t.Fatalf("p99 = %v, want in [50ms, 100ms)", got.P99)
+	}
+}
+
+func TestLatencyHistogramExtremes(t *testing.T) {
+	var h latencyHistogram
+	h.observe(0)
+	h.observe(-time.Second)
+	if got := h.summary(); got.Count != 2 || got.P99 != 1 {
+		t.Fatalf("summary of non-positive durations = %+v, want count 2 and p99 1ns", got)
+	}
+
+	h = latencyHistogram{}
+	h.observe(time.Hour)
+	if got, want := h.summary().P50, time.Duration(1)<<(latencyBuckets-1); got != want {
+		t.Fatalf("p50 of an hour = %v, want the last bucket bound %v", got, want)
+	}
+}
+
+func TestLatencyHistogramObserveDoesNotAllocate(t *testing.T) {
+	var h latencyHistogram
+	if allocs := testing.AllocsPerRun(1000, func() { h.observe(time.Millisecond) }); allocs != 0 {
+		t.Fatalf("observe allocated %v times per call, want 0", allocs)
+	}
+}
+
+func TestLatencyStatsTrackAppendsAndSyncs(t *testing.T) {
+	// The syncer's delay stands in for a slow disk, fixing the sync latency
+	const delay = 2 * time.Millisecond
+	w := openTestWAL(t, Config{syncer: &countingSyncer{delay: delay}})
+	for i := 0; i < 50; i++ {
+		mustAppend(t, w, taskCreated("t"))
+	}
+
+	stats := w.LatencyStats()
+	if stats.Append.Count != 50 || stats.Sync.Count != 50 {
+		t.Fatalf("counts = %d appends, %d syncs, want 50 each", stats.Append.Count, stats.Sync.Count)
+	}
+	if stats.Sync.P50 < delay || stats.Sync.P50 > time.Second {
+		t.Fatalf("sync p50 = %v, want at least %v and sane", stats.Sync.P50, delay)
+	}
+	// Every append waited for its fsync
+	if stats.Append.P50 < stats.Sync.P50/2 {
+		t.Fatalf("append p50 = %v below the sync p50 %v it includes", stats.Append.P50, stats.Sync.P50)
+	}
+	if stats.Append.P99 < stats.Append.P50 || stats.Sync.P99 < stats.Sync.P50 {
+		t.Fatalf("p99 below p50: %+v", stats)
+	}
+}
+
+func TestLatencyStatsSkipFailedSyncs(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{syncer: syncer})
+	mustAppend(t, w, taskCreated("t0"))
+
+	syncer.fail(errors.New("injected fsync failure"))
+	if err := w.Append(taskCreated("t1")); err == nil {
+		t.Fatal("Append with a failing sync succeeded")
+	}
+
+	stats := w.LatencyStats()
+	if stats.Append.Count != 1 || stats.Sync.Count != 1 {
+		t.Fatalf("counts = %d appends, %d syncs after a failed sync, want 1 each", stats.Append.Count, stats.Sync.Count)
+	}
+}
@@ -0,0 +1,47 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+)
+
+// enforceLocked applies records to the live state kept for
+// Config.EnforceLifecycle, so a record that would fail replay is rejected
+// before it is written. It is a no-op when enforcement is disabled
+// On a violation the state is left as before the call
+// Callers must hold w.mu
+func (w *WAL) enforceLocked(records []Record) error {
+	if w.state == nil {
+		return nil
+	}
+
+	for i, record := range records {
+		if err := ApplyRecord(record, w.state); err != nil {
+			if i > 0 {
+				// Earlier records of the batch were applied but will not
+				// be written
+				if reloadErr := w.loadStateLocked(); reloadErr != nil {
+					return fmt.Errorf("%w (failed to reload state: %v)", err, reloadErr)
+				}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadStateLocked rebuilds the live state from the whole log
+// Callers must hold w.mu
+func (w *WAL) loadStateLocked() error {
+	state := NewState()
+	err := w.replayAllLocked(context.Background(), applyOnly(func(record Record) error {
+		return ApplyRecord(record, state)
+	}))
+	if err != nil {
+		return err
+	}
+
+	w.state = state
+	return nil
+}
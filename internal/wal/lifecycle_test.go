@@ -0,0 +1,119 @@
+package wal
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestEnforceLifecycleRejectsBadTransitions(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w,
+		taskCreated("a"),
+		leaseGranted("a", "l1", "w", 1),
+		taskCreated("done"),
+		leaseGranted("done", "l2", "w", 1),
+		taskCompleted("done", "l2"),
+		taskCreated("dead"),
+		taskDead("dead", "operator"),
+		taskCreated("waiting"),
+	)
+
+	tests := []struct {
+		name   string
+		record Record
+	}{
+		{"complete unknown task", taskCompleted("ghost", "l9")},
+		{"complete waiting task", taskCompleted("waiting", "l9")},
+		{"complete with another lease", taskCompleted("a", "l2")},
+		{"double lease", leaseGranted("a", "l3", "w", 2)},
+		{"recreate task", taskCreated("a")},
+		{"complete terminal task", taskCompleted("done", "l2")},
+		{"lease dead task", leaseGranted("dead", "l4", "w", 1)},
+		{"expire unknown lease", leaseExpired("a", "l9")},
+	}
+	for _, tt := range tests {
+		before := w.Offset()
+		if err := w.Append(tt.record); !errors.Is(err, ErrInvariantViolation) {
+			t.Errorf("%s: Append = %v, want ErrInvariantViolation", tt.name, err)
+		}
+		if w.Offset() != before {
+			t.Errorf("%s: rejected record was written", tt.name)
+		}
+	}
+
+	// Valid transitions still go through after the rejections
+	mustAppend(t, w, taskCompleted("a", "l1"), leaseGranted("waiting", "l5", "w", 1))
+
+	state, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState of the enforced log: %v", err)
+	}
+	if !reflect.DeepEqual(state, w.state) {
+		t.Fatal("live state differs from a replay of the log")
+	}
+}
+
+func TestEnforceLifecycleDisabled(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if err := w.Append(taskCompleted("ghost", "l1")); err != nil {
+		t.Fatalf("Append without enforcement = %v, want it written", err)
+	}
+	if _, err := w.BuildState(); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("BuildState = %v, want the violation found on replay", err)
+	}
+}
+
+func TestEnforceLifecycleBatchIsAllOrNothing(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w, taskCreated("a"))
+	before := w.Offset()
+
+	// The second record is valid only after the first, the third never is
+	err := w.AppendBatch([]Record{
+		leaseGranted("a", "l1", "w", 1),
+		taskCompleted("a", "l1"),
+		taskCompleted("a", "l1"),
+	})
+	if !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("AppendBatch = %v, want ErrInvariantViolation", err)
+	}
+	if w.Offset() != before {
+		t.Fatal("part of a rejected batch was written")
+	}
+	if got, _ := w.state.TaskStatus("a"); got != TaskWaiting {
+		t.Fatalf("live state has a leased as %s after the rejected batch, want WAITING", got)
+	}
+
+	// The state was left as before, so the leading records are valid again
+	if err := w.AppendBatch([]Record{leaseGranted("a", "l1", "w", 1), taskCompleted("a", "l1")}); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+}
+
+func TestEnforceLifecycleStateSurvivesReopen(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+
+	w = reopenTestWAL(t, w, Config{EnforceLifecycle: true})
+	if err := w.Append(leaseGranted("a", "l2", "w", 2)); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("double lease after reopen = %v, want ErrInvariantViolation", err)
+	}
+	mustAppend(t, w, taskCompleted("a", "l1"))
+}
+
+func TestEnforceLifecycleFailedWriteNotApplied(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w, taskCreated("a"))
+
+	// The grant passes enforcement but never reaches the file
+	fillDisk(w, syscall.ENOSPC)
+	if err := w.Append(leaseGranted("a", "l1", "w", 1)); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Append on a full disk = %v, want ENOSPC", err)
+	}
+	if got, _ := w.state.TaskStatus("a"); got != TaskWaiting {
+		t.Fatalf("live state has a as %s after the failed write, want WAITING", got)
+	}
+	mustAppend(t, w, leaseGranted("a", "l1", "w", 1))
+}
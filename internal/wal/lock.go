@@ -0,0 +1,27 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockSuffix names the lock file next to the active log
+// A separate file keeps the lock stable across rotation and compaction,
+// which replace the active file
+const lockSuffix = ".lock"
+
+// acquireLock takes the exclusive writer lock for the log at path
+// Returns ErrLocked if another writer holds it
+func acquireLock(path string) (*os.File, error) {
+	file, err := os.OpenFile(path+lockSuffix, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
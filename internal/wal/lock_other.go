@@ -0,0 +1,10 @@
+//go:build !unix
+
+package wal
+
+import "os"
+
+// lockFile is a no-op where flock is unavailable
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,94 @@
+//go:build unix
+
+package wal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecondWriterIsLockedOut(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+
+	if second, err := Open(Config{FilePath: w.filePath}); !errors.Is(err, ErrLocked) {
+		if err == nil {
+			second.Close()
+		}
+		t.Fatalf("second Open = %v, want ErrLocked", err)
+	}
+
+	// Readers do not take the lock
+	reader := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if got := len(mustReplay(t, reader)); got != 1 {
+		t.Fatalf("reader replayed %d records, want 1", got)
+	}
+
+	// The first writer is unaffected by the failed open
+	mustAppend(t, w, taskCreated("b"))
+}
+
+func TestLockReleasedOnClose(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+
+	w = reopenTestWAL(t, w, Config{})
+	mustAppend(t, w, taskCreated("b"))
+	if got := len(mustReplay(t, w)); got != 2 {
+		t.Fatalf("replayed %d records, want 2", got)
+	}
+}
+
+func TestLockReleasedOnFailedRotation(t *testing.T) {
+	injected := errors.New("injected rotation failure")
+	w := openTestWAL(t, Config{rotateFault: injected})
+	mustAppend(t, w, taskCreated("a"))
+
+	if err := w.Rotate(); !errors.Is(err, injected) {
+		t.Fatalf("Rotate = %v, want the injected failure", err)
+	}
+	if err := w.Append(taskCreated("b")); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("Append after a failed rotation = %v, want ErrWALClosed", err)
+	}
+
+	// The log can be opened again in the same process
+	r := openTestWAL(t, Config{FilePath: w.filePath})
+	if got := taskIDs(mustReplay(t, r)); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("replayed %v after the failed rotation, want [a]", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close after a failed rotation: %v", err)
+	}
+}
+
+func TestCloseReleasesLockLeftBehind(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+
+	// A path that closes the active file without releasing the lock
+	w.mu.Lock()
+	w.file.Close()
+	w.file = nil
+	w.mu.Unlock()
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r := openTestWAL(t, Config{FilePath: w.filePath})
+	if got := len(mustReplay(t, r)); got != 1 {
+		t.Fatalf("replayed %d records, want 1", got)
+	}
+}
+
+func TestLockReleasedOnCrash(t *testing.T) {
+	w := openTestWAL(t, Config{crashAt: headerSize + 10})
+	if err := w.Append(taskCreated("a")); !errors.Is(err, errCrashed) {
+		t.Fatalf("Append = %v, want errCrashed", err)
+	}
+
+	// Recovery can run in the same process
+	r := openTestWAL(t, Config{FilePath: w.filePath})
+	if got := len(mustReplay(t, r)); got != 0 {
+		t.Fatalf("replayed %d records of a torn log, want 0", got)
+	}
+}
@@ -0,0 +1,174 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// The manifest lives next to the active log as <FilePath>.manifest and
+// lists the files of the log, so segments are found without listing the
+// directory. It is rewritten, atomically, whenever the set of sealed
+// segments changes, and rebuilt from a directory scan when it is missing
+// or does not match the files it lists, e.g. after a crash between a
+// rotation and its manifest update
+const manifestSuffix = ".manifest"
+
+// manifestVersion is the manifest layout written by this package
+const manifestVersion = 1
+
+// manifest lists the sealed segments of the log, oldest first, followed by
+// the active file
+// BytesWritten counts the bytes appended to the log before the records the
+// active file now holds, so TotalBytesWritten survives reopening: it only
+// changes when the manifest is saved with the active file cut or replaced
+type manifest struct {
+	Version      int             `json:"version"`
+	Files        []manifestEntry `json:"files"`
+	BytesWritten uint64          `json:"bytes_written"`
+}
+
+// manifestEntry describes one file of the log. Start and End locate it in
+// the whole log, headers included, counting from the oldest segment still
+// listed when the manifest was rebuilt
+type manifestEntry struct {
+	Seq    int   `json:"seq"` // for the active file, the sequence it gets when sealed
+	Start  int64 `json:"start"`
+	End    int64 `json:"end"` // 0 for the active file, which still grows
+	Sealed bool  `json:"sealed"`
+}
+
+// active returns the entry of the active file
+func (m *manifest) active() *manifestEntry {
+	return &m.Files[len(m.Files)-1]
+}
+
+// manifestPath returns the path of the manifest of the log at base
+func manifestPath(base string) string {
+	return base + manifestSuffix
+}
+
+// loadManifestLocked reads the manifest into w.manifest and sets
+// w.nextSegment from it, or rebuilds both by scanning the directory if the
+// manifest is missing or stale. A rebuilt manifest is saved unless the
+// log is read-only
+// Callers must hold w.mu or be opening the log
+func (w *WAL) loadManifestLocked() error {
+	m, err := w.readManifest()
+	if err != nil {
+		if m, err = w.scanManifest(); err != nil {
+			return err
+		}
+		if !w.readOnly {
+			if err := saveManifest(w.filePath, m); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.manifest = m
+	w.nextSegment = m.active().Seq
+	return nil
+}
+
+// readManifest reads and checks the manifest on disk. Every sealed segment
+// it lists must exist, and the segment the active file will become must
+// not, or the manifest missed a change to the directory
+func (w *WAL) readManifest() (manifest, error) {
+	data, err := os.ReadFile(manifestPath(w.filePath))
+	if err != nil {
+		return manifest{}, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return manifest{}, fmt.Errorf("%w: manifest: %v", ErrCorruptedLog, err)
+	}
+	if m.Version != manifestVersion || len(m.Files) == 0 || m.active().Sealed {
+		return manifest{}, fmt.Errorf("%w: malformed manifest", ErrCorruptedLog)
+	}
+
+	for i, entry := range m.Files {
+		if i > 0 && entry.Seq <= m.Files[i-1].Seq {
+			return manifest{}, fmt.Errorf("%w: manifest segments out of order", ErrCorruptedLog)
+		}
+		_, err := os.Stat(segmentPath(w.filePath, entry.Seq))
+		switch {
+		case entry.Sealed && err != nil:
+			return manifest{}, fmt.Errorf("manifest lists missing segment %d: %w", entry.Seq, err)
+		case !entry.Sealed && i != len(m.Files)-1:
+			return manifest{}, fmt.Errorf("%w: unsealed segment %d in manifest", ErrCorruptedLog, entry.Seq)
+		case !entry.Sealed && !errors.Is(err, fs.ErrNotExist):
+			return manifest{}, fmt.Errorf("%w: segment %d sealed after the manifest was written", ErrCorruptedLog, entry.Seq)
+		}
+	}
+
+	return m, nil
+}
+
+// scanManifest builds the manifest from the segments found in the
+// directory. The active file gets the sequence after the highest sealed or
+// archived one. The bytes written are estimated from the sealed segments'
+// sizes, since those of archived or compacted records are lost
+func (w *WAL) scanManifest() (manifest, error) {
+	segments, err := w.scanSegments()
+	if err != nil {
+		return manifest{}, err
+	}
+	last, err := w.lastSegmentSeq()
+	if err != nil {
+		return manifest{}, err
+	}
+
+	m := manifest{Version: manifestVersion}
+	var start int64
+	for _, path := range segments {
+		seq, err := segmentSeq(path)
+		if err != nil {
+			return manifest{}, err
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return manifest{}, fmt.Errorf("failed to stat segment %d: %w", seq, err)
+		}
+		m.Files = append(m.Files, manifestEntry{Seq: seq, Start: start, End: start + stat.Size(), Sealed: true})
+		m.BytesWritten += uint64(max(stat.Size()-headerSize, 0))
+		start += stat.Size()
+	}
+	m.Files = append(m.Files, manifestEntry{Seq: last + 1, Start: start})
+
+	return m, nil
+}
+
+// saveManifest atomically replaces the manifest of the log at base with m
+func saveManifest(base string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := writeFileAtomic(manifestPath(base), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// updateManifestLocked applies change to the manifest and saves it
+// The manifest in memory follows the segments even if saving fails; the
+// stale one on disk is then rebuilt by the next Open
+// Callers must hold w.mu
+func (w *WAL) updateManifestLocked(change func(m *manifest)) error {
+	change(&w.manifest)
+	return w.saveManifestLocked()
+}
+
+// saveManifestLocked saves the manifest with the bytes written before the
+// records of the active file, which must be called whenever the active
+// file is cut or replaced, see TotalBytesWritten
+// Callers must hold w.mu
+func (w *WAL) saveManifestLocked() error {
+	w.manifest.BytesWritten = w.written - uint64(w.offset-headerSize)
+	return saveManifest(w.filePath, w.manifest)
+}
@@ -0,0 +1,164 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// rotatedLog returns a log of n segments holding tasks t0 to t(n-1), one
+// per sealed segment, and t(n) in the active file
+func rotatedLog(t *testing.T, n int) *WAL {
+	t.Helper()
+	w := openTestWAL(t, Config{})
+	for i := 0; i < n; i++ {
+		mustAppend(t, w, taskCreated(fmt.Sprintf("t%d", i)))
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+	}
+	mustAppend(t, w, taskCreated(fmt.Sprintf("t%d", n)))
+	return w
+}
+
+// diskManifest reads the manifest of w from disk
+func diskManifest(t *testing.T, w *WAL) manifest {
+	t.Helper()
+	m, err := w.readManifest()
+	if err != nil {
+		t.Fatalf("readManifest: %v", err)
+	}
+	return m
+}
+
+func TestManifestFollowsRotation(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if got, want := diskManifest(t, w).Files, []manifestEntry{{Seq: 1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("manifest of a new log = %+v, want %+v", got, want)
+	}
+
+	w = rotatedLog(t, 3)
+	m := diskManifest(t, w)
+	if !reflect.DeepEqual(m, w.manifest) {
+		t.Fatalf("manifest on disk %+v differs from the WAL's %+v", m, w.manifest)
+	}
+	if len(m.Files) != 4 {
+		t.Fatalf("manifest lists %d files, want 3 segments and the active file", len(m.Files))
+	}
+
+	// Sealed segments, in order and back to back, then the active file
+	var start int64
+	for i, entry := range m.Files {
+		if entry.Seq != i+1 || entry.Start != start || entry.Sealed != (i < 3) {
+			t.Fatalf("entry %d = %+v", i, entry)
+		}
+		if !entry.Sealed {
+			break
+		}
+		if size := fileSize(t, segmentPath(w.filePath, entry.Seq)); entry.End-entry.Start != size {
+			t.Fatalf("entry %d spans %d bytes, segment is %d", i, entry.End-entry.Start, size)
+		}
+		start = entry.End
+	}
+}
+
+func TestManifestRebuiltWhenMissing(t *testing.T) {
+	w := rotatedLog(t, 3)
+	want := w.manifest.Files
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := os.Remove(manifestPath(w.filePath)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A read-only open rebuilds it in memory only
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if !reflect.DeepEqual(r.manifest.Files, want) {
+		t.Fatalf("rebuilt manifest = %+v, want %+v", r.manifest.Files, want)
+	}
+	if _, err := os.Stat(manifestPath(w.filePath)); !os.IsNotExist(err) {
+		t.Fatalf("read-only open saved a manifest: %v", err)
+	}
+
+	w = openTestWAL(t, Config{FilePath: w.filePath})
+	if got := diskManifest(t, w).Files; !reflect.DeepEqual(got, want) {
+		t.Fatalf("saved manifest = %+v, want %+v", got, want)
+	}
+	if got, want := taskIDs(mustReplay(t, w)), []string{"t0", "t1", "t2", "t3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestManifestRebuiltWhenInvalid(t *testing.T) {
+	w := rotatedLog(t, 2)
+	stale, err := os.ReadFile(manifestPath(w.filePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, w, taskCreated("late"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	want := w.manifest.Files
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		// As after a crash between a rotation and its manifest update
+		{"stale", string(stale)},
+		{"garbage", "not json"},
+		{"empty", `{"version":1,"files":[]}`},
+		{"other version", `{"version":9,"files":[{"seq":1}]}`},
+		{"out of order", `{"version":1,"files":[{"seq":2,"sealed":true},{"seq":1,"sealed":true},{"seq":4}]}`},
+		{"missing segment", `{"version":1,"files":[{"seq":7,"sealed":true},{"seq":8}]}`},
+	} {
+		if err := os.WriteFile(manifestPath(w.filePath), []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r := openTestWAL(t, Config{FilePath: w.filePath})
+		if !reflect.DeepEqual(r.manifest.Files, want) {
+			t.Fatalf("%s: rebuilt manifest = %+v, want %+v", tt.name, r.manifest.Files, want)
+		}
+		if got := len(mustReplay(t, r)); got != 4 {
+			t.Fatalf("%s: replayed %d records, want 4", tt.name, got)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+}
+
+func TestManifestOrdersSegments(t *testing.T) {
+	// Sequence numbers past 9 still replay in rotation order
+	w := rotatedLog(t, 12)
+	want := make([]string, 13)
+	for i := range want {
+		want[i] = fmt.Sprintf("t%d", i)
+	}
+	if got := taskIDs(mustReplay(t, w)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+
+	// A reader follows the writer's rotations through the manifest
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	mustAppend(t, w, taskCreated("t13"))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	segments, err := r.sealedSegments()
+	if err != nil {
+		t.Fatalf("sealedSegments: %v", err)
+	}
+	if len(segments) != 13 || segments[12] != segmentPath(w.filePath, 13) {
+		t.Fatalf("reader sees segments %v, want 1 to 13", segments)
+	}
+}
@@ -0,0 +1,188 @@
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Merge concatenates the records of the log files srcs, in order, into a
+// new log file at dst, which must not exist
+// Each source is verified first: a torn tail is skipped, while corruption
+// or a checksum type, checksum scope or payload encoding that differs from
+// the first source's rejects the merge
+// dst gets a header of the current format version. Records of sources in
+// an older version are re-encoded into it, which an encrypted record cannot
+// be without its key, so such sources are rejected; other frames keep their
+// stored payload, so compressed and encrypted records need no key. Sources
+// encrypted with different keys cannot be told apart and must not be mixed
+// dst is sequenced if any source is, and its records are then numbered
+// from 1 in merge order, since the sources' numbering may overlap
+// Each source is a single file; sealed segments must be listed explicitly
+func Merge(dst string, srcs ...string) error {
+	if len(srcs) == 0 {
+		return fmt.Errorf("merge: no source logs")
+	}
+
+	headers := make([]fileHeader, len(srcs))
+	ends := make([]int64, len(srcs))
+	for i, src := range srcs {
+		h, err := readHeaderFile(src)
+		if err != nil {
+			return fmt.Errorf("merge: %s: %w", filepath.Base(src), err)
+		}
+		first := headers[0]
+		if i > 0 && (h.checksum != first.checksum || h.checksumScope() != first.checksumScope() || h.encoding() != first.encoding()) {
+			return fmt.Errorf("merge: %s: checksum type %d, checksum scope or encoding differ from %s",
+				filepath.Base(src), h.checksum, filepath.Base(srcs[0]))
+		}
+		headers[i] = h
+
+		report, err := Verify(src)
+		if err != nil {
+			return fmt.Errorf("merge: %s: %w", filepath.Base(src), err)
+		}
+		if !report.Healthy() {
+			return fmt.Errorf("merge: %s: %w at offset %d: %s",
+				filepath.Base(src), ErrCorruptedLog, report.CorruptOffset, report.CorruptReason)
+		}
+		ends[i] = report.ValidBytes
+	}
+
+	sequenced := false
+	for _, h := range headers {
+		sequenced = sequenced || h.sequenced()
+	}
+	header := newFileHeader(headers[0].checksum, headers[0].encoding(), sequenced, headers[0].checksumScope())
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("merge: failed to create %s: %w", filepath.Base(dst), err)
+	}
+	if err := mergeInto(out, header, srcs, headers, ends); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("merge: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("merge: failed to close %s: %w", filepath.Base(dst), err)
+	}
+
+	return syncDir(filepath.Dir(dst))
+}
+
+// mergeInto writes header and then the valid records of each source, which
+// end at the corresponding offset in ends, reframed for header
+func mergeInto(out *os.File, header fileHeader, srcs []string, headers []fileHeader, ends []int64) error {
+	if err := writeHeader(out, header); err != nil {
+		return err
+	}
+
+	w := &WAL{maxRecordSize: DefaultMaxRecordSize}
+	writer := bufio.NewWriter(out)
+	seq := uint64(1)
+	for i, src := range srcs {
+		in, err := os.Open(src)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", filepath.Base(src), err)
+		}
+		err = w.reframe(writer, header, io.NewSectionReader(in, headerSize, ends[i]-headerSize), headers[i], &seq)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(src), err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write merged log: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync merged log: %w", err)
+	}
+
+	return nil
+}
+
+// reframe writes every frame read from r, a file with header from, to out
+// as a frame of a file with header to. Payloads are re-encoded if the
+// format versions differ. Sequenced frames are numbered from *seq on
+func (w *WAL) reframe(out io.Writer, to fileHeader, r io.Reader, from fileHeader, seq *uint64) error {
+	format := from.frameFormat()
+	for {
+		body, _, err := readFrame(r, format)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		typeByte := body[0]
+		payload := body[recordTypeSize:]
+		if from.sequenced() {
+			payload = payload[sequenceSize:]
+		}
+		if from.version != to.version {
+			if payload, err = transcodePayload(typeByte, payload, from, to); err != nil {
+				return err
+			}
+		}
+
+		data, err := w.frameRecord(to, typeByte, *seq, payload)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return fmt.Errorf("failed to write merged record: %w", err)
+		}
+		if to.sequenced() {
+			*seq++
+		}
+	}
+}
+
+// transcodePayload re-encodes a stored payload of a file with header from
+// for a file with header to, keeping it compressed if it was
+func transcodePayload(typeByte byte, payload []byte, from, to fileHeader) ([]byte, error) {
+	if typeByte&recordFlagEncrypted != 0 {
+		return nil, fmt.Errorf("%w: encrypted record of format version %d cannot be re-encoded", ErrNoEncryptionKey, from.version)
+	}
+
+	compressed := typeByte&recordFlagCompressed != 0
+	var err error
+	if compressed {
+		if payload, err = decompressPayload(payload, DefaultMaxRecordSize); err != nil {
+			return nil, err
+		}
+	}
+
+	recordType := RecordType(typeByte &^ recordFlagMask)
+	decoded, err := decodePayloadAs(from.encoding(), from.version, recordType, payload)
+	if err != nil {
+		return nil, err
+	}
+	if payload, err = encodePayloadAs(to.encoding(), to.version, Record{Type: recordType, Payload: decoded}); err != nil {
+		return nil, err
+	}
+
+	if compressed {
+		return compressPayload(payload)
+	}
+	return payload, nil
+}
+
+// readHeaderFile reads the header of the log file at path
+func readHeaderFile(path string) (fileHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return fileHeader{}, err
+	}
+	defer file.Close()
+
+	header, _, err := readFileHeader(file)
+	return header, err
+}
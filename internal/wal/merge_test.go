@@ -0,0 +1,221 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// closedTestLog writes records to a new log with config and closes it
+func closedTestLog(t *testing.T, config Config, records ...Record) string {
+	t.Helper()
+	w := openTestWAL(t, config)
+	mustAppend(t, w, records...)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return w.filePath
+}
+
+// taskIDs returns the task of each TaskCreated record
+func taskIDs(records []Record) []string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.Payload.(TaskCreatedPayload).TaskID
+	}
+	return ids
+}
+
+// mergedPath returns where a test merge is written
+func mergedPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "merged.wal")
+}
+
+func TestMergeOrderAndCount(t *testing.T) {
+	first := closedTestLog(t, Config{}, taskCreated("a"), taskCreated("b"), taskCreated("c"))
+	second := closedTestLog(t, Config{}, taskCreated("d"), taskCreated("e"))
+
+	dst := mergedPath(t)
+	if err := Merge(dst, first, second); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	merged := openTestWAL(t, Config{FilePath: dst, ReadOnly: true})
+	if got := taskIDs(mustReplay(t, merged)); fmt.Sprint(got) != "[a b c d e]" {
+		t.Fatalf("merged tasks = %v, want [a b c d e]", got)
+	}
+	if merged.header.version != formatVersion {
+		t.Fatalf("merged version = %d, want %d", merged.header.version, formatVersion)
+	}
+}
+
+func TestMergeSkipsTornTail(t *testing.T) {
+	first := closedTestLog(t, Config{}, taskCreated("a"), taskCreated("b"))
+	stat, err := os.Stat(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(first, stat.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	second := closedTestLog(t, Config{}, taskCreated("c"))
+
+	dst := mergedPath(t)
+	if err := Merge(dst, first, second); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	merged := openTestWAL(t, Config{FilePath: dst, ReadOnly: true})
+	if got := taskIDs(mustReplay(t, merged)); fmt.Sprint(got) != "[a c]" {
+		t.Fatalf("merged tasks = %v, want [a c]", got)
+	}
+}
+
+func TestMergeRenumbersSequences(t *testing.T) {
+	sequenced := Config{SequenceNumbers: true}
+	first := closedTestLog(t, sequenced, taskCreated("a"), taskCreated("b"))
+	second := closedTestLog(t, sequenced, taskCreated("c"))
+	plain := closedTestLog(t, Config{}, taskCreated("d"))
+
+	dst := mergedPath(t)
+	if err := Merge(dst, first, second, plain); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	merged := openTestWAL(t, Config{FilePath: dst, SequenceNumbers: true, StrictSequence: true})
+	records := mustReplay(t, merged)
+	for i, record := range records {
+		if record.Seq != uint64(i+1) {
+			t.Fatalf("record %d has seq %d, want %d", i, record.Seq, i+1)
+		}
+	}
+	if len(records) != 4 {
+		t.Fatalf("merged %d records, want 4", len(records))
+	}
+	mustAppend(t, merged, taskCreated("e"))
+	if last := mustReplay(t, merged)[4]; last.Seq != 5 {
+		t.Fatalf("append after merge got seq %d, want 5", last.Seq)
+	}
+}
+
+func TestMergeReencodesOlderVersions(t *testing.T) {
+	old := filepath.Join(t.TempDir(), "old.wal")
+	writeVersionedLog(t, old, versionInitial, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	current := closedTestLog(t, Config{}, taskCreated("b"))
+
+	dst := mergedPath(t)
+	if err := Merge(dst, old, current); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	merged := openTestWAL(t, Config{FilePath: dst, ReadOnly: true})
+	got := mustReplay(t, merged)
+	want := []Record{
+		stampRecord(taskCreated("a"), testTime),
+		stampRecord(leaseGranted("a", "l1", "w", 1), testTime),
+		stampRecord(taskCreated("b"), testTime),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged records = %v, want %v", got, want)
+	}
+}
+
+func TestMergeReencodesCompressedRecords(t *testing.T) {
+	old := filepath.Join(t.TempDir(), "old.wal")
+	header := fileHeader{version: versionInitial, checksum: ChecksumCRC32}
+	big := stampRecord(bigTask("big", 4096), testTime)
+	encoded, err := (&WAL{compressPayloads: true}).encodeRecordWith(header, big)
+	if err != nil {
+		t.Fatalf("encodeRecordWith: %v", err)
+	}
+	if encoded[lengthPrefixSize]&recordFlagCompressed == 0 {
+		t.Fatal("source record is not compressed")
+	}
+	if err := os.WriteFile(old, append(header.encode(), encoded...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := mergedPath(t)
+	if err := Merge(dst, old); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	merged := openTestWAL(t, Config{FilePath: dst, ReadOnly: true})
+	if got := mustReplay(t, merged); !reflect.DeepEqual(got, []Record{big}) {
+		t.Fatalf("merged records = %v, want the compressed task", got)
+	}
+}
+
+func TestMergeCopiesEncryptedRecords(t *testing.T) {
+	key := testKey(7)
+	first := closedTestLog(t, Config{EncryptionKey: key}, taskCreated("a"))
+	second := closedTestLog(t, Config{EncryptionKey: key}, taskCreated("b"))
+
+	// No key is needed to merge logs of the current version
+	dst := mergedPath(t)
+	if err := Merge(dst, first, second); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	merged := openTestWAL(t, Config{FilePath: dst, ReadOnly: true, EncryptionKey: key})
+	if got := taskIDs(mustReplay(t, merged)); fmt.Sprint(got) != "[a b]" {
+		t.Fatalf("merged tasks = %v, want [a b]", got)
+	}
+}
+
+func TestMergeRejectsOldEncryptedRecords(t *testing.T) {
+	aead, err := newAEAD(testKey(7))
+	if err != nil {
+		t.Fatalf("newAEAD: %v", err)
+	}
+	old := filepath.Join(t.TempDir(), "old.wal")
+	header := fileHeader{version: versionInitial, checksum: ChecksumCRC32}
+	encoded, err := (&WAL{aead: aead}).encodeRecordWith(header, stampRecord(taskCreated("a"), testTime))
+	if err != nil {
+		t.Fatalf("encodeRecordWith: %v", err)
+	}
+	if err := os.WriteFile(old, append(header.encode(), encoded...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encoding into the current version needs the plaintext
+	dst := mergedPath(t)
+	if err := Merge(dst, old); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Fatalf("Merge = %v, want ErrNoEncryptionKey", err)
+	}
+	if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("rejected merge left %s behind: %v", dst, err)
+	}
+}
+
+func TestMergeRejectsIncompatibleSources(t *testing.T) {
+	crc := closedTestLog(t, Config{}, taskCreated("a"))
+	xxhash := closedTestLog(t, Config{Checksum: ChecksumXXHash64}, taskCreated("b"))
+	json := closedTestLog(t, Config{Encoding: EncodingJSON}, taskCreated("c"))
+
+	for _, srcs := range [][]string{{crc, xxhash}, {crc, json}} {
+		dst := mergedPath(t)
+		if err := Merge(dst, srcs...); err == nil {
+			t.Fatalf("Merge of %v succeeded", srcs)
+		}
+		if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("rejected merge left %s behind: %v", dst, err)
+		}
+	}
+}
+
+func TestMergeRejectsCorruption(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("a"), taskCreated("b"), taskCreated("c"))
+	flipByte(t, path, offsets[1]+lengthPrefixSize+recordTypeSize+1)
+
+	if err := Merge(mergedPath(t), path); !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("Merge = %v, want ErrCorruptedLog", err)
+	}
+}
+
+func TestMergeExistingDestination(t *testing.T) {
+	src := closedTestLog(t, Config{}, taskCreated("a"))
+	if err := Merge(src, src); err == nil {
+		t.Fatal("Merge over an existing file succeeded")
+	}
+}
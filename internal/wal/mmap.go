@@ -0,0 +1,30 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// errMmapUnsupported is returned by mapFile on platforms without mmap
+var errMmapUnsupported = errors.New("mmap not supported")
+
+// readerAt returns what replay should read the first size bytes of file
+// through, and a function releasing it: a memory mapping when
+// Config.UseMmap is set and the platform supports it, the file otherwise
+func (w *WAL) readerAt(file *os.File, size int64) (io.ReaderAt, func(), error) {
+	if !w.useMmap {
+		return file, func() {}, nil
+	}
+
+	data, err := mapFile(file, size)
+	if errors.Is(err, errMmapUnsupported) {
+		return file, func() {}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bytes.NewReader(data), func() { unmapFile(data) }, nil
+}
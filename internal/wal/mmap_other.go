@@ -0,0 +1,15 @@
+//go:build !unix
+
+package wal
+
+import "os"
+
+// mapFile always fails where mmap is unavailable, so readers stream instead
+func mapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// unmapFile is a no-op where mmap is unavailable
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,148 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// iterate returns every record of w read through an iterator
+func iterate(t testing.TB, w *WAL) []Record {
+	t.Helper()
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	defer it.Close()
+
+	var records []Record
+	for it.Next() {
+		records = append(records, it.Record())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Iterator: %v", err)
+	}
+	return records
+}
+
+func TestMmapReplayMatchesStreaming(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config Config
+	}{
+		{"plain", Config{}},
+		{"segmented", Config{MaxSegmentBytes: 512}},
+		{"sequenced", Config{SequenceNumbers: true}},
+		{"compressed", Config{CompressPayloads: true, CompressMinBytes: 64}},
+		{"encrypted", Config{EncryptionKey: testKey(3)}},
+		{"json", Config{Encoding: EncodingJSON}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := openTestWAL(t, tt.config)
+			mustAppend(t, w, sampleRecords()...)
+			mustAppend(t, w, bigTask("big", 4096))
+			appendTasks(t, w, 20)
+			streamed := mustReplay(t, w)
+			iterated := iterate(t, w)
+
+			config := tt.config
+			config.UseMmap = true
+			w = reopenTestWAL(t, w, config)
+			if got := mustReplay(t, w); !reflect.DeepEqual(got, streamed) {
+				t.Fatalf("mmap replay = %v, want %v", recordTypes(got), recordTypes(streamed))
+			}
+			if got := iterate(t, w); !reflect.DeepEqual(got, iterated) {
+				t.Fatalf("mmap iteration = %v, want %v", recordTypes(got), recordTypes(iterated))
+			}
+
+			// Records appended while mapped are seen by the next replay
+			mustAppend(t, w, taskCreated("after"))
+			if got := len(mustReplay(t, w)); got != len(streamed)+1 {
+				t.Fatalf("mmap replay after an append = %d records, want %d", got, len(streamed)+1)
+			}
+		})
+	}
+}
+
+func TestMmapReplayTornTailAndCorruption(t *testing.T) {
+	path, _ := tornLog(t)
+	streaming := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+	mapped := openTestWAL(t, Config{FilePath: path, ReadOnly: true, UseMmap: true})
+	if got, want := mustReplay(t, mapped), mustReplay(t, streaming); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mmap replay of a torn log = %v, want %v", taskIDs(got), taskIDs(want))
+	}
+
+	path, offsets := writeTestLog(t, taskCreated("a"), taskCreated("b"), taskCreated("c"))
+	flipByte(t, path, offsets[1]+lengthPrefixSize+recordTypeSize+1)
+	for _, useMmap := range []bool{false, true} {
+		r := openTestWAL(t, Config{FilePath: path, ReadOnly: true, UseMmap: useMmap})
+		err := r.Replay(func(Record) error { return nil })
+		var replayErr *ReplayError
+		if !errors.Is(err, ErrCorruptedLog) || !errors.As(err, &replayErr) || replayErr.Offset != offsets[1] {
+			t.Fatalf("UseMmap %t: Replay = %v, want corruption at offset %d", useMmap, err, offsets[1])
+		}
+	}
+}
+
+func TestMmapEmptyLog(t *testing.T) {
+	w := openTestWAL(t, Config{UseMmap: true})
+	if got := mustReplay(t, w); len(got) != 0 {
+		t.Fatalf("mmap replay of an empty log = %v", recordTypes(got))
+	}
+	if got := iterate(t, w); len(got) != 0 {
+		t.Fatalf("mmap iteration of an empty log = %v", recordTypes(got))
+	}
+}
+
+func TestMmapIteratorCloseReleasesMapping(t *testing.T) {
+	w := openTestWAL(t, Config{MaxSegmentBytes: 256, UseMmap: true})
+	appendTasks(t, w, 10)
+
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	if !it.Next() {
+		t.Fatalf("Next: %v", it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if it.mapped != nil || it.file != nil {
+		t.Fatal("Close kept the segment mapped or open")
+	}
+	if it.Next() {
+		t.Fatal("Next after Close returned a record")
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func BenchmarkReplayMmap(b *testing.B) {
+	path := testPath(b)
+	w := openTestWAL(b, Config{FilePath: path, SyncBatchSize: 10000})
+	for i := 0; i < 20000; i++ {
+		mustAppend(b, w, taskCreated(fmt.Sprintf("t%d", i)))
+	}
+	if err := w.Close(); err != nil {
+		b.Fatalf("Close: %v", err)
+	}
+
+	for _, useMmap := range []bool{false, true} {
+		name := "stream"
+		if useMmap {
+			name = "mmap"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := openTestWAL(b, Config{FilePath: path, ReadOnly: true, UseMmap: useMmap})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := r.Replay(func(Record) error { return nil }); err != nil {
+					b.Fatalf("Replay: %v", err)
+				}
+			}
+		})
+	}
+}
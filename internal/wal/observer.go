@@ -0,0 +1,48 @@
+package wal
+
+import "time"
+
+// Observer receives WAL activity, e.g. to feed metrics
+// Callbacks run synchronously, some with the WAL lock held: they must be
+// fast and must not call back into the WAL
+type Observer interface {
+	// RecordAppended is called for each record written, with its frame size
+	RecordAppended(t RecordType, bytes int)
+	// SyncCompleted is called after each successful fsync of the active file
+	SyncCompleted(d time.Duration)
+	// ReplayProgress is called periodically during Replay with the number
+	// of records applied so far, and once more when replay ends
+	ReplayProgress(records int)
+	// TailRepaired is called when Open trims a torn final record, see
+	// Config.RepairOnOpen, with the number of bytes removed
+	TailRepaired(bytes int64)
+}
+
+// replayProgressInterval is the number of records between ReplayProgress calls
+const replayProgressInterval = 1000
+
+// noopObserver is the default Observer
+type noopObserver struct{}
+
+func (noopObserver) RecordAppended(RecordType, int) {}
+func (noopObserver) SyncCompleted(time.Duration)    {}
+func (noopObserver) ReplayProgress(int)             {}
+func (noopObserver) TailRepaired(int64)             {}
+
+// observeReplay wraps scanFn to report replay progress to the observer
+// The returned done function reports the final count
+func (w *WAL) observeReplay(scanFn scanFunc) (scanFunc, func()) {
+	records := 0
+	observed := func(record Record, offset, size int64) error {
+		if err := scanFn(record, offset, size); err != nil {
+			return err
+		}
+		records++
+		if records%replayProgressInterval == 0 {
+			w.observer.ReplayProgress(records)
+		}
+		return nil
+	}
+
+	return observed, func() { w.observer.ReplayProgress(records) }
+}
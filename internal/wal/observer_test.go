@@ -0,0 +1,104 @@
+package wal
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObserver records every callback
+type fakeObserver struct {
+	mu       sync.Mutex
+	appended []RecordType
+	bytes    []int
+	syncs    []time.Duration
+	progress []int
+	repaired []int64
+}
+
+func (o *fakeObserver) RecordAppended(t RecordType, bytes int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.appended = append(o.appended, t)
+	o.bytes = append(o.bytes, bytes)
+}
+
+func (o *fakeObserver) SyncCompleted(d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.syncs = append(o.syncs, d)
+}
+
+func (o *fakeObserver) ReplayProgress(records int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.progress = append(o.progress, records)
+}
+
+func (o *fakeObserver) TailRepaired(bytes int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.repaired = append(o.repaired, bytes)
+}
+
+func TestObserverAppendAndSync(t *testing.T) {
+	observer := &fakeObserver{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, Observer: observer})
+
+	records := []Record{taskCreated("a"), leaseGranted("a", "l1", "w", 1), taskCompleted("a", "l1")}
+	var sizes []int
+	for _, record := range records {
+		encoded, err := w.encodeRecord(stampRecord(record, testTime))
+		if err != nil {
+			t.Fatalf("encodeRecord: %v", err)
+		}
+		sizes = append(sizes, len(encoded))
+	}
+
+	mustAppend(t, w, records[0])
+	if err := w.AppendBatch(records[1:]); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+	if !reflect.DeepEqual(observer.appended, recordTypes(records)) || !reflect.DeepEqual(observer.bytes, sizes) {
+		t.Fatalf("RecordAppended calls = %v %v, want %v %v", observer.appended, observer.bytes, recordTypes(records), sizes)
+	}
+	if len(observer.syncs) != 0 {
+		t.Fatalf("%d SyncCompleted calls before Sync", len(observer.syncs))
+	}
+
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(observer.syncs) != 1 {
+		t.Fatalf("%d SyncCompleted calls after Sync, want 1", len(observer.syncs))
+	}
+
+	// A failed append reports nothing
+	if err := w.Append(taskCompleted("a", "")); err == nil {
+		t.Fatal("invalid append succeeded")
+	}
+	if len(observer.appended) != len(records) {
+		t.Fatalf("%d RecordAppended calls after a failed append", len(observer.appended))
+	}
+}
+
+func TestObserverReplayProgress(t *testing.T) {
+	observer := &fakeObserver{}
+	w := openTestWAL(t, Config{SyncBatchSize: 10000, Observer: observer})
+
+	const n = 2*replayProgressInterval + 500
+	batch := make([]Record, n)
+	for i := range batch {
+		batch[i] = annotation("note")
+	}
+	if err := w.AppendBatch(batch); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+
+	mustReplay(t, w)
+	want := []int{replayProgressInterval, 2 * replayProgressInterval, n}
+	if !reflect.DeepEqual(observer.progress, want) {
+		t.Fatalf("ReplayProgress calls = %v, want %v", observer.progress, want)
+	}
+}
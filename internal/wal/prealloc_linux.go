@@ -0,0 +1,29 @@
+//go:build linux
+
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: blocks are reserved past the end
+// of the file without changing its size
+const fallocKeepSize = 0x1
+
+// preallocate reserves disk blocks for the first size bytes of file
+// The file size is unchanged, so appends and replay never see the reserved
+// space. Filesystems without fallocate support are left alone
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to preallocate WAL: %w", err)
+	}
+
+	return nil
+}
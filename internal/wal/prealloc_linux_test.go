@@ -0,0 +1,51 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// allocatedBytes returns the disk space allocated to the file at path
+func allocatedBytes(t *testing.T, path string) int64 {
+	t.Helper()
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat.Blocks * 512
+}
+
+// requireFallocate skips the test if the temporary filesystem cannot
+// reserve space
+func requireFallocate(t *testing.T) {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, 4096); err != nil {
+		t.Skipf("fallocate unsupported: %v", err)
+	}
+}
+
+func TestPreallocateReservesSpace(t *testing.T) {
+	requireFallocate(t)
+
+	const reserve = 1 << 20
+	w := openTestWAL(t, Config{PreallocateBytes: reserve})
+	if got := allocatedBytes(t, w.filePath); got < reserve {
+		t.Fatalf("allocated %d bytes, want at least %d", got, reserve)
+	}
+
+	// Truncation gives the space back and reserves it again
+	appendTasks(t, w, 3)
+	if err := w.Truncate(0); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := allocatedBytes(t, w.filePath); got < reserve {
+		t.Fatalf("allocated %d bytes after Truncate, want at least %d", got, reserve)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package wal
+
+import "os"
+
+// preallocate is a no-op where fallocate is unavailable
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
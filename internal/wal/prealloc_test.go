@@ -0,0 +1,35 @@
+package wal
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPreallocatedLogEndsAtLastRecord(t *testing.T) {
+	config := Config{PreallocateBytes: 1 << 20}
+	w := openTestWAL(t, config)
+	appendTasks(t, w, 3)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// The reserved space is not part of the file, so replay stops at the
+	// last record and appends after reopening continue from there
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != w.Offset() {
+		t.Fatalf("file size = %d, want the logical end %d", stat.Size(), w.Offset())
+	}
+
+	end := w.Offset()
+	w = reopenTestWAL(t, w, config)
+	if w.Offset() != end {
+		t.Fatalf("reopened offset = %d, want %d", w.Offset(), end)
+	}
+	mustAppend(t, w, taskCreated("t3"))
+	if got := len(mustReplay(t, w)); got != 4 {
+		t.Fatalf("replayed %d records, want 4", got)
+	}
+}
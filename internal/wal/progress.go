@@ -0,0 +1,84 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// progressRecords and progressBytes bound how much replay may advance
+	// between two calls to a ReplayWithProgress callback
+	progressRecords = 1000
+	progressBytes   = 1 << 20
+)
+
+// ReplayWithProgress is Replay that also reports how far it got, e.g. for
+// a progress bar during a long recovery. progress is called every few
+// records and once more when replay completes, with the bytes read so far
+// across sealed segments and the active file, and the records applied
+// Both only increase. After a complete replay, bytesRead is the size of
+// the log: the sealed segments plus the active file up to its last record,
+// so a percentage can be computed against the files' sizes
+func (w *WAL) ReplayWithProgress(applyFn func(Record) error, progress func(bytesRead, recordsApplied int64)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+
+	sealed, err := w.sealedBytesLocked()
+	if err != nil {
+		return err
+	}
+
+	// base is the size of the files already read, end the end of the last
+	// record read in the current one
+	var base, end, applied, last, reported int64
+	activeEnd, err := w.replayAllEndLocked(context.Background(), func(record Record, offset, size int64) error {
+		if offset == headerSize {
+			base += end // first record of the next file
+		}
+		end = offset + size
+
+		if err := applyFn(record); err != nil {
+			return err
+		}
+		applied++
+
+		read := base + end
+		if applied-last >= progressRecords || read-reported >= progressBytes {
+			progress(read, applied)
+			last, reported = applied, read
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	progress(sealed+activeEnd, applied)
+	return nil
+}
+
+// sealedBytesLocked returns the total size of the sealed segments
+// Callers must hold w.mu
+func (w *WAL) sealedBytesLocked() (int64, error) {
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, path := range segments {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat %s: %w", filepath.Base(path), err)
+		}
+		total += stat.Size()
+	}
+
+	return total, nil
+}
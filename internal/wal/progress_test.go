@@ -0,0 +1,108 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// progressCall is one call of a ReplayWithProgress callback
+type progressCall struct {
+	bytesRead, recordsApplied int64
+}
+
+// replayWithProgress returns the progress calls of a full replay of w
+func replayWithProgress(t *testing.T, w *WAL) []progressCall {
+	t.Helper()
+	var calls []progressCall
+	if err := w.ReplayWithProgress(func(Record) error { return nil }, func(bytesRead, recordsApplied int64) {
+		calls = append(calls, progressCall{bytesRead, recordsApplied})
+	}); err != nil {
+		t.Fatalf("ReplayWithProgress: %v", err)
+	}
+	return calls
+}
+
+// logSize returns the size of the sealed segments and the active file of w
+func logSize(t *testing.T, w *WAL) int64 {
+	t.Helper()
+	segments, err := w.sealedSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, path := range segments {
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += stat.Size()
+	}
+	return total + w.Offset()
+}
+
+func TestReplayWithProgressIsMonotonic(t *testing.T) {
+	const n = 2500
+	w := openTestWAL(t, Config{MaxSegmentBytes: 16 << 10, SyncBatchSize: n})
+	appendTasks(t, w, n)
+	if segments, _ := w.sealedSegments(); len(segments) == 0 {
+		t.Fatal("log did not rotate")
+	}
+
+	calls := replayWithProgress(t, w)
+	if len(calls) < n/progressRecords+1 {
+		t.Fatalf("progress called %d times for %d records", len(calls), n)
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i].bytesRead < calls[i-1].bytesRead || calls[i].recordsApplied < calls[i-1].recordsApplied {
+			t.Fatalf("progress went back from %+v to %+v", calls[i-1], calls[i])
+		}
+		if calls[i].recordsApplied-calls[i-1].recordsApplied > progressRecords {
+			t.Fatalf("progress skipped from %+v to %+v", calls[i-1], calls[i])
+		}
+	}
+
+	// The last call covers the whole log
+	if got, want := calls[len(calls)-1], (progressCall{logSize(t, w), n}); got != want {
+		t.Fatalf("final progress = %+v, want %+v", got, want)
+	}
+}
+
+func TestReplayWithProgressByBytes(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	for i := 0; i < 5; i++ {
+		mustAppend(t, w, bigTask("big", progressBytes/2))
+	}
+
+	// Large records report well before progressRecords of them are applied
+	calls := replayWithProgress(t, w)
+	if len(calls) < 3 || calls[0].recordsApplied > 2 {
+		t.Fatalf("progress calls = %+v, want one every %d bytes", calls, progressBytes)
+	}
+	if got, want := calls[len(calls)-1], (progressCall{logSize(t, w), 5}); got != want {
+		t.Fatalf("final progress = %+v, want %+v", got, want)
+	}
+}
+
+func TestReplayWithProgressEmptyAndFailing(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if got := replayWithProgress(t, w); len(got) != 1 || got[0] != (progressCall{headerSize, 0}) {
+		t.Fatalf("progress of an empty log = %+v, want one call at the header's end", got)
+	}
+
+	// A failing apply ends replay without a final report
+	appendTasks(t, w, 3)
+	stop := errors.New("stop")
+	called := false
+	err := w.ReplayWithProgress(func(Record) error { return stop }, func(int64, int64) { called = true })
+	if !errors.Is(err, stop) || called {
+		t.Fatalf("ReplayWithProgress = %v, progress called %t, want stop without progress", err, called)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.ReplayWithProgress(func(Record) error { return nil }, func(int64, int64) {}); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("ReplayWithProgress of a closed log = %v, want ErrWALClosed", err)
+	}
+}
@@ -0,0 +1,86 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestReadOnlyRejectsWrites(t *testing.T) {
+	path, _, _ := closedLog(t, 3)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+	writes := map[string]func() error{
+		"Append":      func() error { return w.Append(taskCreated("x")) },
+		"AppendBatch": func() error { return w.AppendBatch([]Record{taskCreated("x")}) },
+		"Sync":        w.Sync,
+		"Flush":       w.Flush,
+		"Truncate":    func() error { return w.Truncate(0) },
+		"Compact":     w.Compact,
+		"Rotate":      w.Rotate,
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s = %v, want ErrReadOnly", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("read-only log was modified")
+	}
+}
+
+func TestReadOnlyAllowsReads(t *testing.T) {
+	path, _, _ := closedLog(t, 3)
+
+	// A writer may hold the log meanwhile
+	openTestWAL(t, Config{FilePath: path})
+	w := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+
+	if got := len(mustReplay(t, w)); got != 3 {
+		t.Fatalf("Replay saw %d records, want 3", got)
+	}
+	stats, err := w.Stats()
+	if err != nil || stats.TotalRecords != 3 {
+		t.Fatalf("Stats = %+v, %v", stats, err)
+	}
+	if report, err := Verify(path); err != nil || report.ValidRecords != 3 {
+		t.Fatalf("Verify = %+v, %v", report, err)
+	}
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	n := 0
+	for it.Next() {
+		n++
+	}
+	it.Close()
+	if n != 3 || it.Err() != nil {
+		t.Fatalf("iterated %d records, %v", n, it.Err())
+	}
+
+}
+
+func TestReadOnlyMissingFile(t *testing.T) {
+	path := testPath(t)
+	if w, err := Open(Config{FilePath: path, ReadOnly: true}); err == nil {
+		w.Close()
+		t.Fatal("read-only Open of a missing log succeeded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("read-only Open created the log: %v", err)
+	}
+}
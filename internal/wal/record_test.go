@@ -0,0 +1,181 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openRecordReader opens the log at path positioned on its first record,
+// as readNextRecord expects
+func openRecordReader(t *testing.T, path string) *WAL {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	header, _, err := readFileHeader(file)
+	if err != nil {
+		t.Fatalf("readFileHeader: %v", err)
+	}
+	if _, err := file.Seek(headerSize, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	return &WAL{file: file, filePath: path, header: header}
+}
+
+// writeTestLog writes records to a fresh log and returns its path and the
+// offset each record starts at
+func writeTestLog(t *testing.T, records ...Record) (string, []int64) {
+	t.Helper()
+	w := openTestWAL(t, Config{})
+	offsets := make([]int64, len(records))
+	for i, record := range records {
+		offset, err := w.AppendAt(record)
+		if err != nil {
+			t.Fatalf("AppendAt: %v", err)
+		}
+		offsets[i] = offset
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return w.filePath, offsets
+}
+
+func TestReadNextRecordValid(t *testing.T) {
+	records := sampleRecords()[:2]
+	path, offsets := writeTestLog(t, records...)
+	r := openRecordReader(t, path)
+
+	for i, want := range records {
+		got, size, err := r.readNextRecord()
+		if err != nil {
+			t.Fatalf("readNextRecord %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("record %d = %+v, want %+v", i, got, want)
+		}
+		if i+1 < len(offsets) && size != offsets[i+1]-offsets[i] {
+			t.Fatalf("record %d size = %d, want %d", i, size, offsets[i+1]-offsets[i])
+		}
+	}
+	if _, _, err := r.readNextRecord(); err != io.EOF {
+		t.Fatalf("readNextRecord at end = %v, want io.EOF", err)
+	}
+}
+
+func TestReadNextRecordFlippedPayloadByte(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("a"))
+
+	corruptAt := offsets[0] + lengthPrefixSize + recordTypeSize + 1
+	flipByte(t, path, corruptAt)
+
+	r := openRecordReader(t, path)
+	if _, _, err := r.readNextRecord(); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("readNextRecord = %v, want ErrInvalidChecksum", err)
+	}
+}
+
+func TestReadNextRecordTruncatedBody(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("a"), taskCreated("b"))
+
+	// Keep the length prefix of the second record and part of its body
+	if err := os.Truncate(path, offsets[1]+lengthPrefixSize+3); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openRecordReader(t, path)
+	if _, _, err := r.readNextRecord(); err != nil {
+		t.Fatalf("readNextRecord: %v", err)
+	}
+	if _, _, err := r.readNextRecord(); !errors.Is(err, ErrPartialWrite) {
+		t.Fatalf("readNextRecord = %v, want ErrPartialWrite", err)
+	}
+
+	// Replay tolerates the torn final record
+	w := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+	if got := len(mustReplay(t, w)); got != 1 {
+		t.Fatalf("replayed %d records, want 1", got)
+	}
+}
+
+func TestReadNextRecordTruncatedLength(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("a"), taskCreated("b"))
+
+	if err := os.Truncate(path, offsets[1]+2); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openRecordReader(t, path)
+	if _, _, err := r.readNextRecord(); err != nil {
+		t.Fatalf("readNextRecord: %v", err)
+	}
+	if _, _, err := r.readNextRecord(); !errors.Is(err, ErrPartialWrite) {
+		t.Fatalf("readNextRecord = %v, want ErrPartialWrite", err)
+	}
+}
+
+// flipByte inverts the byte at offset of the file at path
+func flipByte(t testing.TB, path string, offset int64) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendRejectsOversizedRecord(t *testing.T) {
+	w := openTestWAL(t, Config{MaxRecordSize: 1024})
+	mustAppend(t, w, taskCreated("small"))
+	end := w.Offset()
+
+	// Below and above streamMinBytes, so both encoders are checked
+	for _, size := range []int{2048, streamMinBytes} {
+		if err := w.Append(bigTask("big", size)); !errors.Is(err, ErrRecordTooLarge) {
+			t.Fatalf("Append of %d bytes = %v, want ErrRecordTooLarge", size, err)
+		}
+	}
+	if err := w.AppendBatch([]Record{taskCreated("ok"), bigTask("big", 2048)}); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("AppendBatch = %v, want ErrRecordTooLarge", err)
+	}
+	if w.Offset() != end {
+		t.Fatalf("offset moved from %d to %d after rejected appends", end, w.Offset())
+	}
+	if got := len(mustReplay(t, w)); got != 1 {
+		t.Fatalf("replayed %d records, want 1", got)
+	}
+}
+
+func TestReplayRejectsHugeLengthPrefix(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("a"), taskCreated("b"), taskCreated("c"))
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0xf0, 0xff, 0xff, 0x7f}, offsets[1]); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	r := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+	err = r.Replay(func(Record) error { return nil })
+	if !errors.Is(err, ErrCorruptedLog) || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Fatalf("Replay = %v, want ErrCorruptedLog for the length", err)
+	}
+}
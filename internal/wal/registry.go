@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Custom record types
+//
+// Record types from MinCustomRecordType to MaxCustomRecordType are left to
+// callers, who supply their payload codec with RegisterPayloadCodec. Lower
+// types are reserved for this package; higher ones would collide with the
+// record flags stored in the same byte
+const (
+	MinCustomRecordType RecordType = 32
+	MaxCustomRecordType RecordType = 63
+)
+
+// payloadCodec serializes the payload of a custom record type
+type payloadCodec struct {
+	encode func(interface{}) ([]byte, error)
+	decode func([]byte) (interface{}, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[RecordType]payloadCodec)
+)
+
+// RegisterPayloadCodec registers the payload codec of custom record type t
+// enc receives Record.Payload on append and dec must return the same
+// payload from its bytes on replay; their errors reject the append or fail
+// replay. Custom records pass validation and are ignored by ApplyRecord
+// Like gob.Register it is meant to be called from init, and panics if t is
+// not a custom type, is already registered, or a codec is nil
+func RegisterPayloadCodec(t RecordType, enc func(interface{}) ([]byte, error), dec func([]byte) (interface{}, error)) {
+	if !t.custom() {
+		panic(fmt.Sprintf("wal: record type %d outside custom range [%d, %d]", t, MinCustomRecordType, MaxCustomRecordType))
+	}
+	if enc == nil || dec == nil {
+		panic(fmt.Sprintf("wal: nil codec for record type %d", t))
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if _, exists := codecs[t]; exists {
+		panic(fmt.Sprintf("wal: codec for record type %d registered twice", t))
+	}
+	codecs[t] = payloadCodec{encode: enc, decode: dec}
+}
+
+// custom reports whether t is in the range left to callers
+func (t RecordType) custom() bool {
+	return t >= MinCustomRecordType && t <= MaxCustomRecordType
+}
+
+// lookupCodec returns the registered codec of a custom record type
+func lookupCodec(t RecordType) (payloadCodec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[t]
+	return codec, ok
+}
+
+// encodeCustomPayload serializes the payload of a custom record with its
+// registered codec
+func encodeCustomPayload(record Record) ([]byte, bool, error) {
+	codec, ok := lookupCodec(record.Type)
+	if !ok {
+		return nil, false, nil
+	}
+
+	data, err := codec.encode(record.Payload)
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: record type %d: %w", ErrInvalidRecord, record.Type, err)
+	}
+	return data, true, nil
+}
+
+// decodeCustomPayload deserializes the payload of a custom record with its
+// registered codec
+func decodeCustomPayload(recordType RecordType, data []byte) (interface{}, bool, error) {
+	codec, ok := lookupCodec(recordType)
+	if !ok {
+		return nil, false, nil
+	}
+
+	payload, err := codec.decode(data)
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: record type %d: %w", ErrCorruptedLog, recordType, err)
+	}
+	return payload, true, nil
+}
@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testCustomType is registered once for the tests of this package
+const testCustomType = MinCustomRecordType
+
+// migrationEvent is the payload of testCustomType
+type migrationEvent struct {
+	From  string
+	To    string
+	Tasks int
+}
+
+var errBadMigration = errors.New("bad migration")
+
+func init() {
+	RegisterPayloadCodec(testCustomType, func(payload interface{}) ([]byte, error) {
+		event, ok := payload.(migrationEvent)
+		if !ok {
+			return nil, errBadMigration
+		}
+		return json.Marshal(event)
+	}, func(data []byte) (interface{}, error) {
+		var event migrationEvent
+		err := json.Unmarshal(data, &event)
+		return event, err
+	})
+}
+
+func TestCustomRecordRoundTrip(t *testing.T) {
+	event := migrationEvent{From: "node-1", To: "node-2", Tasks: 42}
+	for _, encoding := range []Encoding{EncodingBinary, EncodingJSON} {
+		w := openTestWAL(t, Config{Encoding: encoding, EnforceLifecycle: true})
+		mustAppend(t, w, taskCreated("a"), Record{Type: testCustomType, Payload: event}, taskCreated("b"))
+
+		records := mustReplay(t, w)
+		if len(records) != 3 || records[1].Type != testCustomType {
+			t.Fatalf("encoding %d: replayed %v", encoding, recordTypes(records))
+		}
+		if !reflect.DeepEqual(records[1].Payload, event) {
+			t.Fatalf("encoding %d: custom payload = %#v, want %#v", encoding, records[1].Payload, event)
+		}
+	}
+}
+
+func TestCustomRecordErrors(t *testing.T) {
+	w := openTestWAL(t, Config{})
+
+	// The codec rejects a payload of the wrong type
+	err := w.Append(Record{Type: testCustomType, Payload: "not an event"})
+	if !errors.Is(err, ErrInvalidRecord) || !errors.Is(err, errBadMigration) {
+		t.Fatalf("Append = %v, want ErrInvalidRecord wrapping the codec's error", err)
+	}
+	// An unregistered custom type is unknown
+	if err := w.Append(Record{Type: testCustomType + 1, Payload: migrationEvent{}}); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("Append of an unregistered type = %v, want ErrInvalidRecord", err)
+	}
+	if got := len(mustReplay(t, w)); got != 0 {
+		t.Fatalf("replayed %d records after rejected appends, want 0", got)
+	}
+}
+
+func TestRegisterPayloadCodecPanics(t *testing.T) {
+	codec := func(interface{}) ([]byte, error) { return nil, nil }
+	decode := func([]byte) (interface{}, error) { return nil, nil }
+	for name, register := range map[string]func(){
+		"built-in type": func() { RegisterPayloadCodec(RecordTypeTaskCreated, codec, decode) },
+		"flag range":    func() { RegisterPayloadCodec(MaxCustomRecordType+1, codec, decode) },
+		"twice":         func() { RegisterPayloadCodec(testCustomType, codec, decode) },
+		"nil codec":     func() { RegisterPayloadCodec(testCustomType+1, nil, decode) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterPayloadCodec with %s did not panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
@@ -0,0 +1,449 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// appendTasks appends TaskCreated records for tasks t0 to t(n-1)
+func appendTasks(t testing.TB, w *WAL, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		mustAppend(t, w, taskCreated(fmt.Sprintf("t%d", i)))
+	}
+}
+
+func TestReplayContextCancel(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const stopAfter = 4
+	applied := 0
+	err := w.ReplayContext(ctx, func(Record) error {
+		applied++
+		if applied == stopAfter {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReplayContext = %v, want context.Canceled", err)
+	}
+	if applied != stopAfter {
+		t.Fatalf("applied %d records, want %d", applied, stopAfter)
+	}
+
+	// The log is still usable afterwards
+	mustAppend(t, w, taskCreated("t10"))
+	if got := len(mustReplay(t, w)); got != 11 {
+		t.Fatalf("replayed %d records, want 11", got)
+	}
+}
+
+func TestReplayContextAlreadyCancelled(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := w.ReplayContext(ctx, func(Record) error {
+		t.Fatal("apply called with a cancelled context")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReplayContext = %v, want context.Canceled", err)
+	}
+}
+
+func TestReplayFromCheckpointReplaysTail(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	checkpointOffset := w.Offset()
+	mustAppend(t, w, taskCreated("t3"), taskCreated("t4"))
+
+	var tail []string
+	end, err := w.ReplayFrom(checkpointOffset, func(record Record) error {
+		tail = append(tail, record.Payload.(TaskCreatedPayload).TaskID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayFrom: %v", err)
+	}
+	if fmt.Sprint(tail) != "[t3 t4]" {
+		t.Fatalf("replayed %v, want [t3 t4]", tail)
+	}
+	if end != w.Offset() {
+		t.Fatalf("ReplayFrom ended at %d, want %d", end, w.Offset())
+	}
+
+	// The returned offset is the next checkpoint
+	mustAppend(t, w, taskCreated("t5"))
+	n := 0
+	if _, err := w.ReplayFrom(end, func(Record) error { n++; return nil }); err != nil {
+		t.Fatalf("ReplayFrom: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("replayed %d records from the returned offset, want 1", n)
+	}
+}
+
+func TestReplayFromZeroReplaysAll(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+
+	n := 0
+	if _, err := w.ReplayFrom(0, func(Record) error { n++; return nil }); err != nil {
+		t.Fatalf("ReplayFrom: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("replayed %d records, want 3", n)
+	}
+}
+
+func TestReplayFromRejectsNonBoundary(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+
+	for _, offset := range []int64{3, headerSize + 2, w.Offset() + 1} {
+		_, err := w.ReplayFrom(offset, func(Record) error {
+			t.Fatalf("apply called for offset %d", offset)
+			return nil
+		})
+		if !errors.Is(err, ErrCorruptedLog) {
+			t.Errorf("ReplayFrom(%d) = %v, want ErrCorruptedLog", offset, err)
+		}
+	}
+}
+
+func TestReplayConcurrentWithAppend(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 16})
+
+	const n = 200
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := w.Append(taskCreated(fmt.Sprintf("t%d", i))); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// Each replay sees a growing prefix of the appends, in order
+	seen := 0
+	for replaying := true; replaying; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			replaying = false
+		default:
+		}
+
+		i := 0
+		err := w.Replay(func(record Record) error {
+			if got, want := record.Payload.(TaskCreatedPayload).TaskID, fmt.Sprintf("t%d", i); got != want {
+				return fmt.Errorf("record %d is task %s, want %s", i, got, want)
+			}
+			i++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Replay: %v", err)
+		}
+		if i < seen {
+			t.Fatalf("replay saw %d records after an earlier one saw %d", i, seen)
+		}
+		seen = i
+	}
+
+	if seen != n {
+		t.Fatalf("last replay saw %d records, want %d", seen, n)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != w.Offset() {
+		t.Fatalf("file size %d, append offset %d", stat.Size(), w.Offset())
+	}
+}
+
+func TestReplayMidLogCorruption(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 4)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	flipByte(t, w.filePath, offsets[1]+lengthPrefixSize+recordTypeSize+1)
+
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	applied := 0
+	err := r.Replay(func(Record) error { applied++; return nil })
+	if !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("Replay = %v, want ErrCorruptedLog", err)
+	}
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) || replayErr.Offset != offsets[1] || replayErr.RecordIndex != 1 {
+		t.Fatalf("Replay = %v, want a ReplayError at offset %d", err, offsets[1])
+	}
+	if applied != 1 {
+		t.Fatalf("applied %d records before the corruption, want 1", applied)
+	}
+}
+
+func TestReplayTailCorruption(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	flipByte(t, w.filePath, offsets[2]+lengthPrefixSize+recordTypeSize+1)
+
+	// A bad final record is taken for a torn write by default
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if got := len(mustReplay(t, r)); got != 2 {
+		t.Fatalf("replayed %d records, want 2", got)
+	}
+
+	// StrictReplay makes any checksum failure fatal
+	strict := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true, StrictReplay: true})
+	err := strict.Replay(func(Record) error { return nil })
+	if !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("strict Replay = %v, want ErrInvalidChecksum", err)
+	}
+}
+
+func TestStrictReplayToleratesShortTail(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(w.filePath, stat.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true, StrictReplay: true})
+	if got := len(mustReplay(t, r)); got != 2 {
+		t.Fatalf("replayed %d records, want 2", got)
+	}
+}
+
+func TestReplayWithOffsetMatchesAppendOffsets(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 5)
+	if err := w.AppendBatch([]Record{annotation("a"), annotation("b")}); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+
+	var got []int64
+	if err := w.ReplayWithOffset(func(offset int64, record Record) error {
+		got = append(got, offset)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReplayWithOffset: %v", err)
+	}
+
+	if len(got) != 7 {
+		t.Fatalf("ReplayWithOffset reported %d offsets, want 7", len(got))
+	}
+	for i, offset := range offsets {
+		if got[i] != offset {
+			t.Fatalf("offset of record %d = %d, want %d", i, got[i], offset)
+		}
+	}
+	// Each offset is a boundary ReplayFrom accepts
+	n := 0
+	if _, err := w.ReplayFrom(got[6], func(Record) error { n++; return nil }); err != nil || n != 1 {
+		t.Fatalf("ReplayFrom(last offset) applied %d records, %v", n, err)
+	}
+}
+
+func TestReplayN(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 10)
+
+	for _, test := range []struct{ n, want int }{{3, 3}, {10, 10}, {25, 10}, {0, 0}, {-1, 0}} {
+		var ids []string
+		err := w.ReplayN(test.n, func(record Record) error {
+			ids = append(ids, record.Payload.(TaskCreatedPayload).TaskID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ReplayN(%d): %v", test.n, err)
+		}
+		if len(ids) != test.want {
+			t.Fatalf("ReplayN(%d) applied %d records, want %d", test.n, len(ids), test.want)
+		}
+		for i, id := range ids {
+			if id != fmt.Sprintf("t%d", i) {
+				t.Fatalf("ReplayN(%d) record %d = %s, want t%d", test.n, i, id, i)
+			}
+		}
+	}
+
+	// Appends continue at the end of the log after a bounded replay
+	mustAppend(t, w, taskCreated("t10"))
+	if records := mustReplay(t, w); len(records) != 11 {
+		t.Fatalf("replayed %d records after append, want 11", len(records))
+	}
+}
+
+func TestReplayNApplyError(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 5)
+
+	stop := errors.New("stop")
+	applied := 0
+	err := w.ReplayN(4, func(Record) error {
+		if applied++; applied == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || applied != 2 {
+		t.Fatalf("ReplayN = %v after %d records, want stop after 2", err, applied)
+	}
+}
+
+func TestReplayErrorLocatesApplyFailure(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 4)
+
+	stop := errors.New("stop")
+	err := w.Replay(func(record Record) error {
+		if record.Payload.(TaskCreatedPayload).TaskID == "t2" {
+			return stop
+		}
+		return nil
+	})
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) {
+		t.Fatalf("Replay = %v, want a ReplayError", err)
+	}
+	if replayErr.Offset != offsets[2] || replayErr.RecordIndex != 2 || !errors.Is(err, stop) {
+		t.Fatalf("ReplayError = %+v, want record 2 at offset %d wrapping stop", replayErr, offsets[2])
+	}
+}
+
+func TestReplayErrorTruncateBeforeBadRecord(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 4)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	flipByte(t, w.filePath, offsets[2]+lengthPrefixSize+recordTypeSize+1)
+
+	w = openTestWAL(t, Config{FilePath: w.filePath})
+	var replayErr *ReplayError
+	if err := w.Replay(func(Record) error { return nil }); !errors.As(err, &replayErr) {
+		t.Fatalf("Replay = %v, want a ReplayError", err)
+	}
+	if !errors.Is(replayErr, ErrCorruptedLog) || !errors.Is(replayErr, ErrInvalidChecksum) {
+		t.Fatalf("ReplayError = %v, want ErrCorruptedLog and ErrInvalidChecksum", replayErr)
+	}
+
+	// The offset is where to cut the log to recover it
+	if err := w.Truncate(replayErr.Offset); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := len(mustReplay(t, w)); got != 2 {
+		t.Fatalf("replayed %d records after truncation, want 2", got)
+	}
+}
+
+func TestReplayCollectErrors(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 6)
+
+	failing := errors.New("failing")
+	var applied []string
+	failures, err := w.ReplayCollectErrors(func(record Record) error {
+		id := record.Payload.(TaskCreatedPayload).TaskID
+		if id == "t1" || id == "t4" {
+			return failing
+		}
+		applied = append(applied, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayCollectErrors: %v", err)
+	}
+	if want := []string{"t0", "t2", "t3", "t5"}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+	if len(failures) != 2 {
+		t.Fatalf("collected %d failures, want 2", len(failures))
+	}
+	for i, index := range []int{1, 4} {
+		failure := failures[i]
+		if failure.RecordIndex != index || failure.Offset != offsets[index] || !errors.Is(&failure, failing) {
+			t.Errorf("failure %d = %+v, want record %d at offset %d wrapping the apply error", i, failure, index, offsets[index])
+		}
+	}
+
+	// Without failures nothing is collected
+	failures, err = w.ReplayCollectErrors(func(Record) error { return nil })
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("ReplayCollectErrors = %v, %v, want no failures", failures, err)
+	}
+}
+
+func TestReplayCollectErrorsAcrossSegments(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	mustAppend(t, w, taskCreated("active"))
+
+	failures, err := w.ReplayCollectErrors(func(record Record) error {
+		if id := record.Payload.(TaskCreatedPayload).TaskID; id == "t2" || id == "active" {
+			return errors.New("failing")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayCollectErrors: %v", err)
+	}
+
+	// Indexes and offsets are relative to each file
+	if len(failures) != 2 || failures[0].RecordIndex != 2 || failures[1].RecordIndex != 0 || failures[1].Offset != headerSize {
+		t.Fatalf("failures = %+v, want record 2 of the segment and record 0 of the active file", failures)
+	}
+}
+
+func TestReplayCollectErrorsStopsAtCorruption(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("a"), taskCreated("b"), taskCreated("c"), taskCreated("d"))
+	flipByte(t, path, offsets[2]+lengthPrefixSize+recordTypeSize+1)
+
+	w := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+	applied := 0
+	failures, err := w.ReplayCollectErrors(func(Record) error {
+		applied++
+		return errors.New("failing")
+	})
+	if !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("ReplayCollectErrors over a corrupt record = %v, want ErrCorruptedLog", err)
+	}
+
+	// The failures before the corruption come back with the error
+	if applied != 2 || len(failures) != 2 {
+		t.Fatalf("applied %d records and collected %d failures, want 2 of each", applied, len(failures))
+	}
+}
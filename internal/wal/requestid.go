@@ -0,0 +1,49 @@
+package wal
+
+import "context"
+
+// HasRequestID reports whether a TaskCreated record with the given
+// RequestID is in the log, so duplicate task creations can be rejected
+// idempotently. It requires Config.TrackRequestIDs
+//
+// The set is rebuilt by a full replay on Open and kept in memory, one entry
+// per distinct RequestID, so it grows with the number of tasks ever
+// created. Compact, Truncate and TruncatePrefix rebuild it, so the IDs of
+// dropped records are forgotten as they would be on reopen
+func (w *WAL) HasRequestID(id string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return false, ErrWALClosed
+	}
+	if w.requestIDs == nil {
+		return false, ErrRequestIDsNotTracked
+	}
+
+	_, ok := w.requestIDs[id]
+	return ok, nil
+}
+
+// trackRequestID adds the RequestID of a TaskCreated record to the set
+// It is a no-op when tracking is disabled
+// Callers must hold w.mu
+func (w *WAL) trackRequestID(record Record) {
+	if w.requestIDs == nil {
+		return
+	}
+	if p, ok := record.Payload.(TaskCreatedPayload); ok && p.RequestID != "" {
+		w.requestIDs[p.RequestID] = struct{}{}
+	}
+}
+
+// loadRequestIDsLocked rebuilds the RequestID set from the whole log
+// Callers must hold w.mu
+func (w *WAL) loadRequestIDsLocked() error {
+	w.requestIDs = make(map[string]struct{})
+
+	return w.replayAllLocked(context.Background(), func(record Record, _, _ int64) error {
+		w.trackRequestID(record)
+		return nil
+	})
+}
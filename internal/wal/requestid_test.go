@@ -0,0 +1,102 @@
+package wal
+
+import (
+	"errors"
+	"testing"
+)
+
+// createdWithRequestID is a TaskCreated record carrying a request ID
+func createdWithRequestID(taskID, requestID string) Record {
+	record := taskCreated(taskID)
+	p := record.Payload.(TaskCreatedPayload)
+	p.RequestID = requestID
+	record.Payload = p
+	return record
+}
+
+// hasRequestID calls HasRequestID and fails the test on error
+func hasRequestID(t *testing.T, w *WAL, id string) bool {
+	t.Helper()
+	ok, err := w.HasRequestID(id)
+	if err != nil {
+		t.Fatalf("HasRequestID(%q): %v", id, err)
+	}
+	return ok
+}
+
+func TestRequestIDDetectedAfterReopen(t *testing.T) {
+	config := Config{TrackRequestIDs: true}
+	w := openTestWAL(t, config)
+
+	mustAppend(t, w, createdWithRequestID("a", "req-1"), taskCreated("b"))
+	if !hasRequestID(t, w, "req-1") {
+		t.Fatal("HasRequestID(req-1) = false after Append")
+	}
+	if hasRequestID(t, w, "req-2") {
+		t.Fatal("HasRequestID(req-2) = true before Append")
+	}
+
+	w = reopenTestWAL(t, w, config)
+	if !hasRequestID(t, w, "req-1") {
+		t.Fatal("HasRequestID(req-1) = false after reopen")
+	}
+	if hasRequestID(t, w, "") {
+		t.Fatal("empty request ID is tracked")
+	}
+
+	if err := w.AppendBatch([]Record{createdWithRequestID("c", "req-2")}); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+	if !hasRequestID(t, w, "req-2") {
+		t.Fatal("HasRequestID(req-2) = false after AppendBatch")
+	}
+}
+
+func TestRequestIDsNotTracked(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, createdWithRequestID("a", "req-1"))
+
+	if _, err := w.HasRequestID("req-1"); !errors.Is(err, ErrRequestIDsNotTracked) {
+		t.Fatalf("HasRequestID = %v, want ErrRequestIDsNotTracked", err)
+	}
+}
+
+func TestRequestIDsReloadedAfterCompact(t *testing.T) {
+	w := openTestWAL(t, Config{TrackRequestIDs: true})
+	mustAppend(t, w,
+		createdWithRequestID("done", "req-done"),
+		leaseGranted("done", "l1", "w", 1),
+		taskCompleted("done", "l1"),
+		createdWithRequestID("live", "req-live"),
+	)
+
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if hasRequestID(t, w, "req-done") {
+		t.Fatal("request ID of a compacted task is still tracked")
+	}
+	if !hasRequestID(t, w, "req-live") {
+		t.Fatal("request ID of a live task was lost")
+	}
+}
+
+func TestRequestIDsReloadedAfterTruncatePrefix(t *testing.T) {
+	w := openTestWAL(t, Config{TrackRequestIDs: true})
+	mustAppend(t, w, createdWithRequestID("a", "req-a"))
+	point, err := w.SnapshotPoint()
+	if err != nil {
+		t.Fatalf("SnapshotPoint: %v", err)
+	}
+	mustAppend(t, w, createdWithRequestID("b", "req-b"))
+
+	if err := w.TruncatePrefix(point); err != nil {
+		t.Fatalf("TruncatePrefix: %v", err)
+	}
+	if hasRequestID(t, w, "req-a") {
+		t.Fatal("request ID before the prefix is still tracked")
+	}
+	if !hasRequestID(t, w, "req-b") {
+		t.Fatal("request ID after the prefix was lost")
+	}
+}
@@ -0,0 +1,416 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// Sealed segments live next to the active log and are named
+// <FilePath>.<sequence>, with a zero-padded sequence so that
+// lexical order matches creation order
+const segmentSuffixFormat = "%s.%06d"
+
+// segmentPath returns the path of the sealed segment with the given sequence
+func segmentPath(base string, seq int) string {
+	return fmt.Sprintf(segmentSuffixFormat, base, seq)
+}
+
+// segmentGlob returns a pattern matching the segments of the log at base
+// that live in dir
+func segmentGlob(dir, base string) string {
+	return filepath.Join(dir, escapeGlob(filepath.Base(base))+".[0-9][0-9][0-9][0-9][0-9][0-9]")
+}
+
+// sealedSegments returns the sealed segment paths for the log, oldest
+// first, as listed in the manifest
+func (w *WAL) sealedSegments() ([]string, error) {
+	if w.readOnly {
+		// The writer may have rotated since
+		if err := w.loadManifestLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	for _, entry := range w.manifest.Files {
+		if entry.Sealed {
+			paths = append(paths, segmentPath(w.filePath, entry.Seq))
+		}
+	}
+	return paths, nil
+}
+
+// scanSegments lists the sealed segment paths for the log in the
+// directory, oldest first
+func (w *WAL) scanSegments() ([]string, error) {
+	matches, err := filepath.Glob(segmentGlob(filepath.Dir(w.filePath), w.filePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// escapeGlob escapes pattern metacharacters in a literal file name
+func escapeGlob(name string) string {
+	escaped := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '*', '?', '[', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, name[i])
+	}
+	return string(escaped)
+}
+
+// ArchiveOldestSegment moves the oldest sealed segment into the archive
+// directory. Archived segments keep their name and can be replayed again
+// by moving them back next to the active log and removing its manifest,
+// which the next Open rebuilds to include them
+// It is a no-op if there are no sealed segments
+func (w *WAL) ArchiveOldestSegment() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.archiveOldestSegment()
+}
+
+// archiveOldestSegment is ArchiveOldestSegment without locking
+func (w *WAL) archiveOldestSegment() error {
+	if w.readOnly {
+		return ErrReadOnly
+	}
+	if w.archiveDir == "" {
+		return ErrNoArchiveDir
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(w.archiveDir, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	oldest := segments[0]
+	dst := filepath.Join(w.archiveDir, filepath.Base(oldest))
+	if err := moveFile(oldest, dst); err != nil {
+		return fmt.Errorf("failed to archive segment %s: %w", oldest, err)
+	}
+
+	return w.updateManifestLocked(func(m *manifest) {
+		m.Files = m.Files[1:]
+	})
+}
+
+// enforceMaxSegments archives the oldest sealed segments until at most
+// maxSegments remain. Called from the rotation path
+func (w *WAL) enforceMaxSegments() error {
+	if w.maxSegments <= 0 {
+		return nil
+	}
+
+	for {
+		segments, err := w.sealedSegments()
+		if err != nil {
+			return err
+		}
+		if len(segments) <= w.maxSegments {
+			return nil
+		}
+		if err := w.archiveOldestSegment(); err != nil {
+			return err
+		}
+	}
+}
+
+// moveFile renames src to dst, falling back to copy+fsync+remove when the
+// two paths are on different devices
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return syncDir(filepath.Dir(dst))
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst and makes the copy durable
+// The copy is written to a temporary name first so a crash never leaves a
+// half-written segment under the final name
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFileAtomic(dst, in)
+}
+
+// writeFileAtomic writes the contents of in to dst and makes them durable,
+// through a temporary name so dst is never seen half-written
+func writeFileAtomic(dst string, in io.Reader) error {
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return syncDir(filepath.Dir(dst))
+}
+
+// syncDir fsyncs a directory so that renames into it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// segmentSeq parses the sequence number out of a sealed segment path
+func segmentSeq(path string) (int, error) {
+	ext := filepath.Ext(path)
+	if len(ext) < 2 {
+		return 0, fmt.Errorf("%w: %s is not a segment", ErrCorruptedLog, path)
+	}
+	return strconv.Atoi(ext[1:])
+}
+
+// lastSegmentSeq returns the highest sequence number among sealed and
+// archived segments, or 0 if there are none
+// Archived segments are included so that sequence numbers are never reused
+func (w *WAL) lastSegmentSeq() (int, error) {
+	segments, err := w.scanSegments()
+	if err != nil {
+		return 0, err
+	}
+	if w.archiveDir != "" {
+		archived, err := filepath.Glob(segmentGlob(w.archiveDir, w.filePath))
+		if err != nil {
+			return 0, fmt.Errorf("failed to list archived segments: %w", err)
+		}
+		segments = append(segments, archived...)
+	}
+
+	last := 0
+	for _, path := range segments {
+		seq, err := segmentSeq(path)
+		if err != nil {
+			return 0, err
+		}
+		if seq > last {
+			last = seq
+		}
+	}
+
+	return last, nil
+}
+
+// Rotate seals the active file as the next segment and starts a new one,
+// as size-based rotation does, e.g. so a backup can copy sealed segments
+// only. Pending records are synced first
+func (w *WAL) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+
+	return w.rotateLocked()
+}
+
+// rotateLocked seals the active file as the next segment and starts a new,
+// empty active file. Excess segments are then archived
+// Callers must hold w.mu
+func (w *WAL) rotateLocked() error {
+	w.waitSyncLocked()
+	if w.file == nil {
+		return ErrWALClosed
+	}
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+	if w.preallocate > 0 {
+		// Release the reserved space the sealed segment will never use
+		if err := w.file.Truncate(w.offset); err != nil {
+			return fmt.Errorf("failed to trim segment: %w", err)
+		}
+	}
+
+	sealedSize := w.offset
+
+	// From here on a failure leaves the WAL closed, with its read handle
+	// and file lock released so the log can be opened again
+	closeErr := w.file.Close()
+	w.file = nil
+	defer func() {
+		if w.file == nil {
+			w.releaseLocked()
+		}
+	}()
+	if closeErr != nil {
+		return fmt.Errorf("failed to close segment: %w", closeErr)
+	}
+	if w.rotateFault != nil {
+		return w.rotateFault
+	}
+
+	sealed := segmentPath(w.filePath, w.nextSegment)
+	if err := os.Rename(w.filePath, sealed); err != nil {
+		return fmt.Errorf("failed to seal segment: %w", err)
+	}
+
+	file, err := os.OpenFile(w.filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	header, size, err := initFile(file, w.newHeader())
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := syncDir(filepath.Dir(w.filePath)); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+
+	w.file = file
+	w.writer.Reset(file)
+	w.offset = size
+	w.syncedOffset = size
+	w.decodeCache.clear()
+	w.header = header
+	w.nextSegment++
+
+	err = w.updateManifestLocked(func(m *manifest) {
+		sealed := m.active()
+		sealed.Sealed = true
+		sealed.End = sealed.Start + sealedSize
+		m.Files = append(m.Files, manifestEntry{Seq: w.nextSegment, Start: sealed.End})
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.openReadFileLocked(); err != nil {
+		return err
+	}
+	if err := w.preallocateLocked(); err != nil {
+		return err
+	}
+
+	return w.enforceMaxSegments()
+}
+
+// replaySegmentsLocked replays every sealed segment, oldest first
+// Sealed segments were fsynced before sealing, so a torn record in one of
+// them is corruption rather than a crash artifact
+// Callers must hold w.mu
+func (w *WAL) replaySegmentsLocked(ctx context.Context, scanFn scanFunc) error {
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range segments {
+		if err := w.replaySegment(ctx, path, scanFn); err != nil {
+			return fmt.Errorf("segment %s: %w", filepath.Base(path), err)
+		}
+	}
+
+	return nil
+}
+
+// replaySegment replays a single sealed segment file
+func (w *WAL) replaySegment(ctx context.Context, path string, scanFn scanFunc) error {
+	return w.replayFile(ctx, path, tailNone, scanFn)
+}
+
+// replayFile replays a log file from its first record through its own
+// read handle
+func (w *WAL) replayFile(ctx context.Context, path string, tail tailPolicy, scanFn scanFunc) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", filepath.Base(path), err)
+	}
+
+	ra, release, err := w.readerAt(file, stat.Size())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return w.replayReaderAt(ctx, ra, stat.Size(), tail, scanFn)
+}
+
+// replayReaderAt replays a log file of the given size read through r, from
+// its header to its last record
+func (w *WAL) replayReaderAt(ctx context.Context, ra io.ReaderAt, size int64, tail tailPolicy, scanFn scanFunc) error {
+	r := bufio.NewReader(io.NewSectionReader(ra, 0, size))
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = replayRecords(ctx, r, w.format(header), headerSize, scanFn, tail)
+	return err
+}
+
+// ReplayReader replays a single log file of the given size read through r,
+// e.g. a sealed segment held in object storage, without a local file
+// A torn final record is discarded, as in Replay. Encrypted records cannot
+// be decoded, since no key is available
+func ReplayReader(r io.ReaderAt, size int64, applyFn func(Record) error) error {
+	return (&WAL{}).replayReaderAt(context.Background(), r, size, tailTorn, applyOnly(applyFn))
+}
@@ -0,0 +1,402 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMaxSegmentsArchivesOldest(t *testing.T) {
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	config := Config{MaxSegments: 1, ArchiveDir: archiveDir}
+	w := openTestWAL(t, config)
+
+	for i := 0; i < 4; i++ {
+		mustAppend(t, w, taskCreated(fmt.Sprintf("t%d", i)))
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		t.Fatalf("sealedSegments: %v", err)
+	}
+	if len(segments) != 1 || segments[0] != segmentPath(w.filePath, 4) {
+		t.Fatalf("sealed segments = %v, want only segment 4", segments)
+	}
+	archived, err := filepath.Glob(segmentGlob(archiveDir, w.filePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 3 {
+		t.Fatalf("archived %d segments, want 3", len(archived))
+	}
+	if got := len(mustReplay(t, w)); got != 1 {
+		t.Fatalf("replayed %d records with segments archived, want 1", got)
+	}
+
+	// Restoring the archived segments makes them replayable again
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	for _, path := range archived {
+		if err := os.Rename(path, filepath.Join(filepath.Dir(w.filePath), filepath.Base(path))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(manifestPath(w.filePath)); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, Config{FilePath: w.filePath})
+	records := mustReplay(t, w)
+	if len(records) != 4 {
+		t.Fatalf("replayed %d records after restore, want 4", len(records))
+	}
+	for i, record := range records {
+		if got, want := record.Payload.(TaskCreatedPayload).TaskID, fmt.Sprintf("t%d", i); got != want {
+			t.Fatalf("record %d is task %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestArchiveOldestSegmentWithoutArchiveDir(t *testing.T) {
+	w := openTestWAL(t, Config{})
+
+	if err := w.ArchiveOldestSegment(); !errors.Is(err, ErrNoArchiveDir) {
+		t.Fatalf("ArchiveOldestSegment = %v, want ErrNoArchiveDir", err)
+	}
+}
+
+func TestArchiveOldestSegmentWithoutSegments(t *testing.T) {
+	w := openTestWAL(t, Config{ArchiveDir: t.TempDir()})
+
+	if err := w.ArchiveOldestSegment(); err != nil {
+		t.Fatalf("ArchiveOldestSegment: %v", err)
+	}
+}
+
+func TestArchivedSequenceNotReused(t *testing.T) {
+	archiveDir := t.TempDir()
+	config := Config{MaxSegments: 1, ArchiveDir: archiveDir}
+	w := openTestWAL(t, config)
+
+	for i := 0; i < 2; i++ {
+		mustAppend(t, w, taskCreated(fmt.Sprintf("t%d", i)))
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+	}
+
+	// Without a manifest the next sequence comes from the archive too
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := os.Remove(manifestPath(w.filePath)); err != nil {
+		t.Fatal(err)
+	}
+	w = openTestWAL(t, Config{FilePath: w.filePath, MaxSegments: 1, ArchiveDir: archiveDir})
+	mustAppend(t, w, taskCreated("t2"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if _, err := os.Stat(segmentPath(w.filePath, 3)); err != nil {
+		t.Fatalf("new segment: %v", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("segment bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cross-device fallback of moveFile
+	if err := copyFile(src, dst); err != nil {
+		t.Fatalf("copyFile: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "segment bytes" {
+		t.Fatalf("copy holds %q", data)
+	}
+	if _, err := os.Stat(dst + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary copy left behind: %v", err)
+	}
+}
+
+func TestRotationSpansSegments(t *testing.T) {
+	w := openTestWAL(t, Config{MaxSegmentBytes: 256})
+
+	const n = 30
+	appendTasks(t, w, n)
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		t.Fatalf("sealedSegments: %v", err)
+	}
+	if len(segments) < 3 {
+		t.Fatalf("%d sealed segments, want at least 3", len(segments))
+	}
+
+	records := mustReplay(t, w)
+	if len(records) != n {
+		t.Fatalf("replayed %d records, want %d", len(records), n)
+	}
+	for i, record := range records {
+		if got, want := record.Payload.(TaskCreatedPayload).TaskID, fmt.Sprintf("t%d", i); got != want {
+			t.Fatalf("record %d is task %s, want %s", i, got, want)
+		}
+	}
+
+	// Reopening finds the same segments
+	w = reopenTestWAL(t, w, Config{MaxSegmentBytes: 256})
+	if got := len(mustReplay(t, w)); got != n {
+		t.Fatalf("replayed %d records after reopening, want %d", got, n)
+	}
+}
+
+func TestTornRecordToleratedInActiveFileOnly(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	mustAppend(t, w, taskCreated("c"), taskCreated("d"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A torn final record of the active file is a crash artifact
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(w.filePath, stat.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if got := len(mustReplay(t, r)); got != 3 {
+		t.Fatalf("replayed %d records, want 3", got)
+	}
+
+	// In a sealed segment it is corruption
+	sealed := segmentPath(w.filePath, 1)
+	if stat, err = os.Stat(sealed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(sealed, stat.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Replay(func(Record) error { return nil })
+	if !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("Replay = %v, want ErrCorruptedLog from the sealed segment", err)
+	}
+}
+
+// readLog returns the bytes of the log file at path
+func readLog(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReplayReaderFromMemory(t *testing.T) {
+	path, _ := writeTestLog(t, taskCreated("t0"), leaseGranted("t0", "l1", "w1", 1), taskCompleted("t0", "l1"), bigTask("t1", 4096))
+	data := readLog(t, path)
+
+	w := openTestWAL(t, Config{FilePath: path})
+	want := mustReplay(t, w)
+
+	var got []Record
+	if err := ReplayReader(bytes.NewReader(data), int64(len(data)), func(record Record) error {
+		got = append(got, record)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReplayReader: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReplayReader = %v, want %v", recordTypes(got), recordTypes(want))
+	}
+}
+
+func TestReplayReaderHonoursSize(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("t0"), taskCreated("t1"), taskCreated("t2"))
+	data := readLog(t, path)
+
+	// A size ending mid-record reads as a torn tail, not past it
+	var got []Record
+	if err := ReplayReader(bytes.NewReader(data), offsets[2]+3, func(record Record) error {
+		got = append(got, record)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReplayReader: %v", err)
+	}
+	if ids := taskIDs(got); !reflect.DeepEqual(ids, []string{"t0", "t1"}) {
+		t.Fatalf("ReplayReader replayed %v, want t0 and t1", ids)
+	}
+}
+
+func TestReplayReaderErrors(t *testing.T) {
+	path, offsets := writeTestLog(t, taskCreated("t0"), taskCreated("t1"), taskCreated("t2"))
+	data := readLog(t, path)
+	none := func(Record) error { return nil }
+
+	corrupt := bytes.Clone(data)
+	corrupt[offsets[1]+lengthPrefixSize+recordTypeSize+1] ^= 0xff
+	if err := ReplayReader(bytes.NewReader(corrupt), int64(len(corrupt)), none); !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("ReplayReader of a corrupt log = %v, want ErrCorruptedLog", err)
+	}
+
+	if err := ReplayReader(bytes.NewReader([]byte("plain text")), 10, none); !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("ReplayReader of a non-log = %v, want ErrCorruptedLog", err)
+	}
+
+	stop := errors.New("stop")
+	if err := ReplayReader(bytes.NewReader(data), int64(len(data)), func(Record) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("ReplayReader with a failing apply = %v, want it wrapped", err)
+	}
+}
+
+func TestReplayReaderEncryptedLog(t *testing.T) {
+	w := openTestWAL(t, Config{EncryptionKey: testKey(1)})
+	mustAppend(t, w, taskCreated("secret"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data := readLog(t, w.filePath)
+
+	if err := ReplayReader(bytes.NewReader(data), int64(len(data)), func(Record) error { return nil }); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Fatalf("ReplayReader of an encrypted log = %v, want ErrNoEncryptionKey", err)
+	}
+}
+
+func TestRotateReplaysAsOneStream(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, SequenceNumbers: true, syncer: syncer})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if syncer.count() == 0 {
+		t.Fatal("Rotate sealed a segment without syncing it")
+	}
+	mustAppend(t, w, taskCreated("c"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	mustAppend(t, w, taskCreated("d"))
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		t.Fatalf("sealedSegments: %v", err)
+	}
+	if want := []string{segmentPath(w.filePath, 1), segmentPath(w.filePath, 2)}; !reflect.DeepEqual(segments, want) {
+		t.Fatalf("sealed segments = %v, want %v", segments, want)
+	}
+
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			w = reopenTestWAL(t, w, Config{})
+		}
+		records := mustReplay(t, w)
+		if got, want := taskIDs(records), []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("reopen %t: replayed %v, want %v", reopen, got, want)
+		}
+		if got, want := seqs(records), []uint64{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("reopen %t: sequence numbers = %v, want %v", reopen, got, want)
+		}
+	}
+}
+
+func TestRotateEmptyAndClosed(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate of an empty log: %v", err)
+	}
+	mustAppend(t, w, taskCreated("a"))
+	if got := taskIDs(mustReplay(t, w)); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("replayed %v, want [a]", got)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Rotate(); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("Rotate on a closed log = %v, want ErrWALClosed", err)
+	}
+
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if err := r.Rotate(); err == nil {
+		t.Fatal("Rotate of a read-only log succeeded")
+	}
+}
+
+func TestRotateWithConcurrentReaders(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 5)
+
+	// An iterator opened before the rotation reads on past it
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	defer it.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				n := 0
+				if err := w.Replay(func(Record) error { n++; return nil }); err != nil {
+					errs <- err
+					return
+				}
+				if n < 5 {
+					errs <- fmt.Errorf("replay saw %d records, want at least 5", n)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		mustAppend(t, w, taskCreated(fmt.Sprintf("r%d", i)))
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Iterator across rotations: %v", err)
+	}
+	if n < 5 {
+		t.Fatalf("iterator read %d records, want at least the 5 appended before it", n)
+	}
+	if got := len(mustReplay(t, w)); got != 10 {
+		t.Fatalf("replayed %d records, want 10", got)
+	}
+}
@@ -0,0 +1,31 @@
+package wal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelfTestPasses(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatalf("SelfTest: %v", err)
+	}
+}
+
+func TestSelfTestCorruptionDetectsFlippedByte(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, selfTestRecords()...)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if err := selfTestCorruption(w.filePath); err != nil {
+		t.Fatalf("selfTestCorruption: %v", err)
+	}
+
+	// Flipping the byte back leaves a frame that verifies, standing in for
+	// a checksum check that no longer catches corruption
+	err := selfTestCorruption(w.filePath)
+	if err == nil || !strings.Contains(err.Error(), "not detected") {
+		t.Fatalf("selfTestCorruption on an intact frame = %v, want a detection failure", err)
+	}
+}
@@ -0,0 +1,148 @@
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// checkSequenceLocked rejects records whose caller-supplied sequence
+// number is not the one sequenceLocked would assign
+// Callers must hold w.mu
+func (w *WAL) checkSequenceLocked(records []Record) error {
+	if !w.header.sequenced() {
+		return nil
+	}
+
+	for i, record := range records {
+		if expected := w.nextSeq + uint64(i); record.Seq != 0 && record.Seq != expected {
+			return fmt.Errorf("%w: sequence %d, expected %d", ErrInvalidRecord, record.Seq, expected)
+		}
+	}
+
+	return nil
+}
+
+// sequenceLocked assigns the next sequence numbers to the encoded frames
+// in data, one per frame, whose sizes are given by sizes. Frames are
+// encoded before the lock is taken, so the number is patched in and the
+// checksum recomputed here, in append order
+// It is a no-op if the active file carries no sequence numbers
+// Callers must hold w.mu and have checked the records with
+// checkSequenceLocked
+func (w *WAL) sequenceLocked(data []byte, sizes []int) {
+	if !w.header.sequenced() {
+		return
+	}
+
+	checksum := w.header.checksum
+	pos := 0
+	for i, size := range sizes {
+		frame := data[pos : pos+size]
+		binary.LittleEndian.PutUint64(frame[lengthPrefixSize+recordTypeSize:], w.nextSeq+uint64(i))
+		covered := w.header.checksumScope().covered(frame[:size-checksum.size()])
+		checksum.appendSum(frame[len(frame)-checksum.size():][:0], covered)
+		pos += size
+	}
+	w.nextSeq += uint64(len(sizes))
+}
+
+// lastSeqLocked returns the highest sequence number in the log, or 0 if
+// there is none. Only the active file is read unless it has no sequenced
+// records, in which case sealed segments are read newest first
+// Callers must hold w.mu
+func (w *WAL) lastSeqLocked() (uint64, error) {
+	var last uint64
+	track := func(record Record, _, _ int64) error {
+		if record.Seq > last {
+			last = record.Seq
+		}
+		return nil
+	}
+
+	if _, err := w.replayFromLocked(context.Background(), 0, track); err != nil {
+		return 0, err
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return 0, err
+	}
+	for i := len(segments) - 1; i >= 0 && last == 0; i-- {
+		if err := w.replaySegment(context.Background(), segments[i], track); err != nil {
+			return 0, err
+		}
+	}
+
+	return last, nil
+}
+
+// detectGaps wraps scanFn to call onGap whenever a sequenced record does
+// not directly follow the previous sequenced record
+func detectGaps(scanFn scanFunc, onGap func(prev, seq uint64) error) scanFunc {
+	var prev uint64
+	return func(record Record, offset, size int64) error {
+		if record.Seq != 0 {
+			if prev != 0 && record.Seq != prev+1 {
+				if err := onGap(prev, record.Seq); err != nil {
+					return err
+				}
+			}
+			prev = record.Seq
+		}
+		return scanFn(record, offset, size)
+	}
+}
+
+// GapInfo is a range of sequence numbers missing from the log, Start to
+// End inclusive
+type GapInfo struct {
+	Start uint64
+	End   uint64
+}
+
+// DetectGaps scans the whole log, sealed segments included, and returns
+// the ranges of sequence numbers missing between consecutive sequenced
+// records, in log order. Numbers before the first record, e.g. dropped by
+// TruncatePrefix, are not reported, nor are numbers that repeat or go
+// backwards. Returns ErrNotSequenced unless the log uses sequence numbers
+func (w *WAL) DetectGaps() ([]GapInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil, ErrWALClosed
+	}
+	if !w.sequence && !w.header.sequenced() {
+		return nil, ErrNotSequenced
+	}
+
+	var gaps []GapInfo
+	scan := detectGaps(func(Record, int64, int64) error {
+		return nil
+	}, func(prev, seq uint64) error {
+		if seq > prev+1 {
+			gaps = append(gaps, GapInfo{Start: prev + 1, End: seq - 1})
+		}
+		return nil
+	})
+
+	// Not replayAllLocked, which fails on the first gap under StrictSequence
+	if err := w.replaySegmentsLocked(context.Background(), scan); err != nil {
+		return gaps, err
+	}
+	_, err := w.replayFromLocked(context.Background(), 0, scan)
+	return gaps, err
+}
+
+// strictSequenceCheck wraps scanFn to fail replay on a sequence gap when
+// Config.StrictSequence is set
+func (w *WAL) strictSequenceCheck(scanFn scanFunc) scanFunc {
+	if !w.strictSequence {
+		return scanFn
+	}
+
+	return detectGaps(scanFn, func(prev, seq uint64) error {
+		return fmt.Errorf("%w: %d follows %d", ErrSequenceGap, seq, prev)
+	})
+}
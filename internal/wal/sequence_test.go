@@ -0,0 +1,149 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// seqs returns the sequence number of each record
+func seqs(records []Record) []uint64 {
+	numbers := make([]uint64, len(records))
+	for i, record := range records {
+		numbers[i] = record.Seq
+	}
+	return numbers
+}
+
+// removeRecord cuts the frame between start and end out of the log at path
+func removeRecord(t *testing.T, path string, start, end int64) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data[:start:start], data[end:]...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSequenceIncrementsOnAppend(t *testing.T) {
+	config := Config{SequenceNumbers: true}
+	w := openTestWAL(t, config)
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	if err := w.AppendBatch([]Record{taskCreated("c"), taskCreated("d")}); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+
+	// Numbering continues after reopening
+	w = reopenTestWAL(t, w, config)
+	mustAppend(t, w, taskCreated("e"))
+
+	got := seqs(mustReplay(t, w))
+	for i, seq := range got {
+		if seq != uint64(i+1) {
+			t.Fatalf("sequence numbers = %v, want 1 to 5", got)
+		}
+	}
+	if len(got) != 5 {
+		t.Fatalf("replayed %d records, want 5", len(got))
+	}
+}
+
+func TestSequenceCallerSupplied(t *testing.T) {
+	w := openTestWAL(t, Config{SequenceNumbers: true})
+
+	record := taskCreated("a")
+	record.Seq = 1
+	mustAppend(t, w, record)
+
+	record = taskCreated("b")
+	record.Seq = 3
+	if err := w.Append(record); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("Append of seq 3 after 1 = %v, want ErrInvalidRecord", err)
+	}
+	record.Seq = 2
+	mustAppend(t, w, record)
+}
+
+func TestSequenceGapDetected(t *testing.T) {
+	config := Config{SequenceNumbers: true}
+	w := openTestWAL(t, config)
+	offsets := appendTasksAt(t, w, 4)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	removeRecord(t, w.filePath, offsets[1], offsets[2])
+
+	// Replay only reports the gap under StrictSequence
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if got := seqs(mustReplay(t, r)); len(got) != 3 || got[1] != 3 {
+		t.Fatalf("sequence numbers = %v, want [1 3 4]", got)
+	}
+
+	strict := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true, StrictSequence: true})
+	applied := 0
+	err := strict.Replay(func(Record) error { applied++; return nil })
+	if !errors.Is(err, ErrSequenceGap) {
+		t.Fatalf("strict Replay = %v, want ErrSequenceGap", err)
+	}
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) || replayErr.Offset != offsets[1] {
+		t.Fatalf("strict Replay = %v, want a ReplayError at offset %d", err, offsets[1])
+	}
+	if applied != 1 {
+		t.Fatalf("applied %d records before the gap, want 1", applied)
+	}
+}
+
+func TestDetectGapsContiguous(t *testing.T) {
+	w := openTestWAL(t, Config{SequenceNumbers: true, MaxSegmentBytes: 256})
+	appendTasks(t, w, 20)
+	if segments, _ := w.sealedSegments(); len(segments) == 0 {
+		t.Fatal("log did not rotate")
+	}
+
+	gaps, err := w.DetectGaps()
+	if err != nil || len(gaps) != 0 {
+		t.Fatalf("DetectGaps of a contiguous log = %v, %v", gaps, err)
+	}
+}
+
+func TestDetectGapsReportsMissingRanges(t *testing.T) {
+	w := openTestWAL(t, Config{SequenceNumbers: true})
+	offsets := appendTasksAt(t, w, 8)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// Later records first, so the earlier offsets stay valid
+	removeRecord(t, w.filePath, offsets[5], offsets[6])
+	removeRecord(t, w.filePath, offsets[1], offsets[4])
+
+	// StrictSequence does not turn the gaps into an error
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true, StrictSequence: true})
+	gaps, err := r.DetectGaps()
+	if err != nil {
+		t.Fatalf("DetectGaps: %v", err)
+	}
+	if want := []GapInfo{{Start: 2, End: 4}, {Start: 6, End: 6}}; !reflect.DeepEqual(gaps, want) {
+		t.Fatalf("DetectGaps = %v, want %v", gaps, want)
+	}
+}
+
+func TestDetectGapsRequiresSequenceNumbers(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	if _, err := w.DetectGaps(); !errors.Is(err, ErrNotSequenced) {
+		t.Fatalf("DetectGaps without sequence numbers = %v, want ErrNotSequenced", err)
+	}
+
+	s := openTestWAL(t, Config{SequenceNumbers: true})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := s.DetectGaps(); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("DetectGaps of a closed log = %v, want ErrWALClosed", err)
+	}
+}
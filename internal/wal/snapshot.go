@@ -0,0 +1,158 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SnapshotPoint returns the end of the last durable record of the active
+// file: a snapshot of state built from every record before it can be paired
+// with TruncatePrefix at that offset. Buffered or unsynced records are
+// synced first, so the point covers everything appended so far
+func (w *WAL) SnapshotPoint() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waitSyncLocked()
+	if err := w.writableLocked(); err != nil {
+		return 0, err
+	}
+
+	if w.synced != w.appended || w.writer.Buffered() > 0 {
+		if err := w.syncLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.offset, nil
+}
+
+// TruncatePrefix discards every record of the active file before offset,
+// which must be a record boundary such as one returned by SnapshotPoint
+// The records from offset on are copied into a temporary file that is
+// atomically renamed over the original, so their offsets shift down by
+// offset minus the header size. Sequence numbers are kept. Checkpoints are
+// re-encoded with their offsets moved along, see copyTailLocked
+// As with Compact, a log with sealed segments is rejected
+func (w *WAL) TruncatePrefix(offset int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waitSyncLocked()
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		return ErrSegmentedLog
+	}
+
+	if offset == 0 {
+		offset = headerSize
+	}
+	if offset < headerSize || offset > w.offset {
+		return fmt.Errorf("%w: offset %d outside log of %d bytes", ErrCorruptedLog, offset, w.offset)
+	}
+
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+	if err := w.proveBoundaryLocked(offset); err != nil {
+		return err
+	}
+
+	tmpPath := w.filePath + ".prefix"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create truncation file: %w", err)
+	}
+	if _, err := tmp.Write(w.header.encode()); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write truncated header: %w", err)
+	}
+	if err := w.copyTailLocked(tmp, offset); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy log tail: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync truncated log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close truncated log: %w", err)
+	}
+
+	if err := w.replaceActiveLocked(tmpPath); err != nil {
+		return err
+	}
+	// Discarded tasks must be forgotten as they would be on reopen
+	if w.requestIDs != nil {
+		if err := w.loadRequestIDsLocked(); err != nil {
+			return err
+		}
+	}
+	if w.state != nil {
+		return w.loadStateLocked()
+	}
+
+	return nil
+}
+
+// copyTailLocked writes the records of the active file from offset on to
+// dst, which continues from a fresh header. Frames are copied verbatim
+// except checkpoints, which are re-encoded with their offsets moved to
+// where the covered records land, or to the first record if those were
+// discarded, so ReplayFrom(checkpoint.Offset) still works. Anything after
+// the last whole record, such as a torn tail, is copied as it is
+// Callers must hold w.mu
+func (w *WAL) copyTailLocked(dst io.Writer, offset int64) error {
+	out := bufio.NewWriter(dst)
+	next := int64(headerSize)      // offset of the next frame in dst
+	moved := make(map[int64]int64) // old record offset -> new one
+
+	end, err := w.replayFromLocked(context.Background(), offset, func(record Record, at, size int64) error {
+		moved[at] = next
+		p, ok := record.Payload.(CheckpointPayload)
+		if !ok {
+			n, err := io.Copy(out, io.NewSectionReader(w.readFile, at, size))
+			next += n
+			return err
+		}
+
+		switch newOffset, ok := moved[p.Offset]; {
+		case ok:
+			p.Offset = newOffset
+		case p.Offset < offset:
+			p.Offset = headerSize
+		default:
+			p.Offset -= offset - headerSize
+		}
+		record.Payload = p
+		data, err := w.encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		n, err := out.Write(data)
+		next += int64(n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, io.NewSectionReader(w.readFile, end, w.offset-end)); err != nil {
+		return err
+	}
+	return out.Flush()
+}
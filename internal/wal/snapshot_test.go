@@ -0,0 +1,164 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotPointIsDurable(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, syncer: syncer})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+
+	point, err := w.SnapshotPoint()
+	if err != nil {
+		t.Fatalf("SnapshotPoint: %v", err)
+	}
+	if point != w.Offset() || point != w.LastSyncedOffset() {
+		t.Fatalf("SnapshotPoint = %d, want the synced end %d", point, w.Offset())
+	}
+	if syncer.count() != 1 {
+		t.Fatalf("SnapshotPoint ran %d fsyncs, want 1", syncer.count())
+	}
+
+	// Nothing new to sync the second time
+	if _, err := w.SnapshotPoint(); err != nil {
+		t.Fatalf("SnapshotPoint: %v", err)
+	}
+	if syncer.count() != 1 {
+		t.Fatalf("idle SnapshotPoint ran %d fsyncs, want 1", syncer.count())
+	}
+}
+
+func TestTruncatePrefixReplaysTail(t *testing.T) {
+	config := Config{SequenceNumbers: true}
+	w := openTestWAL(t, config)
+	appendTasks(t, w, 3)
+	point, err := w.SnapshotPoint()
+	if err != nil {
+		t.Fatalf("SnapshotPoint: %v", err)
+	}
+	mustAppend(t, w, taskCreated("t3"), taskCreated("t4"))
+	tail := w.Offset() - point
+
+	if err := w.TruncatePrefix(point); err != nil {
+		t.Fatalf("TruncatePrefix: %v", err)
+	}
+	if w.Offset() != headerSize+tail {
+		t.Fatalf("offset after TruncatePrefix = %d, want %d", w.Offset(), headerSize+tail)
+	}
+
+	// Sequence numbers are kept and continue after the tail
+	mustAppend(t, w, taskCreated("t5"))
+	w = reopenTestWAL(t, w, config)
+	records := mustReplay(t, w)
+	if got := fmt.Sprint(taskIDs(records), seqs(records)); got != "[t3 t4 t5] [4 5 6]" {
+		t.Fatalf("replayed %s, want [t3 t4 t5] [4 5 6]", got)
+	}
+}
+
+func TestTruncatePrefixRejectsBadOffsets(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 3)
+
+	for _, offset := range []int64{3, offsets[1] + 1, w.Offset() + 1} {
+		if err := w.TruncatePrefix(offset); !errors.Is(err, ErrCorruptedLog) {
+			t.Errorf("TruncatePrefix(%d) = %v, want ErrCorruptedLog", offset, err)
+		}
+	}
+	if got := len(mustReplay(t, w)); got != 3 {
+		t.Fatalf("replayed %d records after rejected truncations, want 3", got)
+	}
+}
+
+func TestTruncatePrefixRejectsSegmentedLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	if err := w.TruncatePrefix(w.Offset()); !errors.Is(err, ErrSegmentedLog) {
+		t.Fatalf("TruncatePrefix = %v, want ErrSegmentedLog", err)
+	}
+}
+
+func TestTruncatePrefixMovesCheckpointOffsets(t *testing.T) {
+	for _, config := range []Config{{}, {SequenceNumbers: true}, {Encoding: EncodingJSON}} {
+		w := openTestWAL(t, config)
+		appendTasks(t, w, 2)
+		point, err := w.SnapshotPoint()
+		if err != nil {
+			t.Fatalf("SnapshotPoint: %v", err)
+		}
+
+		// One checkpoint covers discarded records only, the other ends on a
+		// record that survives
+		mustAppend(t, w, Record{Type: RecordTypeCheckpoint, Payload: CheckpointPayload{Offset: headerSize, Timestamp: testTime}})
+		mustAppend(t, w, taskCreated("t2"))
+		appendCheckpoint(t, w)
+		mustAppend(t, w, taskCreated("t3"))
+
+		if err := w.TruncatePrefix(point); err != nil {
+			t.Fatalf("TruncatePrefix: %v", err)
+		}
+		var checkpoints []int64
+		for _, record := range mustReplay(t, w) {
+			if p, ok := record.Payload.(CheckpointPayload); ok {
+				checkpoints = append(checkpoints, p.Offset)
+			}
+		}
+		if len(checkpoints) != 2 || checkpoints[0] != headerSize {
+			t.Fatalf("checkpoint offsets after TruncatePrefix = %v, want the first at %d", checkpoints, headerSize)
+		}
+
+		// Resuming from the latest checkpoint replays just what follows it
+		cp, found, err := w.LastCheckpoint()
+		if err != nil || !found || cp.Offset >= w.Offset() {
+			t.Fatalf("LastCheckpoint = %+v, %t, %v in a log of %d bytes", cp, found, err, w.Offset())
+		}
+		var resumed []string
+		if _, err := w.ReplayFrom(cp.Offset, func(record Record) error {
+			if p, ok := record.Payload.(TaskCreatedPayload); ok {
+				resumed = append(resumed, p.TaskID)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("ReplayFrom(%d): %v", cp.Offset, err)
+		}
+		if fmt.Sprint(resumed) != "[t3]" {
+			t.Fatalf("ReplayFrom the checkpoint replayed %v, want [t3]", resumed)
+		}
+	}
+}
+
+func TestTruncatePrefixReloadsState(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w, taskCreated("old"), leaseGranted("old", "l1", "w", 1))
+	point, err := w.SnapshotPoint()
+	if err != nil {
+		t.Fatalf("SnapshotPoint: %v", err)
+	}
+	mustAppend(t, w, taskCreated("new"))
+
+	if err := w.TruncatePrefix(point); err != nil {
+		t.Fatalf("TruncatePrefix: %v", err)
+	}
+	state, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if !reflect.DeepEqual(state, w.state) {
+		t.Fatal("live state differs from a replay of the truncated log")
+	}
+
+	// The discarded task is forgotten, as it would be on reopen
+	if err := w.Append(taskCreated("old")); err != nil {
+		t.Fatalf("Append of a discarded task ID: %v", err)
+	}
+	if err := w.Append(taskCreated("new")); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("Append of a kept task ID = %v, want ErrInvariantViolation", err)
+	}
+}
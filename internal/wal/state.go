@@ -0,0 +1,542 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// TaskStatus is the authoritative state of a task
+// Its values follow docs/state_transition.md: a created task is WAITING and
+// a cancellation only revokes a lease, so there is no Created or Cancelled
+// status. SCHEDULED extends the document for tasks waiting out a backoff
+type TaskStatus uint8
+
+const (
+	TaskWaiting TaskStatus = iota + 1
+	TaskLeased
+	TaskCompleted
+	TaskFailed
+	TaskDead
+	TaskScheduled
+)
+
+// String returns the name used for the status in the design docs
+func (s TaskStatus) String() string {
+	switch s {
+	case TaskWaiting:
+		return "WAITING"
+	case TaskLeased:
+		return "LEASED"
+	case TaskCompleted:
+		return "COMPLETED"
+	case TaskFailed:
+		return "FAILED"
+	case TaskDead:
+		return "DEAD"
+	case TaskScheduled:
+		return "SCHEDULED"
+	}
+	return fmt.Sprintf("TaskStatus(%d)", uint8(s))
+}
+
+// Terminal reports whether no further transitions are allowed
+func (s TaskStatus) Terminal() bool {
+	return s == TaskCompleted || s == TaskFailed || s == TaskDead
+}
+
+// retryable reports whether a failed attempt may be retried or
+// rescheduled: the task waits for a lease, with or without a backoff
+func (s TaskStatus) retryable() bool {
+	return s == TaskWaiting || s == TaskScheduled
+}
+
+// Task is the coordinator's view of a task, derived from WAL replay
+type Task struct {
+	TaskID          string
+	Payload         []byte
+	ExecutionWindow time.Duration
+	RetryPolicy     RetryPolicy
+	RequestID       string
+	CreatedAt       time.Time
+
+	Status         TaskStatus
+	Attempt        int       // attempt number of the latest lease
+	Failures       int       // failed attempts so far
+	Retries        int       // retries recorded with TaskRetried
+	LastFailure    string    // reason of the latest failure, cleared on retry
+	CurrentLeaseID string    // empty unless Status is TaskLeased
+	LastLeaseID    string    // most recently ended lease
+	NextAttemptAt  time.Time // zero unless Status is TaskScheduled
+}
+
+// Lease is an active grant of task ownership
+// Leases only exist while their task is leased; they are removed as soon as
+// the attempt ends
+type Lease struct {
+	LeaseID   string
+	TaskID    string
+	WorkerID  string
+	Attempt   int
+	Expiry    time.Time
+	GrantedAt time.Time
+}
+
+// State is the authoritative coordinator state rebuilt from the WAL
+type State struct {
+	Tasks  map[string]*Task
+	Leases map[string]*Lease // active leases by lease ID
+
+	// LeaseTasks maps every lease ID ever granted to its task, so a reused
+	// lease ID is caught even after the lease ended
+	LeaseTasks map[string]string
+
+	// RecoveryTime is the clock replay may consult for leases that lapsed
+	// without a LeaseExpired record: a lease whose expiry is before it can
+	// be re-granted. Zero disables the check, see RebuildStateAt
+	RecoveryTime time.Time
+}
+
+// NewState returns an empty state
+func NewState() *State {
+	return &State{
+		Tasks:      make(map[string]*Task),
+		Leases:     make(map[string]*Lease),
+		LeaseTasks: make(map[string]string),
+	}
+}
+
+// ErrInvariantViolation is returned by ApplyRecord when a record would
+// perform a forbidden transition
+var ErrInvariantViolation = errors.New("wal: invariant violation")
+
+// ApplyRecord applies a record to coordinator state
+// This is called during replay and ensures invariants are preserved: the
+// record is checked against the current state first and, if the transition
+// is forbidden, ErrInvariantViolation is returned and state is unchanged
+func ApplyRecord(record Record, state *State) error {
+	if record.Type.custom() {
+		return nil // opaque to coordinator state, see RegisterPayloadCodec
+	}
+
+	switch p := record.Payload.(type) {
+	case TaskCreatedPayload:
+		if _, exists := state.Tasks[p.TaskID]; exists {
+			return violation(record, "task %s already exists", p.TaskID)
+		}
+		state.Tasks[p.TaskID] = &Task{
+			TaskID:          p.TaskID,
+			Payload:         p.Payload,
+			ExecutionWindow: p.ExecutionWindow,
+			RetryPolicy:     p.RetryPolicy,
+			RequestID:       p.RequestID,
+			CreatedAt:       p.CreatedAt,
+			Status:          TaskWaiting,
+		}
+
+	case LeaseGrantedPayload:
+		task, err := liveTask(record, state, p.TaskID)
+		if err != nil {
+			return err
+		}
+		if task.Status == TaskLeased {
+			// A lease may lapse without an explicit LeaseExpired record; the
+			// grant's own timestamp and the recovery clock tell. It is only
+			// released once the grant has passed every check
+			current := state.Leases[task.CurrentLeaseID]
+			if !leaseLapsed(current, p.GrantedAt) && !leaseLapsed(current, state.RecoveryTime) {
+				return violation(record, "task %s already has active lease %s", p.TaskID, task.CurrentLeaseID)
+			}
+		}
+		// Attempts only move forward, by one, even after the previous lease
+		// lapsed; a regression means records were replayed out of order
+		if p.Attempt <= task.Attempt {
+			return violation(record, "task %s attempt %d does not advance past attempt %d", p.TaskID, p.Attempt, task.Attempt)
+		}
+		if p.Attempt != task.Attempt+1 {
+			return violation(record, "task %s attempt %d, expected %d", p.TaskID, p.Attempt, task.Attempt+1)
+		}
+		// Lease IDs are never reused, not even by the same task
+		if owner, exists := state.LeaseTasks[p.LeaseID]; exists {
+			return violation(record, "lease %s already granted to task %s", p.LeaseID, owner)
+		}
+		if task.Status == TaskLeased {
+			releaseLease(state, task)
+		}
+		task.Status = TaskLeased
+		task.Attempt = p.Attempt
+		task.CurrentLeaseID = p.LeaseID
+		task.NextAttemptAt = time.Time{}
+		state.LeaseTasks[p.LeaseID] = p.TaskID
+		state.Leases[p.LeaseID] = &Lease{
+			LeaseID:   p.LeaseID,
+			TaskID:    p.TaskID,
+			WorkerID:  p.WorkerID,
+			Attempt:   p.Attempt,
+			Expiry:    p.LeaseExpiry,
+			GrantedAt: p.GrantedAt,
+		}
+
+	case LeaseExtendedPayload:
+		lease, ok := state.Leases[p.LeaseID]
+		if !ok {
+			return violation(record, "unknown lease %s", p.LeaseID)
+		}
+		if !p.NewLeaseExpiry.After(lease.Expiry) {
+			return violation(record, "lease %s expiry must move forward", p.LeaseID)
+		}
+		if leaseLapsed(lease, p.At) {
+			return violation(record, "lease %s extended after it expired", p.LeaseID)
+		}
+		lease.Expiry = p.NewLeaseExpiry
+
+	case TaskCompletedPayload:
+		task, err := leasedTask(record, state, p.TaskID, p.LeaseID)
+		if err != nil {
+			return err
+		}
+		task.Status = TaskCompleted
+		releaseLease(state, task)
+
+	case TaskFailedPayload:
+		task, err := leasedTask(record, state, p.TaskID, p.LeaseID)
+		if err != nil {
+			return err
+		}
+		task.Failures++
+		task.LastFailure = p.FailureReason
+		if task.Failures > task.RetryPolicy.MaxRetries {
+			task.Status = TaskFailed
+		} else {
+			task.Status = TaskWaiting
+		}
+		releaseLease(state, task)
+
+	case TaskCancelledPayload:
+		// Authority loss only; no state change
+		if _, err := liveTask(record, state, p.TaskID); err != nil {
+			return err
+		}
+
+	case TaskRetriedPayload:
+		// The attempt counter itself advances when the retry is leased
+		task, err := liveTask(record, state, p.TaskID)
+		if err != nil {
+			return err
+		}
+		if !task.Status.retryable() || task.Failures <= task.Retries {
+			return violation(record, "task %s has no failed attempt to retry", p.TaskID)
+		}
+		if p.PreviousLeaseID != task.LastLeaseID {
+			return violation(record, "task %s last lease is %s, not %s", p.TaskID, task.LastLeaseID, p.PreviousLeaseID)
+		}
+		if p.Attempt != task.Attempt+1 {
+			return violation(record, "task %s retry attempt %d, expected %d", p.TaskID, p.Attempt, task.Attempt+1)
+		}
+		task.Retries++
+		task.LastFailure = ""
+
+	case TaskRescheduledPayload:
+		// The backoff ends when the retry is leased; the attempt counter
+		// advances then
+		task, err := liveTask(record, state, p.TaskID)
+		if err != nil {
+			return err
+		}
+		if !task.Status.retryable() || task.Failures == 0 {
+			return violation(record, "task %s has no failed attempt to reschedule", p.TaskID)
+		}
+		if p.Attempt != task.Attempt+1 {
+			return violation(record, "task %s rescheduled attempt %d, expected %d", p.TaskID, p.Attempt, task.Attempt+1)
+		}
+		task.Status = TaskScheduled
+		task.NextAttemptAt = p.NextAttemptAt
+
+	case CheckpointPayload:
+		// Marker only; state was persisted elsewhere
+
+	case LeaseRenewalDeniedPayload:
+		// Audit only; the lease keeps its current expiry
+
+	case AnnotationPayload:
+		// Operator note only
+
+	case LeaseExpiredPayload:
+		task, err := leasedTask(record, state, p.TaskID, p.LeaseID)
+		if err != nil {
+			return err
+		}
+		task.Status = TaskWaiting
+		releaseLease(state, task)
+
+	case TaskDeadPayload:
+		task, err := liveTask(record, state, p.TaskID)
+		if err != nil {
+			return err
+		}
+		task.Status = TaskDead
+		releaseLease(state, task)
+
+	default:
+		return payloadMismatch(record)
+	}
+
+	return nil
+}
+
+// liveTask returns the task if it exists and is not terminal
+func liveTask(record Record, state *State, taskID string) (*Task, error) {
+	task, ok := state.Tasks[taskID]
+	if !ok {
+		return nil, violation(record, "unknown task %s", taskID)
+	}
+	if task.Status.Terminal() {
+		return nil, violation(record, "task %s is %s", taskID, task.Status)
+	}
+	return task, nil
+}
+
+// leasedTask returns the task if it is leased under leaseID
+func leasedTask(record Record, state *State, taskID, leaseID string) (*Task, error) {
+	task, err := liveTask(record, state, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status != TaskLeased {
+		return nil, violation(record, "task %s is %s, not LEASED", taskID, task.Status)
+	}
+	if task.CurrentLeaseID != leaseID {
+		return nil, violation(record, "lease %s does not own task %s", leaseID, taskID)
+	}
+	return task, nil
+}
+
+// leaseLapsed reports whether lease had expired at now
+// A zero now is unknown and never lapses a lease
+func leaseLapsed(lease *Lease, now time.Time) bool {
+	return !now.IsZero() && !now.Before(lease.Expiry)
+}
+
+// ExpireLeases releases every lease that expired at or before now and
+// returns its task to WAITING, as a LeaseExpired record would
+// Returns the number of leases released
+func (s *State) ExpireLeases(now time.Time) int {
+	expired := 0
+	for _, lease := range s.Leases {
+		if !leaseLapsed(lease, now) {
+			continue
+		}
+		task := s.Tasks[lease.TaskID]
+		task.Status = TaskWaiting
+		releaseLease(s, task)
+		expired++
+	}
+	return expired
+}
+
+// TaskStatus returns the status of a task, or false if it is unknown
+func (s *State) TaskStatus(taskID string) (TaskStatus, bool) {
+	task, ok := s.Tasks[taskID]
+	if !ok {
+		return 0, false
+	}
+	return task.Status, true
+}
+
+// ActiveLease returns the ID of the lease currently held on a task, or
+// false if the task is unknown or not leased
+func (s *State) ActiveLease(taskID string) (string, bool) {
+	task, ok := s.Tasks[taskID]
+	if !ok || task.CurrentLeaseID == "" {
+		return "", false
+	}
+	return task.CurrentLeaseID, true
+}
+
+// LeasesByExpiry returns copies of the active leases, soonest expiry first
+// and by lease ID among equal expiries, e.g. to arm lease timers after
+// recovery. Extensions are reflected, and leases that ended are gone
+func (s *State) LeasesByExpiry() []Lease {
+	leases := make([]Lease, 0, len(s.Leases))
+	for _, lease := range s.Leases {
+		leases = append(leases, *lease)
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		if !leases[i].Expiry.Equal(leases[j].Expiry) {
+			return leases[i].Expiry.Before(leases[j].Expiry)
+		}
+		return leases[i].LeaseID < leases[j].LeaseID
+	})
+	return leases
+}
+
+// PendingTasks returns the IDs of the tasks waiting to be leased, sorted
+func (s *State) PendingTasks() []string {
+	return s.tasksWithStatus(TaskWaiting)
+}
+
+// ScheduledTasks returns the IDs of the tasks waiting out a retry backoff,
+// sorted; each one's Task.NextAttemptAt says when it may be leased again
+func (s *State) ScheduledTasks() []string {
+	return s.tasksWithStatus(TaskScheduled)
+}
+
+// DeadTasks returns the IDs of the administratively terminated tasks, sorted
+func (s *State) DeadTasks() []string {
+	return s.tasksWithStatus(TaskDead)
+}
+
+// tasksWithStatus returns the sorted IDs of the tasks with the given status
+func (s *State) tasksWithStatus(status TaskStatus) []string {
+	var ids []string
+	for id, task := range s.Tasks {
+		if task.Status == status {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// releaseLease ends the task's current lease, if any
+func releaseLease(state *State, task *Task) {
+	if task.CurrentLeaseID == "" {
+		return
+	}
+	delete(state.Leases, task.CurrentLeaseID)
+	task.LastLeaseID = task.CurrentLeaseID
+	task.CurrentLeaseID = ""
+}
+
+func violation(record Record, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: record type %d: %s", ErrInvariantViolation, record.Type, fmt.Sprintf(format, args...))
+}
+
+// BuildState replays the whole log into a fresh State
+func (w *WAL) BuildState() (*State, error) {
+	state := NewState()
+	if err := w.Replay(func(record Record) error {
+		return ApplyRecord(record, state)
+	}); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SnapshotState replays the whole log into a fresh State with the lock
+// held, so no append can interleave, and returns it along with the offset
+// of the active file it covers. Records from that offset on can be applied
+// incrementally with ReplayFrom. The State shares nothing with the WAL
+func (w *WAL) SnapshotState() (*State, int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	state := NewState()
+	offset, err := w.replayAllEndLocked(context.Background(), applyOnly(func(record Record) error {
+		return ApplyRecord(record, state)
+	}))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return state, offset, nil
+}
+
+// RebuildState reconstructs coordinator state from the log at path, sealed
+// segments included, without opening it for writing
+// This is the primary recovery entry point. A torn final record is
+// discarded; a record that cannot be read or applied is reported with the
+// name of its file and a ReplayError locating it
+func RebuildState(path string) (*State, error) {
+	return RebuildStateAt(path, time.Time{})
+}
+
+// RebuildStateAt is RebuildState with a recovery clock: leases that expired
+// at or before recoveryTime may be re-granted during replay, and those
+// still held at the end of the log are expired, see State.RecoveryTime
+// Passing the time explicitly keeps recovery deterministic
+func RebuildStateAt(path string, recoveryTime time.Time) (*State, error) {
+	state := NewState()
+	state.RecoveryTime = recoveryTime
+
+	err := replayPath(path, func(_ string, record Record, _ int64) error {
+		return ApplyRecord(record, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state.ExpireLeases(recoveryTime)
+	return state, nil
+}
+
+// replayPath replays the log at path, sealed segments first, without
+// opening it for writing. fn receives the base name of the file each
+// record was read from. A torn final record of the active file is discarded
+func replayPath(path string, fn func(name string, record Record, offset int64) error) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to open WAL file: %w", err)
+	}
+
+	w := &WAL{filePath: path, readOnly: true}
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return err
+	}
+
+	files := append(segments, path)
+	for i, file := range files {
+		tail := tailNone
+		if i == len(files)-1 {
+			tail = tailTorn
+		}
+
+		name := filepath.Base(file)
+		err := w.replayFile(context.Background(), file, tail, func(record Record, offset, _ int64) error {
+			return fn(name, record, offset)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// InvariantViolation describes a record that ApplyRecord rejected
+type InvariantViolation struct {
+	File   string // base name of the log file holding the record
+	Offset int64
+	Type   RecordType
+	Reason string
+}
+
+// ValidateLog dry-runs recovery of the log at path: every record is applied
+// to a scratch State and every invariant violation is collected instead of
+// stopping at the first. A rejected record leaves the scratch state
+// unchanged, so later records are checked as if it were absent
+// The error reports a log that cannot be read, not violations
+func ValidateLog(path string) ([]InvariantViolation, error) {
+	state := NewState()
+	var violations []InvariantViolation
+
+	err := replayPath(path, func(name string, record Record, offset int64) error {
+		err := ApplyRecord(record, state)
+		if errors.Is(err, ErrInvariantViolation) {
+			violations = append(violations, InvariantViolation{
+				File:   name,
+				Offset: offset,
+				Type:   record.Type,
+				Reason: err.Error(),
+			})
+			return nil
+		}
+		return err
+	})
+
+	return violations, err
+}
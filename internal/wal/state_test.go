@@ -0,0 +1,1001 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// applyAll applies records to a fresh state, failing the test on error
+func applyAll(t *testing.T, records ...Record) *State {
+	t.Helper()
+	state := NewState()
+	for i, record := range records {
+		if err := ApplyRecord(stampRecord(record, testTime), state); err != nil {
+			t.Fatalf("ApplyRecord %d (%s): %v", i, record.Type, err)
+		}
+	}
+	return state
+}
+
+func TestApplyRecordLegalTransitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []Record
+		status  TaskStatus
+		leased  bool
+	}{
+		{"created", []Record{taskCreated("t")}, TaskWaiting, false},
+		{"leased", []Record{taskCreated("t"), leaseGranted("t", "l1", "w", 1)}, TaskLeased, true},
+		{"extended", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			leaseExtended("l1", testTime.Add(time.Hour)),
+		}, TaskLeased, true},
+		{"completed", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			taskCompleted("t", "l1"),
+		}, TaskCompleted, false},
+		{"failed with retries left", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			taskFailed("t", "l1", "boom"),
+		}, TaskWaiting, false},
+		{"failed without retries left", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			taskFailed("t", "l1", "boom"),
+			leaseGranted("t", "l2", "w", 2),
+			taskFailed("t", "l2", "boom"),
+		}, TaskFailed, false},
+		{"lease expired", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			leaseExpired("t", "l1"),
+		}, TaskWaiting, false},
+		{"re-leased after expiry", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			leaseExpired("t", "l1"),
+			leaseGranted("t", "l2", "w", 2),
+		}, TaskLeased, true},
+		{"cancelled", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			taskCancelled("t", "l1"),
+		}, TaskLeased, true},
+		{"dead", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			taskDead("t", "poison"),
+		}, TaskDead, false},
+		{"markers", []Record{
+			taskCreated("t"),
+			checkpoint(headerSize),
+			annotation("note"),
+		}, TaskWaiting, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := applyAll(t, tt.records...)
+			task := state.Tasks["t"]
+			if task.Status != tt.status {
+				t.Fatalf("status = %s, want %s", task.Status, tt.status)
+			}
+			if _, leased := state.ActiveLease("t"); leased != tt.leased {
+				t.Fatalf("leased = %t, want %t", leased, tt.leased)
+			}
+			if leases := len(state.Leases); leases > 1 || (leases == 1) != tt.leased {
+				t.Fatalf("%d active leases", leases)
+			}
+		})
+	}
+}
+
+func TestApplyRecordExtendsLease(t *testing.T) {
+	expiry := testTime.Add(time.Hour)
+	state := applyAll(t,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		leaseExtended("l1", expiry),
+	)
+	if got := state.Leases["l1"].Expiry; !got.Equal(expiry) {
+		t.Fatalf("lease expiry = %s, want %s", got, expiry)
+	}
+}
+
+func TestApplyRecordIllegalTransitions(t *testing.T) {
+	leased := []Record{taskCreated("t"), leaseGranted("t", "l1", "w", 1)}
+
+	tests := []struct {
+		name   string
+		before []Record
+		record Record
+	}{
+		{"duplicate task", []Record{taskCreated("t")}, taskCreated("t")},
+		{"lease for unknown task", nil, leaseGranted("t", "l1", "w", 1)},
+		{"second active lease", leased, leaseGranted("t", "l2", "w", 2)},
+		{"complete with a non-owning lease", leased, taskCompleted("t", "other")},
+		{"complete an unleased task", []Record{taskCreated("t")}, taskCompleted("t", "l1")},
+		{"extend an unknown lease", leased, leaseExtended("other", testTime.Add(time.Hour))},
+		{"extend backwards", leased, leaseExtended("l1", testTime)},
+		{"fail with a non-owning lease", leased, taskFailed("t", "other", "boom")},
+		{"expire a non-owning lease", leased, leaseExpired("t", "other")},
+		{"attempt does not advance", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			leaseExpired("t", "l1"),
+		}, leaseGranted("t", "l2", "w", 1)},
+		{"reused lease ID", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			leaseExpired("t", "l1"),
+		}, leaseGranted("t", "l1", "w", 2)},
+		{"lease a completed task", []Record{
+			taskCreated("t"),
+			leaseGranted("t", "l1", "w", 1),
+			taskCompleted("t", "l1"),
+		}, leaseGranted("t", "l2", "w", 2)},
+		{"kill a dead task", []Record{taskCreated("t"), taskDead("t", "poison")}, taskDead("t", "again")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := applyAll(t, tt.before...)
+			before := applyAll(t, tt.before...)
+
+			err := ApplyRecord(stampRecord(tt.record, testTime), state)
+			if !errors.Is(err, ErrInvariantViolation) {
+				t.Fatalf("ApplyRecord = %v, want ErrInvariantViolation", err)
+			}
+			if !reflect.DeepEqual(state, before) {
+				t.Fatalf("rejected record changed state: %+v, was %+v", state, before)
+			}
+		})
+	}
+}
+
+func TestBuildState(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("a"),
+		taskCreated("b"),
+		leaseGranted("a", "l1", "w", 1),
+		taskCompleted("a", "l1"),
+		leaseGranted("b", "l2", "w", 1),
+	)
+
+	state, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if state.Tasks["a"].Status != TaskCompleted || state.Tasks["b"].Status != TaskLeased {
+		t.Fatalf("statuses = %s, %s", state.Tasks["a"].Status, state.Tasks["b"].Status)
+	}
+	if lease, ok := state.ActiveLease("b"); !ok || lease != "l2" {
+		t.Fatalf("ActiveLease(b) = %s, %t", lease, ok)
+	}
+}
+
+func TestRebuildStateLifecycle(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		leaseExtended("l1", testTime.Add(time.Hour)),
+		taskCompleted("t", "l1"),
+	)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	state, err := RebuildState(w.filePath)
+	if err != nil {
+		t.Fatalf("RebuildState: %v", err)
+	}
+	task := state.Tasks["t"]
+	if task == nil || task.Status != TaskCompleted {
+		t.Fatalf("task = %+v, want COMPLETED", task)
+	}
+	if _, ok := state.ActiveLease("t"); ok || len(state.Leases) != 0 {
+		t.Fatalf("leases left after completion: %v", state.Leases)
+	}
+	if task.LastLeaseID != "l1" {
+		t.Fatalf("LastLeaseID = %s, want l1", task.LastLeaseID)
+	}
+}
+
+func TestRebuildStateReportsViolation(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("t"))
+	bad, err := w.AppendAt(taskCompleted("t", "l1")) // never leased
+	if err != nil {
+		t.Fatalf("AppendAt: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, err = RebuildState(w.filePath)
+	if !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("RebuildState = %v, want ErrInvariantViolation", err)
+	}
+	var replayErr *ReplayError
+	if !errors.As(err, &replayErr) || replayErr.Offset != bad || replayErr.RecordIndex != 1 {
+		t.Fatalf("RebuildState = %v, want a ReplayError at offset %d", err, bad)
+	}
+}
+
+func TestRebuildStateToleratesTornTail(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("t"), leaseGranted("t", "l1", "w", 1))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(w.filePath, stat.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := RebuildState(w.filePath)
+	if err != nil {
+		t.Fatalf("RebuildState: %v", err)
+	}
+	if got := state.Tasks["t"].Status; got != TaskWaiting {
+		t.Fatalf("status = %s, want WAITING without the torn grant", got)
+	}
+}
+
+func TestRebuildStateMissingFile(t *testing.T) {
+	if _, err := RebuildState(testPath(t)); err == nil {
+		t.Fatal("RebuildState of a missing log succeeded")
+	}
+}
+
+func TestApplyRecordTaskRetried(t *testing.T) {
+	state := applyAll(t,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		taskFailed("t", "l1", "boom"),
+	)
+	if task := state.Tasks["t"]; task.LastFailure != "boom" || task.Failures != 1 {
+		t.Fatalf("task after failure = %+v", task)
+	}
+
+	if err := ApplyRecord(taskRetried("t", "l1", 2), state); err != nil {
+		t.Fatalf("ApplyRecord TaskRetried: %v", err)
+	}
+	task := state.Tasks["t"]
+	if task.Retries != 1 || task.LastFailure != "" || task.Status != TaskWaiting {
+		t.Fatalf("task after retry = %+v", task)
+	}
+
+	// The retry runs as the attempt it announced
+	if err := ApplyRecord(stampRecord(leaseGranted("t", "l2", "w", 2), testTime), state); err != nil {
+		t.Fatalf("ApplyRecord LeaseGranted: %v", err)
+	}
+	if task.Attempt != 2 {
+		t.Fatalf("attempt = %d, want 2", task.Attempt)
+	}
+}
+
+func TestApplyRecordTaskRetriedRejected(t *testing.T) {
+	failed := []Record{
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		taskFailed("t", "l1", "boom"),
+	}
+
+	tests := []struct {
+		name   string
+		before []Record
+		record Record
+	}{
+		{"unknown task", nil, taskRetried("t", "l1", 2)},
+		{"never failed", []Record{taskCreated("t")}, taskRetried("t", "l1", 1)},
+		{"wrong previous lease", failed, taskRetried("t", "l0", 2)},
+		{"wrong attempt", failed, taskRetried("t", "l1", 3)},
+		{"retried twice", append(failed[:3:3], taskRetried("t", "l1", 2)), taskRetried("t", "l1", 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := applyAll(t, tt.before...)
+			if err := ApplyRecord(tt.record, state); !errors.Is(err, ErrInvariantViolation) {
+				t.Fatalf("ApplyRecord = %v, want ErrInvariantViolation", err)
+			}
+		})
+	}
+}
+
+// writeVersionedLog writes records to a new log at path in the given format
+// version, as an older release of this package would have
+func writeVersionedLog(t *testing.T, path string, version uint8, records ...Record) {
+	t.Helper()
+	header := fileHeader{version: version, checksum: ChecksumCRC32}
+	data := header.encode()
+	w := &WAL{}
+	for _, record := range records {
+		encoded, err := w.encodeRecordWith(header, stampRecord(record, testTime))
+		if err != nil {
+			t.Fatalf("encodeRecordWith: %v", err)
+		}
+		data = append(data, encoded...)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayLogWithoutTaskRetried(t *testing.T) {
+	path := testPath(t)
+	writeVersionedLog(t, path, versionInitial,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		taskFailed("t", "l1", "boom"),
+		leaseGranted("t", "l2", "w", 2),
+		taskCompleted("t", "l2"),
+	)
+
+	state, err := RebuildState(path)
+	if err != nil {
+		t.Fatalf("RebuildState: %v", err)
+	}
+	if task := state.Tasks["t"]; task.Status != TaskCompleted || task.Attempt != 2 || task.Retries != 0 {
+		t.Fatalf("task = %+v", task)
+	}
+}
+
+// regrantLog writes a log whose second grant of task t comes while the
+// first lease, expiring at testTime+1m, has no LeaseExpired record
+func regrantLog(t *testing.T) string {
+	t.Helper()
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w1", 1),
+		leaseGranted("t", "l2", "w2", 2),
+	)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return w.filePath
+}
+
+func TestRebuildStateAtRegrantsExpiredLease(t *testing.T) {
+	path := regrantLog(t)
+
+	state, err := RebuildStateAt(path, testTime.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("RebuildStateAt: %v", err)
+	}
+	task := state.Tasks["t"]
+	if task.Attempt != 2 || state.LeaseTasks["l2"] != "t" {
+		t.Fatalf("task = %+v, want attempt 2 under lease l2", task)
+	}
+	// The second lease expires at testTime+1m too, so recovery expires it
+	if task.Status != TaskWaiting || task.LastLeaseID != "l2" || len(state.Leases) != 0 {
+		t.Fatalf("task = %+v with leases %v, want WAITING with none", task, state.Leases)
+	}
+}
+
+func TestRebuildStateAtRejectsLiveLease(t *testing.T) {
+	path := regrantLog(t)
+
+	for _, recoveryTime := range []time.Time{{}, testTime.Add(30 * time.Second)} {
+		if _, err := RebuildStateAt(path, recoveryTime); !errors.Is(err, ErrInvariantViolation) {
+			t.Fatalf("RebuildStateAt(%v) = %v, want ErrInvariantViolation", recoveryTime, err)
+		}
+	}
+}
+
+func TestRebuildStateAtExpiresHeldLeases(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("t"), leaseGranted("t", "l1", "w", 1))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	state, err := RebuildStateAt(w.filePath, testTime.Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("RebuildStateAt: %v", err)
+	}
+	if state.Tasks["t"].Status != TaskLeased {
+		t.Fatalf("status = %s, want LEASED before expiry", state.Tasks["t"].Status)
+	}
+
+	state, err = RebuildStateAt(w.filePath, testTime.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("RebuildStateAt: %v", err)
+	}
+	if state.Tasks["t"].Status != TaskWaiting {
+		t.Fatalf("status = %s, want WAITING at expiry", state.Tasks["t"].Status)
+	}
+}
+
+func TestValidateLogReportsEveryViolation(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	completed, err := w.AppendAt(taskCompleted("a", "l1")) // never leased
+	if err != nil {
+		t.Fatalf("AppendAt: %v", err)
+	}
+	mustAppend(t, w, leaseGranted("a", "l1", "w", 1))
+	duplicate, err := w.AppendAt(taskCreated("a"))
+	if err != nil {
+		t.Fatalf("AppendAt: %v", err)
+	}
+	mustAppend(t, w, taskCompleted("a", "l1"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	violations, err := ValidateLog(w.filePath)
+	if err != nil {
+		t.Fatalf("ValidateLog: %v", err)
+	}
+	want := []struct {
+		offset int64
+		typ    RecordType
+	}{{completed, RecordTypeTaskCompleted}, {duplicate, RecordTypeTaskCreated}}
+	// Rejected records leave the scratch state unchanged, so the final
+	// completion of the leased task is accepted
+	if len(violations) != len(want) {
+		t.Fatalf("ValidateLog = %+v, want %d violations", violations, len(want))
+	}
+	for i, v := range violations {
+		if v.Offset != want[i].offset || v.Type != want[i].typ || v.File != filepath.Base(w.filePath) || v.Reason == "" {
+			t.Fatalf("violation %d = %+v, want %s at offset %d", i, v, want[i].typ, want[i].offset)
+		}
+	}
+}
+
+func TestValidateLogClean(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1), taskCompleted("a", "l1"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	violations, err := ValidateLog(w.filePath)
+	if err != nil || len(violations) != 0 {
+		t.Fatalf("ValidateLog = %v, %v, want no violations", violations, err)
+	}
+	if _, err := ValidateLog(testPath(t)); err == nil {
+		t.Fatal("ValidateLog of a missing log succeeded")
+	}
+}
+
+func TestApplyRecordOrdersExtensionByAt(t *testing.T) {
+	extendAt := func(at time.Time) Record {
+		return Record{Type: RecordTypeLeaseExtended, Payload: LeaseExtendedPayload{
+			LeaseID:        "l1",
+			NewLeaseExpiry: testTime.Add(time.Hour),
+			At:             at,
+		}}
+	}
+
+	// The lease granted at testTime expires at testTime+1m
+	for _, test := range []struct {
+		name string
+		at   time.Time
+		ok   bool
+	}{
+		{"before expiry", testTime.Add(30 * time.Second), true},
+		{"at expiry", testTime.Add(time.Minute), false},
+		{"after expiry", testTime.Add(2 * time.Minute), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			state := applyAll(t, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+			err := ApplyRecord(extendAt(test.at), state)
+			if test.ok != (err == nil) {
+				t.Fatalf("ApplyRecord = %v, want ok %v", err, test.ok)
+			}
+			if !test.ok && !errors.Is(err, ErrInvariantViolation) {
+				t.Fatalf("ApplyRecord = %v, want ErrInvariantViolation", err)
+			}
+		})
+	}
+
+	// Records of version 1 files carry no At and are not ordered
+	state := applyAll(t, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+	if err := ApplyRecord(extendAt(time.Time{}), state); err != nil {
+		t.Fatalf("ApplyRecord without At: %v", err)
+	}
+}
+
+func TestSnapshotStateConcurrentWithAppends(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 16})
+
+	const n = 200
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			id := fmt.Sprintf("t%d", i)
+			if err := w.AppendBatch([]Record{taskCreated(id), leaseGranted(id, "l-"+id, "w", 1)}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	var snapshots []*State
+	var offsets []int64
+	for len(snapshots) < 10 {
+		state, offset, err := w.SnapshotState()
+		if err != nil {
+			t.Fatalf("SnapshotState: %v", err)
+		}
+		snapshots = append(snapshots, state)
+		offsets = append(offsets, offset)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+
+	final, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	for i, snapshot := range snapshots {
+		// Each snapshot is exactly the records before its offset
+		prefix := NewState()
+		err := w.ReplayWithOffset(func(offset int64, record Record) error {
+			if offset >= offsets[i] {
+				return nil
+			}
+			return ApplyRecord(record, prefix)
+		})
+		if err != nil {
+			t.Fatalf("ReplayWithOffset: %v", err)
+		}
+		if !reflect.DeepEqual(snapshot, prefix) {
+			t.Fatalf("snapshot %d at offset %d has %d tasks, want %d", i, offsets[i], len(snapshot.Tasks), len(prefix.Tasks))
+		}
+
+		// and catches up to the log from there
+		if _, err := w.ReplayFrom(offsets[i], func(record Record) error {
+			return ApplyRecord(record, snapshot)
+		}); err != nil {
+			t.Fatalf("ReplayFrom(%d): %v", offsets[i], err)
+		}
+		if !reflect.DeepEqual(snapshot, final) {
+			t.Fatalf("snapshot %d caught up differs from the final state", i)
+		}
+	}
+}
+
+func TestSnapshotStateSharesNothing(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w, taskCreated("a"), leaseGranted("a", "l1", "w", 1))
+
+	state, _, err := w.SnapshotState()
+	if err != nil {
+		t.Fatalf("SnapshotState: %v", err)
+	}
+	state.Tasks["a"].Status = TaskCompleted
+	delete(state.Leases, "l1")
+
+	// The WAL's own state still holds the lease
+	mustAppend(t, w, taskCompleted("a", "l1"))
+	again, _, err := w.SnapshotState()
+	if err != nil {
+		t.Fatalf("SnapshotState: %v", err)
+	}
+	if again.Tasks["a"].Status != TaskCompleted || len(again.Leases) != 0 {
+		t.Fatalf("task = %+v, leases %v, want COMPLETED with none", again.Tasks["a"], again.Leases)
+	}
+}
+
+func TestApplyRecordAttemptsMoveForward(t *testing.T) {
+	inOrder := []Record{
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		leaseExpired("t", "l1"),
+		leaseGranted("t", "l2", "w", 2),
+		leaseExpired("t", "l2"),
+		leaseGranted("t", "l3", "w", 3),
+	}
+	state := applyAll(t, inOrder...)
+	if task := state.Tasks["t"]; task.Attempt != 3 || task.CurrentLeaseID != "l3" {
+		t.Fatalf("task = %+v, want attempt 3 under l3", task)
+	}
+
+	// Attempt 2 replayed after attempt 3, e.g. from a bad merge, would
+	// regress the task even though lease l3 lapsed
+	state = applyAll(t, inOrder...)
+	state.RecoveryTime = testTime.Add(time.Hour)
+	err := ApplyRecord(stampRecord(leaseGranted("t", "l4", "w", 2), testTime), state)
+	if !errors.Is(err, ErrInvariantViolation) || !strings.Contains(err.Error(), "does not advance") {
+		t.Fatalf("ApplyRecord of attempt 2 after 3 = %v, want an attempt violation", err)
+	}
+	before := applyAll(t, inOrder...)
+	before.RecoveryTime = state.RecoveryTime
+	if !reflect.DeepEqual(state, before) {
+		t.Fatalf("rejected grant changed state: %+v, was %+v", state.Tasks["t"], before.Tasks["t"])
+	}
+
+	// Skipping an attempt is rejected too
+	err = ApplyRecord(stampRecord(leaseGranted("t", "l4", "w", 5), testTime), state)
+	if !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("ApplyRecord of attempt 5 after 3 = %v, want ErrInvariantViolation", err)
+	}
+}
+
+func leaseRenewalDenied(taskID, leaseID string) Record {
+	return Record{Type: RecordTypeLeaseRenewalDenied, Payload: LeaseRenewalDeniedPayload{
+		TaskID:  taskID,
+		LeaseID: leaseID,
+		Reason:  "coordinator rejected the extension",
+	}}
+}
+
+func TestApplyRecordLeaseRenewalDeniedIsAuditOnly(t *testing.T) {
+	records := []Record{
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		leaseExtended("l1", testTime.Add(time.Hour)),
+	}
+	want := applyAll(t, records...)
+
+	// A denial leaves the lease as it was, even one naming an unknown task
+	got := applyAll(t, append(records, leaseRenewalDenied("t", "l1"), leaseRenewalDenied("gone", "l9"))...)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("denial changed state: %+v, want %+v", got.Tasks["t"], want.Tasks["t"])
+	}
+}
+
+func TestLeaseRenewalDeniedRoundTrip(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w, taskCreated("t"), leaseGranted("t", "l1", "w", 1), leaseRenewalDenied("t", "l1"), taskCompleted("t", "l1"))
+	w = reopenTestWAL(t, w, Config{EnforceLifecycle: true})
+
+	records := mustReplay(t, w)
+	if len(records) != 4 {
+		t.Fatalf("replayed %d records, want 4", len(records))
+	}
+	denied, ok := records[2].Payload.(LeaseRenewalDeniedPayload)
+	if !ok {
+		t.Fatalf("record 2 is %s, want LeaseRenewalDenied", records[2].Type)
+	}
+	want := leaseRenewalDenied("t", "l1").Payload.(LeaseRenewalDeniedPayload)
+	want.At = testTime // stamped on append
+	if denied != want {
+		t.Fatalf("denial = %+v, want %+v", denied, want)
+	}
+	state, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if got := state.Tasks["t"].Status; got != TaskCompleted {
+		t.Fatalf("task is %s after replay, want COMPLETED", got)
+	}
+}
+
+func TestReplayLogWithoutLeaseRenewalDenied(t *testing.T) {
+	path := testPath(t)
+	writeVersionedLog(t, path, versionInitial,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		leaseExtended("l1", testTime.Add(time.Hour)),
+		taskCompleted("t", "l1"),
+	)
+
+	state, err := RebuildState(path)
+	if err != nil {
+		t.Fatalf("RebuildState: %v", err)
+	}
+	if got := state.Tasks["t"].Status; got != TaskCompleted {
+		t.Fatalf("task is %s, want COMPLETED", got)
+	}
+}
+
+func TestStateQueries(t *testing.T) {
+	path := testPath(t)
+	writeVersionedLog(t, path, formatVersion,
+		taskCreated("waiting"),
+		taskCreated("leased"),
+		leaseGranted("leased", "l1", "w1", 1),
+		taskCreated("completed"),
+		leaseGranted("completed", "l2", "w1", 1),
+		taskCompleted("completed", "l2"),
+		taskCreated("failed"),
+		leaseGranted("failed", "l3", "w2", 1),
+		taskFailed("failed", "l3", "boom"),
+		leaseGranted("failed", "l4", "w2", 2),
+		taskFailed("failed", "l4", "boom"),
+		taskCreated("cancelled"),
+		leaseGranted("cancelled", "l5", "w2", 1),
+		taskCancelled("cancelled", "l5"),
+		taskCreated("dead"),
+		taskDead("dead", "operator"),
+		taskCreated("also-waiting"),
+		leaseGranted("also-waiting", "l6", "w3", 1),
+		leaseExpired("also-waiting", "l6"),
+	)
+	state, err := RebuildState(path)
+	if err != nil {
+		t.Fatalf("RebuildState: %v", err)
+	}
+
+	statuses := map[string]TaskStatus{
+		"waiting":      TaskWaiting,
+		"leased":       TaskLeased,
+		"completed":    TaskCompleted,
+		"failed":       TaskFailed,
+		"cancelled":    TaskLeased, // authority revoked, status unchanged
+		"dead":         TaskDead,
+		"also-waiting": TaskWaiting,
+	}
+	for id, want := range statuses {
+		if got, ok := state.TaskStatus(id); !ok || got != want {
+			t.Errorf("TaskStatus(%s) = %s, %t, want %s", id, got, ok, want)
+		}
+	}
+	if _, ok := state.TaskStatus("unknown"); ok {
+		t.Error("TaskStatus of an unknown task found it")
+	}
+
+	// A cancellation changes nothing in the log's state, not even the lease
+	for id, want := range map[string]string{"leased": "l1", "cancelled": "l5"} {
+		if lease, ok := state.ActiveLease(id); !ok || lease != want {
+			t.Errorf("ActiveLease(%s) = %s, %t, want %s", id, lease, ok, want)
+		}
+	}
+	for _, id := range []string{"waiting", "completed", "failed", "dead", "also-waiting", "unknown"} {
+		if lease, ok := state.ActiveLease(id); ok {
+			t.Errorf("ActiveLease(%s) = %s, want none", id, lease)
+		}
+	}
+
+	if got, want := state.PendingTasks(), []string{"also-waiting", "waiting"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PendingTasks = %v, want %v", got, want)
+	}
+	if got, want := state.DeadTasks(), []string{"dead"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DeadTasks = %v, want %v", got, want)
+	}
+	if got := state.ScheduledTasks(); len(got) != 0 {
+		t.Errorf("ScheduledTasks = %v, want none", got)
+	}
+}
+
+func TestStateQueriesEmpty(t *testing.T) {
+	state := NewState()
+	if _, ok := state.TaskStatus("t"); ok {
+		t.Error("TaskStatus found a task in an empty state")
+	}
+	if _, ok := state.ActiveLease("t"); ok {
+		t.Error("ActiveLease found a lease in an empty state")
+	}
+	if got := state.PendingTasks(); len(got) != 0 {
+		t.Errorf("PendingTasks = %v, want none", got)
+	}
+	if got := state.DeadTasks(); len(got) != 0 {
+		t.Errorf("DeadTasks = %v, want none", got)
+	}
+}
+
+func TestApplyRecordUniqueLeaseIDs(t *testing.T) {
+	state := applyAll(t,
+		taskCreated("a"),
+		taskCreated("b"),
+		leaseGranted("a", "l1", "w", 1),
+		leaseGranted("b", "l2", "w", 1),
+		taskFailed("a", "l1", "boom"),
+		leaseGranted("a", "l3", "w", 2),
+	)
+	want := map[string]string{"l1": "a", "l2": "b", "l3": "a"}
+	if !reflect.DeepEqual(state.LeaseTasks, want) {
+		t.Fatalf("LeaseTasks = %v, want %v", state.LeaseTasks, want)
+	}
+}
+
+func TestApplyRecordRejectsReusedLeaseID(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []Record
+		grant   Record
+		owner   string
+	}{
+		{
+			"active lease of another task",
+			[]Record{taskCreated("a"), taskCreated("b"), leaseGranted("a", "l1", "w", 1)},
+			leaseGranted("b", "l1", "w", 1),
+			"a",
+		},
+		{
+			"ended lease of another task",
+			[]Record{taskCreated("a"), taskCreated("b"), leaseGranted("a", "l1", "w", 1), taskCompleted("a", "l1")},
+			leaseGranted("b", "l1", "w", 1),
+			"a",
+		},
+		{
+			"earlier lease of the same task",
+			[]Record{taskCreated("a"), leaseGranted("a", "l1", "w", 1), leaseExpired("a", "l1")},
+			leaseGranted("a", "l1", "w", 2),
+			"a",
+		},
+	}
+	for _, tt := range tests {
+		state := applyAll(t, tt.records...)
+		before := applyAll(t, tt.records...)
+
+		err := ApplyRecord(tt.grant, state)
+		if !errors.Is(err, ErrInvariantViolation) || !strings.Contains(err.Error(), "already granted to task "+tt.owner) {
+			t.Errorf("%s: ApplyRecord = %v, want a violation naming task %s", tt.name, err, tt.owner)
+		}
+		if !reflect.DeepEqual(state, before) {
+			t.Errorf("%s: rejected grant changed state", tt.name)
+		}
+	}
+}
+
+func TestReusedLeaseIDRejectedAcrossReopen(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"), leaseGranted("a", "l1", "w", 1), taskCompleted("a", "l1"))
+
+	w = reopenTestWAL(t, w, Config{EnforceLifecycle: true})
+	if err := w.Append(leaseGranted("b", "l1", "w", 1)); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("Append of a reused lease ID after reopen = %v, want ErrInvariantViolation", err)
+	}
+
+	// Without enforcement the duplicate is written, and replay rejects it
+	w = reopenTestWAL(t, w, Config{})
+	mustAppend(t, w, leaseGranted("b", "l1", "w", 1))
+	if _, err := RebuildState(w.filePath); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("RebuildState = %v, want ErrInvariantViolation", err)
+	}
+}
+
+// leaseGrantedUntil is leaseGranted with the given expiry
+func leaseGrantedUntil(taskID, leaseID string, expiry time.Time) Record {
+	record := leaseGranted(taskID, leaseID, "w", 1)
+	p := record.Payload.(LeaseGrantedPayload)
+	p.LeaseExpiry = expiry
+	record.Payload = p
+	return record
+}
+
+func TestLeasesByExpiry(t *testing.T) {
+	at := func(minutes int) time.Time { return testTime.Add(time.Duration(minutes) * time.Minute) }
+	w := openTestWAL(t, Config{})
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		mustAppend(t, w, taskCreated(id))
+	}
+	mustAppend(t, w,
+		leaseGrantedUntil("a", "la", at(30)),
+		leaseGrantedUntil("b", "lb", at(10)),
+		leaseGrantedUntil("c", "lc", at(20)),
+		leaseGrantedUntil("d", "ld", at(5)),
+		leaseGrantedUntil("e", "le", at(20)),
+		leaseGrantedUntil("f", "lf", at(1)),
+		leaseExtended("lb", at(40)), // moves to the back
+		leaseExpired("d", "ld"),     // gone
+		taskCompleted("f", "lf"),    // gone
+	)
+
+	state, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	leases := state.LeasesByExpiry()
+	var ids []string
+	for _, lease := range leases {
+		ids = append(ids, lease.LeaseID)
+	}
+	// Equal expiries are ordered by lease ID
+	if want := []string{"lc", "le", "la", "lb"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("LeasesByExpiry = %v, want %v", ids, want)
+	}
+	if !leases[3].Expiry.Equal(at(40)) || leases[3].TaskID != "b" {
+		t.Fatalf("extended lease = %+v", leases[3])
+	}
+
+	// The leases are copies
+	leases[0].Expiry = at(100)
+	if !state.Leases["lc"].Expiry.Equal(at(20)) {
+		t.Fatal("LeasesByExpiry returned the state's own leases")
+	}
+	if got := NewState().LeasesByExpiry(); len(got) != 0 {
+		t.Fatalf("LeasesByExpiry of an empty state = %v", got)
+	}
+}
+
+func taskRescheduled(taskID string, attempt int, backoff time.Duration) Record {
+	return Record{Type: RecordTypeTaskRescheduled, Payload: TaskRescheduledPayload{
+		TaskID:        taskID,
+		NextAttemptAt: testTime.Add(backoff),
+		Attempt:       attempt,
+		Backoff:       backoff,
+	}}
+}
+
+func TestTaskRescheduledSchedulesTask(t *testing.T) {
+	w := openTestWAL(t, Config{EnforceLifecycle: true})
+	mustAppend(t, w,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		taskFailed("t", "l1", "boom"),
+		taskRescheduled("t", 2, 5*time.Minute),
+		taskCreated("other"),
+	)
+
+	// The backoff survives a round trip through the log and recovery
+	w = reopenTestWAL(t, w, Config{EnforceLifecycle: true})
+	records := mustReplay(t, w)
+	if got, want := records[3], stampRecord(taskRescheduled("t", 2, 5*time.Minute), testTime); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %+v, want %+v", got, want)
+	}
+	state, err := w.BuildState()
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	task := state.Tasks["t"]
+	if task.Status != TaskScheduled || !task.NextAttemptAt.Equal(testTime.Add(5*time.Minute)) {
+		t.Fatalf("task = %s next attempt %v, want SCHEDULED at %v", task.Status, task.NextAttemptAt, testTime.Add(5*time.Minute))
+	}
+	if got := state.ScheduledTasks(); !reflect.DeepEqual(got, []string{"t"}) {
+		t.Fatalf("ScheduledTasks = %v, want [t]", got)
+	}
+	if got := state.PendingTasks(); !reflect.DeepEqual(got, []string{"other"}) {
+		t.Fatalf("PendingTasks = %v, want [other]", got)
+	}
+
+	// The retry's lease ends the backoff
+	mustAppend(t, w, leaseGranted("t", "l2", "w", 2))
+	if state, err = w.BuildState(); err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if task := state.Tasks["t"]; task.Status != TaskLeased || !task.NextAttemptAt.IsZero() || task.Attempt != 2 {
+		t.Fatalf("task after the retry's lease = %+v", task)
+	}
+	if got := state.ScheduledTasks(); len(got) != 0 {
+		t.Fatalf("ScheduledTasks = %v after the retry was leased", got)
+	}
+}
+
+func TestTaskRescheduledKeepsBackoffOnRetry(t *testing.T) {
+	// A retry recorded during the backoff does not cut it short
+	state := applyAll(t,
+		taskCreated("t"),
+		leaseGranted("t", "l1", "w", 1),
+		taskFailed("t", "l1", "boom"),
+		taskRescheduled("t", 2, time.Minute),
+		taskRetried("t", "l1", 2),
+	)
+	if task := state.Tasks["t"]; task.Status != TaskScheduled || !task.NextAttemptAt.Equal(testTime.Add(time.Minute)) || task.Retries != 1 {
+		t.Fatalf("task rescheduled then retried = %+v", task)
+	}
+}
+
+func TestTaskRescheduledViolations(t *testing.T) {
+	failed := []Record{taskCreated("t"), leaseGranted("t", "l1", "w", 1), taskFailed("t", "l1", "boom")}
+	tests := []struct {
+		name    string
+		history []Record
+		record  Record
+	}{
+		{"unknown task", nil, taskRescheduled("t", 2, time.Minute)},
+		{"never failed", []Record{taskCreated("t")}, taskRescheduled("t", 1, time.Minute)},
+		{"leased", failed[:2], taskRescheduled("t", 2, time.Minute)},
+		{"attempt skipped", failed, taskRescheduled("t", 3, time.Minute)},
+		{"attempt repeated", failed, taskRescheduled("t", 1, time.Minute)},
+		{"completed", []Record{taskCreated("t"), leaseGranted("t", "l1", "w", 1), taskCompleted("t", "l1")}, taskRescheduled("t", 2, time.Minute)},
+	}
+	for _, tt := range tests {
+		state := applyAll(t, tt.history...)
+		if err := ApplyRecord(tt.record, state); err == nil {
+			t.Errorf("%s: ApplyRecord of TaskRescheduled succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stats summarizes the contents of the log
+type Stats struct {
+	TotalRecords int
+	RecordCounts map[RecordType]int
+	TotalBytes   int64 // bytes of valid record frames, excluding file headers
+
+	// LastRecordOffset is the offset of the last valid record in the active
+	// file, or -1 if the active file holds no records
+	LastRecordOffset int64
+
+	// SequenceGaps counts places where a sequence number does not follow
+	// the previous one, see Config.SequenceNumbers
+	SequenceGaps int
+}
+
+// Stats replays the whole log, sealed segments included, and reports what
+// it contains. A torn final record is ignored, as in Replay
+func (w *WAL) Stats() (Stats, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := Stats{
+		RecordCounts:     make(map[RecordType]int),
+		LastRecordOffset: -1,
+	}
+	if w.file == nil {
+		return stats, ErrWALClosed
+	}
+
+	count := detectGaps(func(record Record, _, size int64) error {
+		stats.TotalRecords++
+		stats.RecordCounts[record.Type]++
+		stats.TotalBytes += size
+		return nil
+	}, func(_, _ uint64) error {
+		stats.SequenceGaps++
+		return nil
+	})
+
+	if err := w.replaySegmentsLocked(context.Background(), count); err != nil {
+		return stats, err
+	}
+
+	_, err := w.replayFromLocked(context.Background(), 0, func(record Record, offset, size int64) error {
+		stats.LastRecordOffset = offset
+		return count(record, offset, size)
+	})
+	return stats, err
+}
+
+// CountRecords counts the records of the whole log, sealed segments
+// included, checking only frame lengths and checksums. Payloads are not
+// decoded, which makes it much cheaper than Replay on large logs, but a
+// frame whose payload would fail to decode is still counted
+// A torn final record is ignored, as in Replay
+func (w *WAL) CountRecords() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, ErrWALClosed
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, path := range segments {
+		n, err := w.countSegment(path)
+		if err != nil {
+			return count, fmt.Errorf("segment %s: %w", filepath.Base(path), err)
+		}
+		count += n
+	}
+
+	// Buffered records must be visible to the reader
+	if err := w.flushLocked(); err != nil {
+		return count, err
+	}
+	r := bufio.NewReader(io.NewSectionReader(w.readFile, headerSize, w.offset-headerSize))
+	n, err := countFrames(r, w.format(w.header), w.activeTailPolicy())
+	return count + n, err
+}
+
+// countSegment counts the records of a sealed segment
+func (w *WAL) countSegment(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header, err := readHeader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return countFrames(r, w.format(header), tailNone)
+}
+
+// countFrames counts the valid frames in r, applying the tail policy to a
+// bad final frame as replayRecords does
+func countFrames(r *bufio.Reader, format frameFormat, tail tailPolicy) (int, error) {
+	count := 0
+	for {
+		_, _, err := readFrame(r, format)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			if tail.tolerates(err) && atEOF(r) {
+				return count, nil
+			}
+			if errors.Is(err, ErrPartialWrite) || errors.Is(err, ErrInvalidChecksum) {
+				err = fmt.Errorf("%w: bad record: %w", ErrCorruptedLog, err)
+			}
+			return count, fmt.Errorf("failed to read record %d: %w", count, err)
+		}
+		count++
+	}
+}
+
+// Size returns the size of the active file, counting records still in the
+// write buffer, without reading the log. Sealed segments are not included
+func (w *WAL) Size() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, ErrWALClosed
+	}
+
+	return w.offset, nil
+}
+
+// TotalBytesWritten returns the number of bytes ever appended to the log,
+// frames included, e.g. for a write-rate dashboard. Unlike Size it keeps
+// growing across rotation, compaction, truncation and Reset, and it is
+// persisted in the manifest, so it survives reopening. Records dropped
+// before reaching the file, by a failed write or Repair, are not counted
+func (w *WAL) TotalBytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.written
+}
+
+// LastModified returns the modification time of the active file
+// Records still in the write buffer have not touched the file yet
+func (w *WAL) LastModified() (time.Time, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return time.Time{}, ErrWALClosed
+	}
+
+	stat, err := w.file.Stat()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+
+	return stat.ModTime(), nil
+}
@@ -0,0 +1,339 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStatsCountsRecordMix(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		taskCreated("a"),
+		taskCreated("b"),
+		taskCreated("c"),
+		leaseGranted("a", "l1", "w1", 1),
+		leaseGranted("b", "l2", "w1", 1),
+		taskCompleted("a", "l1"),
+		taskDead("c", "poison"),
+	)
+	last, err := w.AppendAt(annotation("done"))
+	if err != nil {
+		t.Fatalf("AppendAt: %v", err)
+	}
+
+	stats, err := w.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	want := map[RecordType]int{
+		RecordTypeTaskCreated:   3,
+		RecordTypeLeaseGranted:  2,
+		RecordTypeTaskCompleted: 1,
+		RecordTypeTaskDead:      1,
+		RecordTypeAnnotation:    1,
+	}
+	if !reflect.DeepEqual(stats.RecordCounts, want) {
+		t.Fatalf("RecordCounts = %v, want %v", stats.RecordCounts, want)
+	}
+	if stats.TotalRecords != 8 {
+		t.Fatalf("TotalRecords = %d, want 8", stats.TotalRecords)
+	}
+	if stats.TotalBytes != w.Offset()-headerSize {
+		t.Fatalf("TotalBytes = %d, want %d", stats.TotalBytes, w.Offset()-headerSize)
+	}
+	if stats.LastRecordOffset != last {
+		t.Fatalf("LastRecordOffset = %d, want %d", stats.LastRecordOffset, last)
+	}
+}
+
+func TestStatsEmptyLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+
+	stats, err := w.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.TotalRecords != 0 || stats.TotalBytes != 0 || stats.LastRecordOffset != -1 {
+		t.Fatalf("Stats of an empty log = %+v", stats)
+	}
+}
+
+func TestStatsIgnoresTornTail(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(w.filePath, stat.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	stats, err := r.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.TotalRecords != 1 || stats.LastRecordOffset != headerSize {
+		t.Fatalf("Stats = %+v, want the first record only", stats)
+	}
+}
+
+func TestCountRecordsMatchesStats(t *testing.T) {
+	w := openTestWAL(t, Config{MaxSegmentBytes: 512, SyncBatchSize: 100})
+	appendTasks(t, w, 20)
+	mustAppend(t, w, annotation("buffered")) // still in the write buffer
+	if segments, _ := w.sealedSegments(); len(segments) == 0 {
+		t.Fatal("log did not rotate")
+	}
+
+	count, err := w.CountRecords()
+	if err != nil {
+		t.Fatalf("CountRecords: %v", err)
+	}
+	stats, err := w.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if count != 21 || count != stats.TotalRecords {
+		t.Fatalf("CountRecords = %d, Stats.TotalRecords = %d, want 21", count, stats.TotalRecords)
+	}
+}
+
+func TestCountRecordsIgnoresTornTail(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(w.filePath, stat.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if count, err := r.CountRecords(); err != nil || count != 2 {
+		t.Fatalf("CountRecords = %d, %v, want 2", count, err)
+	}
+}
+
+// BenchmarkCountRecords compares counting the records of a log with a
+// full replay, which also decodes every payload
+func BenchmarkCountRecords(b *testing.B) {
+	w := openTestWAL(b, Config{SyncBatchSize: 1 << 20})
+	batch := make([]Record, 10000)
+	for i := range batch {
+		batch[i] = bigTask(fmt.Sprintf("t%d", i), 1024)
+	}
+	if err := w.AppendBatch(batch); err != nil {
+		b.Fatalf("AppendBatch: %v", err)
+	}
+
+	b.Run("count", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := w.CountRecords(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("replay", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := w.Replay(func(Record) error { return nil }); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSizeGrowsByEncodedLength(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	size, err := w.Size()
+	if err != nil {
+		t.Fatalf("Size: %v", err)
+	}
+	if size != headerSize {
+		t.Fatalf("Size of an empty log = %d, want %d", size, headerSize)
+	}
+
+	record := taskCreated("a")
+	encoded, err := w.encodeRecord(stampRecord(record, testTime))
+	if err != nil {
+		t.Fatalf("encodeRecord: %v", err)
+	}
+	mustAppend(t, w, record)
+
+	// Buffered bytes are counted before they reach the file
+	want := size + int64(len(encoded))
+	if got, _ := w.Size(); got != want {
+		t.Fatalf("Size after Append = %d, want %d", got, want)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got, _ := w.Size(); got != want {
+		t.Fatalf("Size after Flush = %d, want %d", got, want)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != want {
+		t.Fatalf("file size after Flush = %d, want %d", stat.Size(), want)
+	}
+}
+
+func TestLastModifiedFollowsFlush(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(w.filePath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	mustAppend(t, w, taskCreated("a"))
+	if got, err := w.LastModified(); err != nil || !got.Equal(past) {
+		t.Fatalf("LastModified before Flush = %v, %v, want %v", got, err, past)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got, _ := w.LastModified(); !got.After(past) {
+		t.Fatalf("LastModified after Flush = %v, want after %v", got, past)
+	}
+}
+
+func TestSizeClosed(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := w.Size(); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("Size = %v, want ErrWALClosed", err)
+	}
+	if _, err := w.LastModified(); !errors.Is(err, ErrWALClosed) {
+		t.Fatalf("LastModified = %v, want ErrWALClosed", err)
+	}
+}
+
+// appendedBytes appends records to w and returns the bytes they took
+func appendedBytes(t *testing.T, w *WAL, records ...Record) uint64 {
+	t.Helper()
+	start := w.Offset()
+	mustAppend(t, w, records...)
+	return uint64(w.Offset() - start)
+}
+
+func TestTotalBytesWrittenAccumulatesAcrossRotation(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if got := w.TotalBytesWritten(); got != 0 {
+		t.Fatalf("TotalBytesWritten of a new log = %d, want 0", got)
+	}
+	want := appendedBytes(t, w, taskCreated("a"), taskCreated("b"))
+	if got := w.TotalBytesWritten(); got != want {
+		t.Fatalf("TotalBytesWritten = %d, want %d", got, want)
+	}
+
+	// The active file starts over, the counter does not
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if size, _ := w.Size(); size != headerSize {
+		t.Fatalf("Size after Rotate = %d, want %d", size, headerSize)
+	}
+	if got := w.TotalBytesWritten(); got != want {
+		t.Fatalf("TotalBytesWritten after Rotate = %d, want %d", got, want)
+	}
+	want += appendedBytes(t, w, taskCreated("c"), bigTask("big", streamMinBytes))
+	if got := w.TotalBytesWritten(); got != want {
+		t.Fatalf("TotalBytesWritten after appending to the new segment = %d, want %d", got, want)
+	}
+
+	w = reopenTestWAL(t, w, Config{})
+	if got := w.TotalBytesWritten(); got != want {
+		t.Fatalf("TotalBytesWritten after reopening = %d, want %d", got, want)
+	}
+	want += appendedBytes(t, w, taskCreated("d"))
+	if got := w.TotalBytesWritten(); got != want {
+		t.Fatalf("TotalBytesWritten after appending to the reopened log = %d, want %d", got, want)
+	}
+}
+
+func TestTotalBytesWrittenSurvivesRewrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite func(w *WAL) error
+	}{
+		{"compact", func(w *WAL) error { return w.Compact() }},
+		{"truncate", func(w *WAL) error { return w.Truncate(headerSize) }},
+		{"reset", func(w *WAL) error { return w.Reset() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := openTestWAL(t, Config{})
+			want := appendedBytes(t, w, taskCreated("done"), leaseGranted("done", "l1", "w", 1),
+				taskCompleted("done", "l1"), taskCreated("open"))
+
+			if err := tt.rewrite(w); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if size, _ := w.Size(); uint64(size-headerSize) >= want {
+				t.Fatalf("Size after %s = %d, want the file to shrink", tt.name, size)
+			}
+			if got := w.TotalBytesWritten(); got != want {
+				t.Fatalf("TotalBytesWritten after %s = %d, want %d", tt.name, got, want)
+			}
+
+			want += appendedBytes(t, w, taskCreated("next"))
+			w = reopenTestWAL(t, w, Config{})
+			if got := w.TotalBytesWritten(); got != want {
+				t.Fatalf("TotalBytesWritten after %s and reopening = %d, want %d", tt.name, got, want)
+			}
+		})
+	}
+}
+
+func TestTotalBytesWrittenSkipsRolledBackRecords(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	want := appendedBytes(t, w, taskCreated("a"))
+
+	fillDisk(w, syscall.ENOSPC)
+	if err := w.Append(taskCreated("full")); err == nil {
+		t.Fatal("Append on a full disk succeeded")
+	}
+	if got := w.TotalBytesWritten(); got != want {
+		t.Fatalf("TotalBytesWritten after a failed append = %d, want %d", got, want)
+	}
+}
+
+func TestTotalBytesWrittenEstimatedWithoutManifest(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	want := appendedBytes(t, w, taskCreated("a"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	want += appendedBytes(t, w, taskCreated("b"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The segments still on disk are counted when the manifest is rebuilt
+	if err := os.Remove(manifestPath(w.filePath)); err != nil {
+		t.Fatal(err)
+	}
+	w = openTestWAL(t, Config{FilePath: w.filePath})
+	if got := w.TotalBytesWritten(); got != want {
+		t.Fatalf("TotalBytesWritten with a rebuilt manifest = %d, want %d", got, want)
+	}
+}
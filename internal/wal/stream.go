@@ -0,0 +1,157 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// streamMinBytes is the task payload size from which AppendAt writes
+	// a TaskCreated record straight from the caller's payload instead of
+	// encoding the whole frame into one buffer first
+	streamMinBytes = 1 << 20
+
+	// streamChunkSize is how much of the task payload is hashed and
+	// written at a time
+	streamChunkSize = 64 << 10
+)
+
+// streamable reports whether record can be written by streamLocked to a
+// file with the given header: a large TaskCreated record, in the binary
+// encoding, that is neither compressed nor encrypted, both of which need
+// the whole payload at once
+func (w *WAL) streamable(header fileHeader, record Record) bool {
+	p, ok := record.Payload.(TaskCreatedPayload)
+	return ok && len(p.Payload) >= streamMinBytes &&
+		header.encoding() == EncodingBinary &&
+		!w.shouldCompress(record) && w.aead == nil
+}
+
+// streamFrame is a record frame prepared by encodeStream: everything but
+// the task payload, which is only referenced
+type streamFrame struct {
+	prefix  []byte // length prefix, type byte and sequence number, if any
+	head    []byte // payload fields before the task payload
+	payload []byte
+	tail    []byte // payload fields after the task payload
+	header  fileHeader
+}
+
+// encodeStream prepares a streamable record for streamLocked in a file with
+// the given header. Like encodeRecordWith, it is safe without w.mu
+func (w *WAL) encodeStream(header fileHeader, record Record) (*streamFrame, error) {
+	p := record.Payload.(TaskCreatedPayload)
+
+	frame := &streamFrame{payload: p.Payload, header: header}
+	head := newEncoder(header.version)
+	head.putString(p.TaskID)
+	head.putUint32(uint32(len(p.Payload)))
+	frame.head = head.buf
+	tail := newEncoder(header.version)
+	putTaskCreatedTail(tail, p)
+	frame.tail = tail.buf
+
+	length := recordTypeSize + len(frame.head) + len(frame.payload) + len(frame.tail) + header.checksum.size()
+	if header.sequenced() {
+		length += sequenceSize
+	}
+	if w.maxRecordSize > 0 && length > w.maxRecordSize {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrRecordTooLarge, length, w.maxRecordSize)
+	}
+
+	frame.prefix = make([]byte, lengthPrefixSize, lengthPrefixSize+recordTypeSize+sequenceSize)
+	binary.LittleEndian.PutUint32(frame.prefix, uint32(length))
+	frame.prefix = append(frame.prefix, byte(record.Type))
+	if header.sequenced() {
+		frame.prefix = binary.LittleEndian.AppendUint64(frame.prefix, record.Seq)
+	}
+
+	return frame, nil
+}
+
+// size returns the size of the frame on disk
+func (f *streamFrame) size() int {
+	return len(f.prefix) + len(f.head) + len(f.payload) + len(f.tail) + f.header.checksum.size()
+}
+
+// streamLocked writes a frame prepared by encodeStream to the buffer
+// without ever holding it whole in memory: the task payload is written in
+// chunks from the caller's slice and the checksum is computed as the frame
+// goes out. The record is assigned the next sequence number in sequenced
+// files. Returns the frame size. On error the log is rolled back to the
+// previous record boundary, as in bufferLocked. A frame reaching past
+// Config.crashAt is torn there, as in crashLocked
+// Callers must hold w.mu and have checked the record with
+// checkSequenceLocked
+func (w *WAL) streamLocked(frame *streamFrame) (int, error) {
+	if frame.header.sequenced() {
+		binary.LittleEndian.PutUint64(frame.prefix[lengthPrefixSize+recordTypeSize:], w.nextSeq)
+	}
+
+	// Everything written through out is hashed as well; the length prefix
+	// only under ChecksumScopeFrame
+	sum := frame.header.checksum.digest()
+	var dst io.Writer = w.writer
+	if w.crashDue(frame.size()) {
+		dst = &crashWriter{w: w.writer, n: w.crashAt - w.offset}
+	}
+	out := io.MultiWriter(dst, sum)
+
+	end := w.offset - int64(w.writer.Buffered())
+	err := func() error {
+		covered := frame.prefix
+		if frame.header.checksumScope() != ChecksumScopeFrame {
+			if _, err := dst.Write(frame.prefix[:lengthPrefixSize]); err != nil {
+				return err
+			}
+			covered = frame.prefix[lengthPrefixSize:]
+		}
+		if _, err := out.Write(covered); err != nil {
+			return err
+		}
+		if _, err := out.Write(frame.head); err != nil {
+			return err
+		}
+		for rest := frame.payload; len(rest) > 0; {
+			chunk := rest[:min(len(rest), streamChunkSize)]
+			if _, err := out.Write(chunk); err != nil {
+				return err
+			}
+			rest = rest[len(chunk):]
+		}
+		if _, err := out.Write(frame.tail); err != nil {
+			return err
+		}
+		_, err := dst.Write(appendDigest(nil, sum))
+		return err
+	}()
+	if errors.Is(err, errCrashed) {
+		return 0, w.haltLocked()
+	}
+	if err != nil {
+		return 0, w.rollbackLocked(end, fmt.Errorf("failed to write record: %w", err))
+	}
+
+	size := frame.size()
+	w.offset += int64(size)
+	w.written += uint64(size)
+	w.appended++
+	if frame.header.sequenced() {
+		w.nextSeq++
+	}
+
+	return size, nil
+}
+
+// encodeAppend encodes record for AppendAt in a file with the given header:
+// as a frame when it is small, or prepared for streamLocked otherwise
+func (w *WAL) encodeAppend(header fileHeader, record Record) ([]byte, *streamFrame, error) {
+	if w.streamable(header, record) {
+		frame, err := w.encodeStream(header, record)
+		return nil, frame, err
+	}
+	data, err := w.encodeRecordWith(header, record)
+	return data, nil, err
+}
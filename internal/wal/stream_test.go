@@ -0,0 +1,150 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestStreamedFrameMatchesEncodedFrame(t *testing.T) {
+	record := stampRecord(bigTask("big", streamMinBytes+12345), testTime)
+	for _, checksum := range checksumTypes {
+		for _, scope := range []ChecksumScope{ChecksumScopePayload, ChecksumScopeFrame} {
+			for _, sequenced := range []bool{false, true} {
+				name := fmt.Sprintf("checksum %d scope %d sequenced %t", checksum, scope, sequenced)
+				w := openTestWAL(t, Config{Checksum: checksum, ChecksumScope: scope, SequenceNumbers: sequenced})
+				mustAppend(t, w, taskCreated("first"))
+				start := w.Offset()
+
+				if !w.streamable(w.header, record) {
+					t.Fatalf("%s: record is not streamed", name)
+				}
+				mustAppend(t, w, record)
+
+				// The streamed frame is byte for byte what the encoder makes
+				want := record
+				want.Seq = 2
+				encoded, err := w.encodeRecordWith(w.header, want)
+				if err != nil {
+					t.Fatalf("%s: encodeRecordWith: %v", name, err)
+				}
+				data, err := os.ReadFile(w.filePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data[start:w.Offset()], encoded) {
+					t.Fatalf("%s: streamed frame differs from the encoded one", name)
+				}
+			}
+		}
+	}
+}
+
+func TestStreamedRecordRoundTrip(t *testing.T) {
+	record := bigTask("big", 3*streamMinBytes+7)
+	w := openTestWAL(t, Config{SequenceNumbers: true})
+	mustAppend(t, w, taskCreated("before"), record, taskCreated("after"))
+
+	w = reopenTestWAL(t, w, Config{})
+	records := mustReplay(t, w)
+	if got, want := taskIDs(records), []string{"before", "big", "after"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+	if got := records[1].Payload.(TaskCreatedPayload).Payload; !bytes.Equal(got, record.Payload.(TaskCreatedPayload).Payload) {
+		t.Fatal("streamed task payload differs after replay")
+	}
+	if got, want := seqs(records), []uint64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sequence numbers = %v, want %v", got, want)
+	}
+	if report, err := Verify(w.filePath); err != nil || !report.Healthy() || report.ValidRecords != 3 {
+		t.Fatalf("Verify = %+v, %v", report, err)
+	}
+}
+
+func TestStreamableOnlyForPlainBinaryRecords(t *testing.T) {
+	big := bigTask("big", streamMinBytes)
+	tests := []struct {
+		name   string
+		config Config
+		record Record
+		want   bool
+	}{
+		{"large binary", Config{}, big, true},
+		{"below threshold", Config{}, bigTask("small", streamMinBytes-1), false},
+		{"not a task", Config{}, annotation("note"), false},
+		{"json", Config{Encoding: EncodingJSON}, big, false},
+		{"compressed", Config{CompressPayloads: true}, big, false},
+		{"encrypted", Config{EncryptionKey: testKey(1)}, big, false},
+	}
+	for _, tt := range tests {
+		w := openTestWAL(t, tt.config)
+		if got := w.streamable(w.header, tt.record); got != tt.want {
+			t.Errorf("%s: streamable = %t, want %t", tt.name, got, tt.want)
+		}
+		// Either way the record round-trips
+		mustAppend(t, w, tt.record)
+		if got := mustReplay(t, w); len(got) != 1 || got[0].Type != tt.record.Type {
+			t.Errorf("%s: replayed %v", tt.name, recordTypes(got))
+		}
+	}
+}
+
+// heapAllocated returns how many bytes f allocates on the heap
+func heapAllocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestStreamedAppendDoesNotCopyPayload(t *testing.T) {
+	const size = 8 << 20
+	record := bigTask("big", size)
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	mustAppend(t, w, taskCreated("warm-up"))
+
+	allocated := heapAllocated(func() { mustAppend(t, w, record) })
+	if allocated >= size/8 {
+		t.Fatalf("streamed append of %d bytes allocated %d bytes", size, allocated)
+	}
+}
+
+func BenchmarkAppendLargePayload(b *testing.B) {
+	record := bigTask("big", 4<<20)
+	for _, streamed := range []bool{false, true} {
+		name := "buffered"
+		if streamed {
+			name = "streamed"
+		}
+		b.Run(name, func(b *testing.B) {
+			w := openTestWAL(b, Config{SyncBatchSize: 1 << 20})
+			b.ReportAllocs()
+			b.SetBytes(4 << 20)
+			for i := 0; i < b.N; i++ {
+				if streamed {
+					if err := w.Append(record); err != nil {
+						b.Fatalf("Append: %v", err)
+					}
+					continue
+				}
+
+				// The whole frame is encoded before it is written, as for
+				// records that are not streamed
+				data, err := w.encodeRecordWith(w.header, stampRecord(record, testTime))
+				if err != nil {
+					b.Fatalf("encodeRecordWith: %v", err)
+				}
+				w.mu.Lock()
+				err = w.bufferLocked(data, 1)
+				w.mu.Unlock()
+				if err != nil {
+					b.Fatalf("bufferLocked: %v", err)
+				}
+			}
+		})
+	}
+}
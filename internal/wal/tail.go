@@ -0,0 +1,51 @@
+package wal
+
+import (
+	"context"
+	"time"
+)
+
+// tailPollInterval is how often Tail checks for newly appended records
+const tailPollInterval = 50 * time.Millisecond
+
+// Tail follows the active file like tail -f: it sends every record from
+// offset from (0 for the first record) to out, then keeps polling for
+// newly appended records until ctx is cancelled, returning ctx.Err()
+// An incomplete final record is waited for rather than reported
+// Records are read under the lock but sent without it, so a slow consumer
+// never blocks appends. Tail does not follow rotation or compaction: once
+// the active file is replaced its offset no longer matches and Tail
+// returns an error. out is not closed
+func (w *WAL) Tail(ctx context.Context, from int64, out chan<- Record) error {
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	offset := from
+	for {
+		var records []Record
+		w.mu.Lock()
+		next, err := w.replayFromLocked(ctx, offset, applyOnly(func(record Record) error {
+			records = append(records, record)
+			return nil
+		}))
+		w.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		offset = next
+
+		for _, record := range records {
+			select {
+			case out <- record:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTailFollowsAppends(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	mustAppend(t, w, taskCreated("t0"))
+	from := w.Offset()
+	mustAppend(t, w, taskCreated("t1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan Record)
+	done := make(chan error, 1)
+	go func() { done <- w.Tail(ctx, from, out) }()
+
+	const n = 10
+	go func() {
+		for i := 2; i < n; i++ {
+			if err := w.Append(taskCreated(fmt.Sprintf("t%d", i))); err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// Records from the starting offset arrive in order, old and new alike
+	for i := 1; i < n; i++ {
+		select {
+		case record := <-out:
+			if got, want := record.Payload.(TaskCreatedPayload).TaskID, fmt.Sprintf("t%d", i); got != want {
+				t.Fatalf("tailed task %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for record %d", i)
+		}
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Tail = %v, want context.Canceled", err)
+	}
+}
+
+func TestTailStopsOnCancelWithSlowConsumer(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan Record) // never read
+	done := make(chan error, 1)
+	go func() { done <- w.Tail(ctx, 0, out) }()
+
+	// Appends are not blocked by the stalled consumer
+	mustAppend(t, w, taskCreated("t3"))
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Tail = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tail did not return after cancellation")
+	}
+}
@@ -0,0 +1,113 @@
+package wal
+
+import (
+	"fmt"
+	"time"
+)
+
+// lockPollMax bounds the backoff between attempts to take the lock
+const lockPollMax = 5 * time.Millisecond
+
+// AppendWithTimeout is Append that gives up with ErrTimeout if the record
+// is not durable within d, so latency-sensitive callers can shed load
+// instead of stalling on a busy lock or a slow fsync
+// If the lock cannot be taken in time nothing is written. If the fsync is
+// what runs late, the record is already in the log and the sync completes
+// in the background; the error then says so. Rotation, if due, is left to
+// the next append
+func (w *WAL) AppendWithTimeout(record Record, d time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(d)
+
+	record = stampRecord(record, w.clock())
+	if err := w.validateRecord(record); err != nil {
+		return err
+	}
+
+	if !w.lockBefore(deadline) {
+		return fmt.Errorf("%w: record not appended", ErrTimeout)
+	}
+	header, err := w.header, w.writableLocked()
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	data, err := w.encodeRecordWith(header, record)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	if !w.lockBefore(deadline) {
+		return fmt.Errorf("%w: record not appended", ErrTimeout)
+	}
+	err = w.bufferRecordLocked(header, data, record)
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	synced := make(chan error, 1)
+	go func() {
+		synced <- w.Sync()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case err := <-synced:
+		if err == nil {
+			w.appendLatency.observe(time.Since(start))
+		}
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w: record appended but not yet durable", ErrTimeout)
+	}
+}
+
+// bufferRecordLocked writes a record encoded for header to the buffer, as
+// AppendAt does but without applying the sync policy
+// Callers must hold w.mu
+func (w *WAL) bufferRecordLocked(header fileHeader, data []byte, record Record) error {
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+	if w.header != header {
+		// The active file was replaced by one with a different framing
+		var err error
+		if data, err = w.encodeRecord(record); err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+	}
+	if err := w.checkSequenceLocked([]Record{record}); err != nil {
+		return err
+	}
+	if err := w.enforceLocked([]Record{record}); err != nil {
+		return err
+	}
+	w.sequenceLocked(data, []int{len(data)})
+
+	if err := w.bufferLocked(data, 1); err != nil {
+		return err
+	}
+	w.observer.RecordAppended(record.Type, len(data))
+	w.trackRequestID(record)
+
+	return nil
+}
+
+// lockBefore takes w.mu, giving up once deadline passes
+// sync.Mutex cannot be waited on with a timeout, so TryLock is polled with
+// exponential backoff. Returns whether the lock is held
+func (w *WAL) lockBefore(deadline time.Time) bool {
+	backoff := 50 * time.Microsecond
+	for !w.mu.TryLock() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		time.Sleep(min(backoff, remaining))
+		backoff = min(2*backoff, lockPollMax)
+	}
+
+	return true
+}
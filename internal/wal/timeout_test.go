@@ -0,0 +1,54 @@
+package wal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendWithTimeoutSlowSync(t *testing.T) {
+	syncer := &countingSyncer{delay: 200 * time.Millisecond}
+	w := openTestWAL(t, Config{syncer: syncer})
+
+	err := w.AppendWithTimeout(taskCreated("a"), 20*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "not yet durable") {
+		t.Fatalf("AppendWithTimeout = %v, want ErrTimeout after the append", err)
+	}
+
+	// The record is in the log and the sync completes in the background
+	if got := len(mustReplay(t, w)); got != 1 {
+		t.Fatalf("replayed %d records, want 1", got)
+	}
+	waitFor(t, "the background sync", func() bool { return w.LastSyncedOffset() == w.Offset() })
+}
+
+func TestAppendWithTimeoutBusyLock(t *testing.T) {
+	w := openTestWAL(t, Config{})
+
+	w.mu.Lock()
+	err := w.AppendWithTimeout(taskCreated("a"), 20*time.Millisecond)
+	w.mu.Unlock()
+	if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "not appended") {
+		t.Fatalf("AppendWithTimeout = %v, want ErrTimeout before the append", err)
+	}
+	if got := len(mustReplay(t, w)); got != 0 {
+		t.Fatalf("replayed %d records after a timed out append, want 0", got)
+	}
+}
+
+func TestAppendWithTimeoutInTime(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, syncer: syncer})
+
+	if err := w.AppendWithTimeout(taskCreated("a"), 5*time.Second); err != nil {
+		t.Fatalf("AppendWithTimeout: %v", err)
+	}
+	// Durable on return, whatever the batch size
+	if syncer.count() != 1 || w.LastSyncedOffset() != w.Offset() {
+		t.Fatalf("%d fsyncs, synced to %d of %d", syncer.count(), w.LastSyncedOffset(), w.Offset())
+	}
+	if err := w.AppendWithTimeout(taskCompleted("a", ""), time.Second); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("AppendWithTimeout of an invalid record = %v, want ErrInvalidRecord", err)
+	}
+}
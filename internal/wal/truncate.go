@@ -0,0 +1,215 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errFound stops a scan early once the target offset has been seen
+var errFound = errors.New("found")
+
+// Truncate discards every record of the active file at or after offset, which
+// must be a record boundary as returned by Offset, AppendAt or ReplayFrom,
+// or the Offset of a ReplayError, to cut the log just before a bad record
+// Offset 0 discards all records. Sealed segments are not affected
+func (w *WAL) Truncate(offset int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+
+	if offset == 0 {
+		offset = headerSize
+	}
+	if offset < headerSize || offset > w.offset {
+		return fmt.Errorf("%w: offset %d outside log of %d bytes", ErrCorruptedLog, offset, w.offset)
+	}
+
+	// Buffered records must reach the file before it is cut
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+
+	if err := w.proveBoundaryLocked(offset); err != nil {
+		return err
+	}
+
+	if err := w.file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+	w.offset = offset
+	w.syncedOffset = min(w.syncedOffset, offset)
+	w.decodeCache.clear()
+	if err := w.preallocateLocked(); err != nil {
+		return err
+	}
+
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+	if err := w.saveManifestLocked(); err != nil {
+		return err
+	}
+	if w.header.sequenced() {
+		last, err := w.lastSeqLocked()
+		if err != nil {
+			return err
+		}
+		w.nextSeq = last + 1
+	}
+	if w.requestIDs != nil {
+		if err := w.loadRequestIDsLocked(); err != nil {
+			return err
+		}
+	}
+	if w.state != nil {
+		return w.loadStateLocked()
+	}
+
+	return nil
+}
+
+// Repair trims a torn final record left by an unclean shutdown, so the
+// next append starts on a record boundary instead of after the torn bytes
+// Returns the new end of the log. A clean log is left untouched and its
+// current size returned; corruption before the final record is reported
+// rather than trimmed
+func (w *WAL) Repair() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waitSyncLocked()
+	if err := w.writableLocked(); err != nil {
+		return 0, err
+	}
+
+	return w.repairLocked()
+}
+
+// repairLocked is Repair without locking
+// Callers must hold w.mu
+func (w *WAL) repairLocked() (int64, error) {
+	end, err := w.replayFromLocked(context.Background(), 0, func(Record, int64, int64) error {
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if end == w.offset {
+		return end, nil
+	}
+
+	if err := w.file.Truncate(end); err != nil {
+		return 0, fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+	w.written -= uint64(w.offset - end)
+	w.offset = end
+	w.syncedOffset = min(w.syncedOffset, end)
+	w.decodeCache.clear()
+	if err := w.preallocateLocked(); err != nil {
+		return 0, err
+	}
+	if err := w.syncLocked(); err != nil {
+		return 0, err
+	}
+
+	return end, nil
+}
+
+// proveBoundaryLocked walks the records of the active file to prove offset
+// is a record boundary rather than trusting whatever bytes happen to be there
+// The write buffer must have been flushed by the caller
+// Callers must hold w.mu
+func (w *WAL) proveBoundaryLocked(offset int64) error {
+	end, err := w.replayFromLocked(context.Background(), 0, func(_ Record, recordOffset, _ int64) error {
+		if recordOffset >= offset {
+			return errFound
+		}
+		return nil
+	})
+	var replayErr *ReplayError
+	switch {
+	case err == nil, errors.Is(err, errFound):
+	case errors.As(err, &replayErr) && replayErr.Offset == offset:
+		// Every record before offset is valid, so a bad record starts there
+		return nil
+	default:
+		return err
+	}
+	// The scan stops at the first record at or past offset, or at the end of
+	// the valid records; either way it lands exactly on offset only if
+	// offset is a boundary
+	if end != offset {
+		return fmt.Errorf("%w: offset %d is not a record boundary", ErrCorruptedLog, offset)
+	}
+
+	return nil
+}
+
+// Reset discards the whole log, sealed segments included, and starts over
+// with an empty active file and a fresh header, as if it had just been
+// created. Unlike Truncate(0) it also clears derived in-memory state: the
+// RequestID set and the sync counters. Archived segments are kept
+func (w *WAL) Reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waitSyncLocked()
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return err
+	}
+	for _, path := range segments {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove segment: %w", err)
+		}
+	}
+
+	// Buffered records are discarded, not written
+	w.written -= uint64(w.writer.Buffered())
+	w.writer.Reset(w.file)
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+	header := w.newHeader()
+	if err := writeHeader(w.file, header); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(w.filePath)); err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+	if err := w.preallocateLocked(); err != nil {
+		return err
+	}
+
+	w.header = header
+	w.offset = headerSize
+	w.appended = 0
+	w.synced = 0
+	w.syncedOffset = headerSize
+	w.decodeCache.clear()
+	w.nextSeq = 1
+	err = w.updateManifestLocked(func(m *manifest) {
+		m.Files = []manifestEntry{{Seq: w.nextSegment}}
+	})
+	if err != nil {
+		return err
+	}
+	if w.requestIDs != nil {
+		w.requestIDs = make(map[string]struct{})
+	}
+	if w.state != nil {
+		w.state = NewState()
+	}
+
+	return nil
+}
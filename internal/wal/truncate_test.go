@@ -0,0 +1,304 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// appendTasksAt appends TaskCreated records for tasks t0 to t(n-1) and
+// returns the offset of each
+func appendTasksAt(t testing.TB, w *WAL, n int) []int64 {
+	t.Helper()
+	offsets := make([]int64, n)
+	for i := range offsets {
+		offset, err := w.AppendAt(taskCreated(fmt.Sprintf("t%d", i)))
+		if err != nil {
+			t.Fatalf("AppendAt: %v", err)
+		}
+		offsets[i] = offset
+	}
+	return offsets
+}
+
+func TestTruncateToRecordBoundary(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	offsets := appendTasksAt(t, w, 5)
+
+	if err := w.Truncate(offsets[2]); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := len(mustReplay(t, w)); got != 2 {
+		t.Fatalf("replayed %d records after truncation, want 2", got)
+	}
+	if w.Offset() != offsets[2] {
+		t.Fatalf("Offset = %d, want %d", w.Offset(), offsets[2])
+	}
+
+	// Appends continue at the cut, and it survives reopening
+	mustAppend(t, w, taskCreated("after"))
+	w = reopenTestWAL(t, w, Config{})
+	records := mustReplay(t, w)
+	if len(records) != 3 || records[2].Payload.(TaskCreatedPayload).TaskID != "after" {
+		t.Fatalf("replayed %+v, want t0, t1, after", records)
+	}
+}
+
+func TestTruncateToZero(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+
+	if err := w.Truncate(0); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := len(mustReplay(t, w)); got != 0 {
+		t.Fatalf("replayed %d records, want 0", got)
+	}
+}
+
+func TestTruncateRejectsBadOffsets(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 3)
+	end := w.Offset()
+
+	for _, offset := range []int64{end + 1, offsets[1] + 1, offsets[2] - 1, 3} {
+		if err := w.Truncate(offset); !errors.Is(err, ErrCorruptedLog) {
+			t.Errorf("Truncate(%d) = %v, want ErrCorruptedLog", offset, err)
+		}
+	}
+	if w.Offset() != end || len(mustReplay(t, w)) != 3 {
+		t.Fatal("a rejected Truncate changed the log")
+	}
+}
+
+func TestResetStartsOver(t *testing.T) {
+	config := Config{TrackRequestIDs: true, EnforceLifecycle: true, MaxSegmentBytes: 256}
+	w := openTestWAL(t, config)
+	for i := 0; i < 10; i++ {
+		mustAppend(t, w, createdWithRequestID(fmt.Sprintf("t%d", i), fmt.Sprintf("req-%d", i)))
+	}
+	segments, err := w.sealedSegments()
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("sealedSegments = %v, %v, want some", segments, err)
+	}
+
+	if err := w.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if got := len(mustReplay(t, w)); got != 0 {
+		t.Fatalf("replayed %d records after Reset, want 0", got)
+	}
+	if segments, _ := w.sealedSegments(); len(segments) != 0 {
+		t.Fatalf("%d sealed segments left after Reset", len(segments))
+	}
+	if hasRequestID(t, w, "req-0") || len(w.requestIDs) != 0 {
+		t.Fatalf("request IDs left after Reset: %v", w.requestIDs)
+	}
+	if len(w.state.Tasks) != 0 {
+		t.Fatalf("tasks left after Reset: %v", w.state.Tasks)
+	}
+	if w.Offset() != headerSize || w.LastSyncedOffset() != headerSize || w.appended != 0 {
+		t.Fatalf("offset %d, synced %d, appended %d after Reset", w.Offset(), w.LastSyncedOffset(), w.appended)
+	}
+
+	// The same task can be created again, and the log reopens cleanly
+	mustAppend(t, w, createdWithRequestID("t0", "req-0"))
+	w = reopenTestWAL(t, w, config)
+	records := mustReplay(t, w)
+	if len(records) != 1 || !hasRequestID(t, w, "req-0") {
+		t.Fatalf("reopened log has %d records, want the one appended after Reset", len(records))
+	}
+}
+
+func TestResetDiscardsBufferedRecords(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	mustAppend(t, w, taskCreated("a"))
+
+	if err := w.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := len(mustReplay(t, w)); got != 0 {
+		t.Fatalf("replayed %d records, want the buffered one discarded", got)
+	}
+}
+
+func TestResetReadOnly(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	r := openTestWAL(t, Config{FilePath: w.filePath, ReadOnly: true})
+	if err := r.Reset(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Reset = %v, want ErrReadOnly", err)
+	}
+}
+
+// appendGarbage appends data to the log file at path, as a torn write would
+func appendGarbage(t *testing.T, path string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepairTrimsPartialFrame(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	end := w.Offset()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	frame, err := EncodeRecord(taskCreated("torn"))
+	if err != nil {
+		t.Fatalf("EncodeRecord: %v", err)
+	}
+	appendGarbage(t, w.filePath, frame[:len(frame)/2])
+
+	w = openTestWAL(t, Config{FilePath: w.filePath})
+	repaired, err := w.Repair()
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if repaired != end || w.Offset() != end {
+		t.Fatalf("Repair = %d, offset %d, want %d", repaired, w.Offset(), end)
+	}
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != end {
+		t.Fatalf("file size after Repair = %d, want %d", stat.Size(), end)
+	}
+
+	// Appends land on the boundary and every earlier record survives
+	mustAppend(t, w, taskCreated("t3"))
+	if got := fmt.Sprint(taskIDs(mustReplay(t, w))); got != "[t0 t1 t2 t3]" {
+		t.Fatalf("replayed %s, want [t0 t1 t2 t3]", got)
+	}
+
+	// A clean log is left alone
+	clean := w.Offset()
+	if repaired, err := w.Repair(); err != nil || repaired != clean {
+		t.Fatalf("Repair of a clean log = %d, %v, want %d", repaired, err, clean)
+	}
+}
+
+func TestRepairReportsMidLogCorruption(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	flipByte(t, w.filePath, offsets[1]+lengthPrefixSize+recordTypeSize+1)
+	stat, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, Config{FilePath: w.filePath})
+	if _, err := w.Repair(); !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("Repair = %v, want ErrCorruptedLog", err)
+	}
+	after, err := os.Stat(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != stat.Size() {
+		t.Fatalf("Repair trimmed a corrupt log from %d to %d bytes", stat.Size(), after.Size())
+	}
+}
+
+// tornLog writes three tasks and half of a fourth to a closed log and
+// returns its path and the end of the whole records
+func tornLog(t *testing.T) (string, int64) {
+	t.Helper()
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	end := w.Offset()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	frame, err := EncodeRecord(taskCreated("torn"))
+	if err != nil {
+		t.Fatalf("EncodeRecord: %v", err)
+	}
+	appendGarbage(t, w.filePath, frame[:len(frame)/2])
+	return w.filePath, end
+}
+
+func TestRepairOnOpenTrimsTornTail(t *testing.T) {
+	path, end := tornLog(t)
+	torn := fileSize(t, path)
+
+	observer := &fakeObserver{}
+	w := openTestWAL(t, Config{FilePath: path, RepairOnOpen: true, Observer: observer})
+	if w.Offset() != end || fileSize(t, path) != end {
+		t.Fatalf("offset %d, file size %d after repairing open, want %d", w.Offset(), fileSize(t, path), end)
+	}
+	if got := fmt.Sprint(observer.repaired); got != fmt.Sprint([]int64{torn - end}) {
+		t.Fatalf("TailRepaired calls = %s, want [%d]", got, torn-end)
+	}
+
+	mustAppend(t, w, taskCreated("t3"))
+	w = reopenTestWAL(t, w, Config{})
+	if got := fmt.Sprint(taskIDs(mustReplay(t, w))); got != "[t0 t1 t2 t3]" {
+		t.Fatalf("replayed %s, want [t0 t1 t2 t3]", got)
+	}
+}
+
+func TestRepairOnOpenCleanLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	appendTasks(t, w, 3)
+	end := w.Offset()
+
+	observer := &fakeObserver{}
+	w = reopenTestWAL(t, w, Config{RepairOnOpen: true, Observer: observer})
+	if w.Offset() != end || len(observer.repaired) != 0 {
+		t.Fatalf("repairing open of a clean log: offset %d, TailRepaired %v", w.Offset(), observer.repaired)
+	}
+}
+
+func TestRepairOnOpenDisabledOrReadOnly(t *testing.T) {
+	path, _ := tornLog(t)
+	torn := fileSize(t, path)
+
+	for _, config := range []Config{
+		{FilePath: path},
+		{FilePath: path, RepairOnOpen: true, ReadOnly: true},
+	} {
+		observer := &fakeObserver{}
+		config.Observer = observer
+		w := openTestWAL(t, config)
+		if size := fileSize(t, path); size != torn || len(observer.repaired) != 0 {
+			t.Fatalf("Open with %+v trimmed the log to %d bytes, TailRepaired %v", config, size, observer.repaired)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+}
+
+func TestRepairOnOpenRejectsMidLogCorruption(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, 3)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	flipByte(t, w.filePath, offsets[1]+lengthPrefixSize+recordTypeSize+1)
+	size := fileSize(t, w.filePath)
+
+	if _, err := Open(Config{FilePath: w.filePath, RepairOnOpen: true}); !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("repairing Open of a corrupt log = %v, want ErrCorruptedLog", err)
+	}
+	if after := fileSize(t, w.filePath); after != size {
+		t.Fatalf("repairing Open trimmed a corrupt log from %d to %d bytes", size, after)
+	}
+	// The lock is released, so the log can be opened to be dealt with
+	openTestWAL(t, Config{FilePath: w.filePath})
+}
@@ -0,0 +1,220 @@
+package wal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRecord(t *testing.T) {
+	valid := make(map[RecordType]Record)
+	for _, record := range sampleRecords() {
+		valid[record.Type] = record
+	}
+
+	// with returns the valid record of type rt with its payload changed
+	with := func(rt RecordType, change func(p interface{}) interface{}) Record {
+		record := valid[rt]
+		record.Payload = change(record.Payload)
+		return record
+	}
+
+	tests := []struct {
+		name   string
+		record Record
+		field  string // named in the error, "" if the record is valid
+	}{
+		{"TaskCreated valid", valid[RecordTypeTaskCreated], ""},
+		{"TaskCreated without TaskID", with(RecordTypeTaskCreated, func(p interface{}) interface{} {
+			c := p.(TaskCreatedPayload)
+			c.TaskID = ""
+			return c
+		}), "TaskID"},
+		{"TaskCreated without Payload", with(RecordTypeTaskCreated, func(p interface{}) interface{} {
+			c := p.(TaskCreatedPayload)
+			c.Payload = nil
+			return c
+		}), "Payload"},
+		{"TaskCreated with zero ExecutionWindow", with(RecordTypeTaskCreated, func(p interface{}) interface{} {
+			c := p.(TaskCreatedPayload)
+			c.ExecutionWindow = 0
+			return c
+		}), "ExecutionWindow"},
+		{"TaskCreated with negative MaxRetries", with(RecordTypeTaskCreated, func(p interface{}) interface{} {
+			c := p.(TaskCreatedPayload)
+			c.RetryPolicy.MaxRetries = -1
+			return c
+		}), "MaxRetries"},
+
+		{"TaskCompleted valid", valid[RecordTypeTaskCompleted], ""},
+		{"TaskCompleted without LeaseID", taskCompleted("t", ""), "LeaseID"},
+		{"TaskCompleted without TaskID", taskCompleted("", "l"), "TaskID"},
+
+		{"TaskFailed valid", valid[RecordTypeTaskFailed], ""},
+		{"TaskFailed without LeaseID", taskFailed("t", "", "r"), "LeaseID"},
+
+		{"TaskCancelled valid", valid[RecordTypeTaskCancelled], ""},
+		{"TaskCancelled without TaskID", taskCancelled("", "l"), "TaskID"},
+
+		{"LeaseGranted valid", valid[RecordTypeLeaseGranted], ""},
+		{"LeaseGranted without WorkerID", leaseGranted("t", "l", "", 1), "WorkerID"},
+		{"LeaseGranted without LeaseID", leaseGranted("t", "", "w", 1), "LeaseID"},
+		{"LeaseGranted with negative Attempt", leaseGranted("t", "l", "w", -1), "Attempt"},
+		{"LeaseGranted without LeaseExpiry", with(RecordTypeLeaseGranted, func(p interface{}) interface{} {
+			g := p.(LeaseGrantedPayload)
+			g.LeaseExpiry = time.Time{}
+			return g
+		}), "LeaseExpiry"},
+
+		{"LeaseExtended valid", valid[RecordTypeLeaseExtended], ""},
+		{"LeaseExtended without LeaseID", leaseExtended("", testTime), "LeaseID"},
+		{"LeaseExtended without NewLeaseExpiry", leaseExtended("l", time.Time{}), "NewLeaseExpiry"},
+
+		{"LeaseExpired valid", valid[RecordTypeLeaseExpired], ""},
+		{"LeaseExpired without LeaseID", leaseExpired("t", ""), "LeaseID"},
+
+		{"TaskDead valid", valid[RecordTypeTaskDead], ""},
+		{"TaskDead without TaskID", taskDead("", "r"), "TaskID"},
+
+		{"TaskRetried valid", valid[RecordTypeTaskRetried], ""},
+		{"TaskRetried without PreviousLeaseID", taskRetried("t", "", 2), "LeaseID"},
+		{"TaskRetried with zero Attempt", taskRetried("t", "l", 0), "Attempt"},
+
+		{"TaskRescheduled valid", valid[RecordTypeTaskRescheduled], ""},
+		{"TaskRescheduled without NextAttemptAt", with(RecordTypeTaskRescheduled, func(p interface{}) interface{} {
+			r := p.(TaskRescheduledPayload)
+			r.NextAttemptAt = time.Time{}
+			return r
+		}), "NextAttemptAt"},
+		{"TaskRescheduled with negative Backoff", with(RecordTypeTaskRescheduled, func(p interface{}) interface{} {
+			r := p.(TaskRescheduledPayload)
+			r.Backoff = -time.Second
+			return r
+		}), "Backoff"},
+
+		{"Checkpoint valid", valid[RecordTypeCheckpoint], ""},
+		{"Checkpoint with negative Offset", checkpoint(-1), "Offset"},
+
+		{"LeaseRenewalDenied valid", valid[RecordTypeLeaseRenewalDenied], ""},
+		{"LeaseRenewalDenied without TaskID", with(RecordTypeLeaseRenewalDenied, func(p interface{}) interface{} {
+			d := p.(LeaseRenewalDeniedPayload)
+			d.TaskID = ""
+			return d
+		}), "TaskID"},
+
+		{"Annotation valid", valid[RecordTypeAnnotation], ""},
+		{"Annotation without Text", annotation(""), "Text"},
+
+		{"payload of another type", Record{Type: RecordTypeTaskDead, Payload: TaskCompletedPayload{TaskID: "t", LeaseID: "l"}}, "TaskCompletedPayload"},
+		{"unknown type", Record{Type: 200, Payload: struct{}{}}, "unknown record type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRecord(tt.record)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("ValidateRecord = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidRecord) {
+				t.Fatalf("ValidateRecord = %v, want ErrInvalidRecord", err)
+			}
+			if !strings.Contains(err.Error(), tt.field) {
+				t.Fatalf("ValidateRecord = %v, want it to name %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestAppendRejectsInvalidRecord(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	before := w.Offset()
+
+	if err := w.Append(taskCompleted("t", "")); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("Append = %v, want ErrInvalidRecord", err)
+	}
+	if got := w.Offset(); got != before {
+		t.Fatalf("offset moved from %d to %d", before, got)
+	}
+	if got := len(mustReplay(t, w)); got != 0 {
+		t.Fatalf("replayed %d records, want 0", got)
+	}
+}
+
+// taskWithWindow is a TaskCreated record with the given execution window
+func taskWithWindow(taskID string, window time.Duration) Record {
+	record := taskCreated(taskID)
+	p := record.Payload.(TaskCreatedPayload)
+	p.ExecutionWindow = window
+	record.Payload = p
+	return record
+}
+
+func TestAppendBoundsExecutionWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		bound  time.Duration // Config.MaxExecutionWindow
+		window time.Duration
+		ok     bool
+	}{
+		{"default bound", 0, DefaultMaxExecutionWindow, true},
+		{"over default bound", 0, DefaultMaxExecutionWindow + time.Nanosecond, false},
+		{"years long", 0, 5 * 365 * 24 * time.Hour, false},
+		{"negative bound uses default", -time.Hour, 24 * time.Hour, true},
+		{"custom bound", time.Hour, time.Hour, true},
+		{"over custom bound", time.Hour, time.Hour + time.Second, false},
+	}
+
+	appends := map[string]func(w *WAL, record Record) error{
+		"Append": func(w *WAL, record Record) error { return w.Append(record) },
+		"AppendAt": func(w *WAL, record Record) error {
+			_, err := w.AppendAt(record)
+			return err
+		},
+		"AppendBatch": func(w *WAL, record Record) error { return w.AppendBatch([]Record{record}) },
+		"AppendAsync": func(w *WAL, record Record) error { return <-w.AppendAsync(record) },
+		"AppendWithTimeout": func(w *WAL, record Record) error {
+			return w.AppendWithTimeout(record, time.Minute)
+		},
+	}
+
+	for _, tt := range tests {
+		for via, appendRecord := range appends {
+			t.Run(tt.name+"/"+via, func(t *testing.T) {
+				w := openTestWAL(t, Config{MaxExecutionWindow: tt.bound})
+				err := appendRecord(w, taskWithWindow("t", tt.window))
+				if tt.ok {
+					if err != nil {
+						t.Fatalf("%s = %v, want nil", via, err)
+					}
+					return
+				}
+				if !errors.Is(err, ErrInvalidRecord) || !strings.Contains(err.Error(), "ExecutionWindow") {
+					t.Fatalf("%s = %v, want ErrInvalidRecord naming ExecutionWindow", via, err)
+				}
+				if got := len(mustReplay(t, w)); got != 0 {
+					t.Fatalf("replayed %d records after a rejected append", got)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateRecordLeavesWindowUnbounded(t *testing.T) {
+	// The bound is a Config limit, so a log written under a larger one
+	// still validates and replays
+	record := taskWithWindow("t", 2*DefaultMaxExecutionWindow)
+	if err := ValidateRecord(record); err != nil {
+		t.Fatalf("ValidateRecord = %v, want nil", err)
+	}
+
+	w := openTestWAL(t, Config{MaxExecutionWindow: 3 * DefaultMaxExecutionWindow})
+	mustAppend(t, w, record)
+	w = reopenTestWAL(t, w, Config{})
+	if got := mustReplay(t, w); len(got) != 1 || got[0].Payload.(TaskCreatedPayload).ExecutionWindow != 2*DefaultMaxExecutionWindow {
+		t.Fatalf("replayed %v, want the long-window task", recordTypes(got))
+	}
+}
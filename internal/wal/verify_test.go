@@ -0,0 +1,191 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// closedLog writes n tasks to a fresh log, closes it and returns its path,
+// the offset of each record and the file size
+func closedLog(t *testing.T, n int) (string, []int64, int64) {
+	t.Helper()
+	w := openTestWAL(t, Config{})
+	offsets := appendTasksAt(t, w, n)
+	end := w.Offset()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return w.filePath, offsets, end
+}
+
+func TestVerifyHealthyLog(t *testing.T) {
+	path, _, end := closedLog(t, 5)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	want := VerifyReport{ValidRecords: 5, ValidBytes: end, CorruptOffset: -1}
+	if report != want {
+		t.Fatalf("Verify = %+v, want %+v", report, want)
+	}
+	if !report.Healthy() {
+		t.Fatal("healthy log reported unhealthy")
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("Verify modified the file")
+	}
+}
+
+func TestVerifyMidLogCorruption(t *testing.T) {
+	path, offsets, _ := closedLog(t, 5)
+	flipByte(t, path, offsets[2]+lengthPrefixSize+recordTypeSize+1)
+
+	report, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if report.ValidRecords != 2 || report.ValidBytes != offsets[2] || report.CorruptOffset != offsets[2] {
+		t.Fatalf("Verify = %+v, want corruption at record 2, offset %d", report, offsets[2])
+	}
+	if report.CorruptReason == "" || report.TornTail || report.Healthy() {
+		t.Fatalf("Verify = %+v", report)
+	}
+}
+
+func TestVerifyTornTail(t *testing.T) {
+	path, offsets, end := closedLog(t, 3)
+	if err := os.Truncate(path, end-2); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	want := VerifyReport{ValidRecords: 2, ValidBytes: offsets[2], CorruptOffset: -1, TornTail: true}
+	if report != want {
+		t.Fatalf("Verify = %+v, want %+v", report, want)
+	}
+	if !report.Healthy() {
+		t.Fatal("torn tail reported unhealthy")
+	}
+}
+
+func TestVerifyRejectsNonLog(t *testing.T) {
+	path := testPath(t)
+	if err := os.WriteFile(path, []byte("plain text"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(path); !errors.Is(err, ErrCorruptedLog) {
+		t.Fatalf("Verify = %v, want ErrCorruptedLog", err)
+	}
+}
+
+func TestVerifyReaderMatchesVerify(t *testing.T) {
+	path, offsets, _ := closedLog(t, 5)
+	flipByte(t, path, offsets[3]+lengthPrefixSize+recordTypeSize+1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	got, err := VerifyReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("VerifyReader: %v", err)
+	}
+	if got != want {
+		t.Fatalf("VerifyReader = %+v, Verify = %+v", got, want)
+	}
+}
+
+// largeLog writes n tasks of size payload bytes each under config to a
+// fresh log, closes it and returns its path
+func largeLog(t testing.TB, config Config, n, size int) string {
+	t.Helper()
+	config.SyncBatchSize = n
+	w := openTestWAL(t, config)
+	for i := 0; i < n; i++ {
+		mustAppend(t, w, bigTask(fmt.Sprintf("big%d", i), size))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return w.filePath
+}
+
+func TestVerifyMemoryBoundedByChunk(t *testing.T) {
+	const size = 8 << 20
+	path := largeLog(t, Config{}, 4, size)
+
+	var report VerifyReport
+	var err error
+	allocated := heapAllocated(func() { report, err = Verify(path) })
+	if err != nil || !report.Healthy() || report.ValidRecords != 4 {
+		t.Fatalf("Verify = %+v, %v", report, err)
+	}
+	// The chunk buffer and the file reader, not the 32 MiB of payloads
+	if allocated > 4*verifyBufferSize+64<<10 {
+		t.Fatalf("Verify of %d records of %d bytes allocated %d bytes", 4, size, allocated)
+	}
+
+	// Decoding loads each record whole
+	allocated = heapAllocated(func() { report, err = VerifyDecode(path) })
+	if err != nil || !report.Healthy() || allocated < size {
+		t.Fatalf("VerifyDecode = %+v, %v after allocating %d bytes", report, err, allocated)
+	}
+}
+
+func TestVerifyStreamsEveryChecksum(t *testing.T) {
+	for _, checksum := range checksumTypes {
+		for _, scope := range []ChecksumScope{ChecksumScopePayload, ChecksumScopeFrame} {
+			config := Config{Checksum: checksum, ChecksumScope: scope, SequenceNumbers: true}
+			path := largeLog(t, config, 2, 3*verifyBufferSize+17)
+			if report, err := Verify(path); err != nil || !report.Healthy() || report.ValidRecords != 2 {
+				t.Fatalf("checksum %d scope %d: Verify = %+v, %v", checksum, scope, report, err)
+			}
+
+			// A flipped byte deep in the first payload is found
+			flipByte(t, path, headerSize+2*verifyBufferSize)
+			report, err := Verify(path)
+			if err != nil || report.CorruptOffset != headerSize || report.ValidRecords != 0 {
+				t.Fatalf("checksum %d scope %d: Verify of a corrupt payload = %+v, %v", checksum, scope, report, err)
+			}
+		}
+	}
+}
+
+func BenchmarkVerifyLargePayloads(b *testing.B) {
+	path := largeLog(b, Config{}, 8, 4<<20)
+	for _, decode := range []bool{false, true} {
+		name := "stream"
+		if decode {
+			name = "decode"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(8 * 4 << 20)
+			for i := 0; i < b.N; i++ {
+				if _, err := verifyPath(path, decode); err != nil {
+					b.Fatalf("verifyPath: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package wal
 
 import (
+	"bufio"
+	"context"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -22,12 +25,30 @@ const (
 	RecordTypeLeaseExtended
 	RecordTypeLeaseExpired
 	RecordTypeTaskDead
+	RecordTypeTaskRetried
+	RecordTypeCheckpoint
+	RecordTypeLeaseRenewalDenied
+	RecordTypeAnnotation
+	RecordTypeTaskRescheduled
+)
+
+// Frame layout sizes, see encodeRecord
+// The checksum width depends on the ChecksumType
+const (
+	lengthPrefixSize = 4
+	recordTypeSize   = 1
+	sequenceSize     = 8 // only in files with sequence numbers
 )
 
 // Record represents a WAL entry with its type and payload
 type Record struct {
 	Type    RecordType
 	Payload interface{}
+
+	// Seq is the record's sequence number in logs written with
+	// Config.SequenceNumbers, 0 otherwise. Append assigns the next number
+	// when it is 0; a caller-supplied Seq must equal that number
+	Seq uint64
 }
 
 // Task Lifecycle Records
@@ -39,7 +60,7 @@ type TaskCreatedPayload struct {
 	ExecutionWindow time.Duration
 	RetryPolicy     RetryPolicy
 	RequestID       string    // optional
-	CreatedAt       time.Time // optional, metadata only
+	CreatedAt       time.Time // metadata only, set on append if zero
 }
 
 // TaskCompletedPayload represents successful task completion
@@ -67,6 +88,25 @@ type TaskDeadPayload struct {
 	Reason string
 }
 
+// TaskRetriedPayload represents the decision to retry a failed task
+// Attempt is the attempt number the retry will run as once leased
+type TaskRetriedPayload struct {
+	TaskID          string
+	PreviousLeaseID string
+	Attempt         int
+	Reason          string
+}
+
+// TaskRescheduledPayload represents the decision to retry a failed task
+// after a backoff. Attempt is the attempt number the retry will run as,
+// not to be leased before NextAttemptAt
+type TaskRescheduledPayload struct {
+	TaskID        string
+	NextAttemptAt time.Time
+	Attempt       int
+	Backoff       time.Duration
+}
+
 // Lease Lifecycle Records
 
 // LeaseGrantedPayload represents granting task ownership
@@ -76,19 +116,49 @@ type LeaseGrantedPayload struct {
 	WorkerID    string
 	Attempt     int
 	LeaseExpiry time.Time
-	GrantedAt   time.Time // optional, metadata only
+	GrantedAt   time.Time // metadata only, set on append if zero
 }
 
 // LeaseExtendedPayload represents lease duration extension
 type LeaseExtendedPayload struct {
 	LeaseID        string
 	NewLeaseExpiry time.Time
+	At             time.Time // set on append if zero; zero in version 1 files
 }
 
 // LeaseExpiredPayload represents explicit lease expiration (optional)
 type LeaseExpiredPayload struct {
 	TaskID  string
 	LeaseID string
+	At      time.Time // set on append if zero; zero in version 1 files
+}
+
+// LeaseRenewalDeniedPayload records a rejected lease extension, for audit
+// It does not change state: the lease keeps its current expiry
+type LeaseRenewalDeniedPayload struct {
+	LeaseID string
+	TaskID  string
+	Reason  string
+	At      time.Time // set on append if zero
+}
+
+// AnnotationPayload is a free-form operator note in the log timeline,
+// e.g. a manual intervention. It does not change state
+type AnnotationPayload struct {
+	Text   string
+	Author string    // optional
+	At     time.Time // set on append if zero
+}
+
+// Recovery Records
+
+// CheckpointPayload marks the point at which external state was persisted
+// Offset is the log offset the persisted state covers, i.e. replay resumes
+// from there; StateHash is State.Hash of the persisted state
+type CheckpointPayload struct {
+	Offset    int64
+	Timestamp time.Time
+	StateHash uint32
 }
 
 // RetryPolicy defines retry behavior for tasks
@@ -100,25 +170,257 @@ type RetryPolicy struct {
 // WAL represents the Write-Ahead Log
 type WAL struct {
 	mu            sync.Mutex
-	file          *os.File
+	file          *os.File      // append-only write handle
+	lockFile      *os.File      // holds the writer lock, nil if read-only
+	readFile      *os.File      // read-only handle used by replay, never seeked
+	writer        *bufio.Writer // buffers appends until flushed to file
 	filePath      string
 	offset        int64
-	syncBatchSize int // configurable batch size for fsync
+	syncBatchSize int          // configurable batch size for fsync
+	appended      uint64       // records written since Open
+	synced        uint64       // value of appended covered by the last fsync
+	syncedOffset  int64        // end of the active file covered by the last fsync
+	written       uint64       // bytes ever appended, see TotalBytesWritten
+	syncing       bool         // a group commit fsync is running without w.mu
+	syncCond      *sync.Cond   // broadcast when a group commit fsync ends
+	syncer        syncer       // fsyncs the active file
+	readOnly      bool         // writes fail with ErrReadOnly
+	observer      Observer     // never nil after Open
+	header        fileHeader   // framing of the active file
+	checksum      ChecksumType // configured algorithm for new files
+	encoding      Encoding     // configured payload encoding for new files
+	checksumScope ChecksumScope
+
+	forceSyncTypes  map[RecordType]bool
+	maxSegmentBytes int64  // 0 means the active file is never rotated
+	nextSegment     int    // sequence number for the next sealed segment
+	maxSegments     int    // 0 means unbounded
+	archiveDir      string // destination for archived segments
+
+	manifest manifest // segments of the log, see manifestSuffix
+
+	strictReplay     bool
+	compressPayloads bool
+	compressMinBytes int
+	aead             cipher.AEAD // payload encryption, nil if disabled
+	maxRecordSize    int
+	maxWindow        time.Duration
+	preallocate      int64 // bytes reserved for each active file, 0 if disabled
+	useMmap          bool
+	decodeCache      *decodeCache // nil unless Config.DecodeCacheSize is set
+
+	sequence       bool   // new files carry sequence numbers
+	strictSequence bool   // replay fails on a sequence gap
+	nextSeq        uint64 // sequence number of the next sequenced record
+
+	requestIDs map[string]struct{} // nil unless Config.TrackRequestIDs
+	state      *State              // nil unless Config.EnforceLifecycle
+
+	// replayTypes limits payload decoding to these types during
+	// ReplayFiltered, nil otherwise
+	replayTypes map[RecordType]bool
+
+	clock func() time.Time // stamps appended records, see Config.Clock
+
+	crashAt     int64 // failpoint offset, see Config.crashAt
+	rotateFault error // see Config.rotateFault
+
+	appendLatency latencyHistogram // updated without w.mu
+	syncLatency   latencyHistogram
+
+	// Background sync, see Config.SyncInterval
+	stopSync    chan struct{} // nil unless SyncInterval is set
+	syncStopped chan struct{}
+	stopOnce    sync.Once
 }
 
 // Config holds WAL configuration
 type Config struct {
 	FilePath      string
 	SyncBatchSize int // number of records before fsync
+
+	// SyncInterval, when set, syncs unsynced records in the background at
+	// this interval, bounding the loss window for records that have not
+	// reached SyncBatchSize. Whichever trigger fires first syncs
+	SyncInterval time.Duration
+
+	// Checksum selects the record checksum algorithm for new files
+	// Existing files keep the algorithm recorded in their header
+	// Defaults to ChecksumCRC32
+	Checksum ChecksumType
+
+	// ChecksumScope selects what the record checksum covers in new files,
+	// ChecksumScopePayload by default. Existing files keep their scope
+	ChecksumScope ChecksumScope
+
+	// Encoding selects the payload encoding for new files, EncodingBinary
+	// by default. Existing files keep the encoding recorded in their header
+	Encoding Encoding
+
+	// WriteBufferSize is the size of the in-memory append buffer
+	// Defaults to DefaultWriteBufferSize
+	WriteBufferSize int
+
+	// ForceSyncTypes lists record types that are fsynced immediately on
+	// append, regardless of the batch counter
+	ForceSyncTypes []RecordType
+
+	// StrictReplay makes a checksum failure fatal even on the final record
+	// A short final record is still discarded as a torn write
+	StrictReplay bool
+
+	// MaxSegmentBytes rotates the active file into a sealed segment once it
+	// grows past this size; 0 disables rotation
+	MaxSegmentBytes int64
+
+	// MaxSegments bounds the number of sealed segments kept next to the
+	// active log; 0 means unbounded. Excess segments are moved to ArchiveDir
+	MaxSegments int
+	ArchiveDir  string
+
+	// CompressPayloads gzips TaskCreated records whose task payload is at
+	// least CompressMinBytes (default DefaultCompressMinBytes)
+	CompressPayloads bool
+	CompressMinBytes int
+
+	// EncryptionKey enables AES-256-GCM encryption of record payloads when
+	// set; it must be EncryptionKeySize bytes. The same key is required to
+	// replay encrypted records
+	EncryptionKey []byte
+
+	// ReadOnly opens an existing log for inspection only: the file is never
+	// created or modified, and every write returns ErrReadOnly
+	// Otherwise Open takes an exclusive advisory lock on <FilePath>.lock,
+	// held until Close, and fails with ErrLocked if another writer has it
+	ReadOnly bool
+
+	// SequenceNumbers numbers the records of new files consecutively across
+	// the whole log, so that lost records show up as gaps, see Record.Seq
+	// Replay reports a gap with ErrSequenceGap when StrictSequence is set;
+	// Stats counts them either way. Compaction leaves gaps by design
+	SequenceNumbers bool
+	StrictSequence  bool
+
+	// MaxRecordSize bounds the encoded size of a record, excluding its
+	// length prefix. Larger appends fail with ErrRecordTooLarge, and a
+	// larger length prefix read back is treated as corruption instead of
+	// being allocated. Defaults to DefaultMaxRecordSize
+	MaxRecordSize int
+
+	// MaxExecutionWindow bounds the ExecutionWindow of appended TaskCreated
+	// records; a longer window likely comes from a unit bug upstream and
+	// fails with ErrInvalidRecord. Defaults to DefaultMaxExecutionWindow
+	MaxExecutionWindow time.Duration
+
+	// PreallocateBytes reserves this much disk space for the active file
+	// when it is opened or replaced, so bursty appends do not have to grow
+	// it block by block. The space is reserved with fallocate without
+	// changing the file size, so the end of the log is still the end of the
+	// file; sealed segments give back what they did not use. Only supported
+	// on Linux, ignored elsewhere and on filesystems without fallocate
+	PreallocateBytes int64
+
+	// RepairOnOpen makes Open trim a torn final record of a writable log,
+	// as Repair does, so the first append lands on a record boundary
+	// The number of bytes trimmed is reported to Observer.TailRepaired
+	RepairOnOpen bool
+
+	// UseMmap makes replay and iteration read log files through a
+	// read-only memory mapping instead of read calls, which is faster on
+	// large logs. The active file is mapped only while the WAL lock is
+	// held, and Iterator maps sealed segments only, since the active file
+	// may be truncated beneath it. Verify takes no Config and always
+	// streams. Ignored on platforms without mmap
+	UseMmap bool
+
+	// DecodeCacheSize, when positive, keeps up to that many records decoded
+	// by Index.ReadAt and Index.ReadByType in a least recently used cache
+	// shared by the indexes of the WAL, so re-reading nearby records, e.g.
+	// in an interactive inspector, skips the disk. Records served from the
+	// cache share their payload bytes. Truncation, rotation and compaction
+	// clear it
+	DecodeCacheSize int
+
+	// TrackRequestIDs keeps the RequestIDs of all created tasks in memory
+	// for HasRequestID. Open then replays the whole log to build the set
+	TrackRequestIDs bool
+
+	// EnforceLifecycle keeps the coordinator State in memory and rejects an
+	// append that ApplyRecord would reject on replay, e.g. completing a task
+	// that is not leased, with ErrInvariantViolation before anything is
+	// written. Open then replays the whole log to build the state, so the
+	// log must hold the full history: after TruncatePrefix the in-memory
+	// state carries on, but reopening fails
+	EnforceLifecycle bool
+
+	// Observer is notified of appends, syncs and replay progress
+	// Defaults to a no-op
+	Observer Observer
+
+	// Clock returns the time Append stamps on records whose CreatedAt,
+	// GrantedAt or At is zero, e.g. a fixed clock for deterministic tests
+	// Defaults to time.Now
+	Clock func() time.Time
+
+	// syncer replaces fsync of the active file, so tests can count syncs
+	// or inject failures. Defaults to fileSyncer
+	syncer syncer
+
+	// crashAt, when positive, makes the append whose records would reach
+	// past this offset of the active file write only up to it and crash,
+	// so tests can produce torn writes deterministically, see crashLocked
+	crashAt int64
+
+	// rotateFault, when set, makes rotation fail with it once the active
+	// file is closed, leaving the WAL closed as a failed rename would
+	rotateFault error
 }
 
+// syncer makes the records written to a file durable
+type syncer interface {
+	Sync(file *os.File) error
+}
+
+// fileSyncer is the default syncer, calling fsync via os.File.Sync
+type fileSyncer struct{}
+
+// Sync fsyncs file
+func (fileSyncer) Sync(file *os.File) error {
+	return file.Sync()
+}
+
+// DefaultMaxRecordSize is the record size limit used when
+// Config.MaxRecordSize is not set
+const DefaultMaxRecordSize = 16 << 20
+
+// DefaultMaxExecutionWindow is the task execution window limit used when
+// Config.MaxExecutionWindow is not set
+const DefaultMaxExecutionWindow = 7 * 24 * time.Hour
+
+// DefaultWriteBufferSize is the append buffer size used when
+// Config.WriteBufferSize is not set
+const DefaultWriteBufferSize = 64 * 1024
+
 // Errors
 var (
-	ErrWALClosed       = errors.New("wal: log is closed")
-	ErrInvalidRecord   = errors.New("wal: invalid record")
-	ErrCorruptedLog    = errors.New("wal: corrupted log file")
-	ErrPartialWrite    = errors.New("wal: partial write detected")
-	ErrInvalidChecksum = errors.New("wal: checksum mismatch")
+	ErrWALClosed          = errors.New("wal: log is closed")
+	ErrInvalidRecord      = errors.New("wal: invalid record")
+	ErrCorruptedLog       = errors.New("wal: corrupted log file")
+	ErrPartialWrite       = errors.New("wal: partial write detected")
+	ErrInvalidChecksum    = errors.New("wal: checksum mismatch")
+	ErrNoArchiveDir       = errors.New("wal: archive directory not configured")
+	ErrSegmentedLog       = errors.New("wal: operation not supported on a log with sealed segments")
+	ErrUnsupportedVersion = errors.New("wal: unsupported format version")
+	ErrNoEncryptionKey    = errors.New("wal: record is encrypted but no key is configured")
+	ErrDecryptionFailed   = errors.New("wal: failed to decrypt record")
+	ErrReadOnly           = errors.New("wal: log is opened read-only")
+
+	ErrRequestIDsNotTracked = errors.New("wal: request IDs are not tracked")
+	ErrLocked               = errors.New("wal: log is opened for writing by another process")
+	ErrRecordTooLarge       = errors.New("wal: record exceeds the maximum record size")
+	ErrSequenceGap          = errors.New("wal: gap in record sequence numbers")
+	ErrTimeout              = errors.New("wal: operation timed out")
+	ErrNotSequenced         = errors.New("wal: sequence numbers are not enabled")
 )
 
 // Open creates or opens a WAL file
@@ -127,210 +429,1408 @@ func Open(config Config) (*WAL, error) {
 	if config.SyncBatchSize <= 0 {
 		config.SyncBatchSize = 1 // default: sync after every write
 	}
+	if config.WriteBufferSize <= 0 {
+		config.WriteBufferSize = DefaultWriteBufferSize
+	}
+	if config.MaxSegments > 0 && config.ArchiveDir == "" {
+		return nil, ErrNoArchiveDir
+	}
+	if config.MaxRecordSize <= 0 {
+		config.MaxRecordSize = DefaultMaxRecordSize
+	}
+	if config.MaxExecutionWindow <= 0 {
+		config.MaxExecutionWindow = DefaultMaxExecutionWindow
+	}
+	if config.CompressMinBytes <= 0 {
+		config.CompressMinBytes = DefaultCompressMinBytes
+	}
+	if config.Checksum == 0 {
+		config.Checksum = ChecksumCRC32
+	}
+	if config.syncer == nil {
+		config.syncer = fileSyncer{}
+	}
+	if config.Observer == nil {
+		config.Observer = noopObserver{}
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+	if !config.Checksum.valid() {
+		return nil, fmt.Errorf("unknown checksum type %d", config.Checksum)
+	}
+	if !config.ChecksumScope.valid() {
+		return nil, fmt.Errorf("unknown checksum scope %d", config.ChecksumScope)
+	}
+	if !config.Encoding.valid() {
+		return nil, fmt.Errorf("unknown encoding %d", config.Encoding)
+	}
 
-	file, err := os.OpenFile(config.FilePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	aead, err := newAEAD(config.EncryptionKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open WAL file: %w", err)
+		return nil, err
 	}
 
-	stat, err := file.Stat()
+	var (
+		file     *os.File
+		lockFile *os.File
+		header   fileHeader
+		size     int64
+	)
+	if config.ReadOnly {
+		if file, err = os.Open(config.FilePath); err != nil {
+			return nil, fmt.Errorf("failed to open WAL file: %w", err)
+		}
+		header, size, err = readFileHeader(file)
+	} else {
+		if lockFile, err = acquireLock(config.FilePath); err != nil {
+			return nil, err
+		}
+		if file, err = os.OpenFile(config.FilePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); err != nil {
+			lockFile.Close()
+			return nil, fmt.Errorf("failed to open WAL file: %w", err)
+		}
+		header, size, err = initFile(file, newFileHeader(config.Checksum, config.Encoding, config.SequenceNumbers, config.ChecksumScope))
+	}
 	if err != nil {
 		file.Close()
-		return nil, fmt.Errorf("failed to stat WAL file: %w", err)
+		lockFile.Close() // nil when read-only
+		return nil, err
 	}
 
 	wal := &WAL{
-		file:          file,
-		filePath:      config.FilePath,
-		offset:        stat.Size(),
-		syncBatchSize: config.SyncBatchSize,
+		file:            file,
+		lockFile:        lockFile,
+		writer:          bufio.NewWriterSize(file, config.WriteBufferSize),
+		filePath:        config.FilePath,
+		offset:          size,
+		syncedOffset:    size,
+		header:          header,
+		checksum:        config.Checksum,
+		encoding:        config.Encoding,
+		checksumScope:   config.ChecksumScope,
+		syncer:          config.syncer,
+		readOnly:        config.ReadOnly,
+		observer:        config.Observer,
+		clock:           config.Clock,
+		syncBatchSize:   config.SyncBatchSize,
+		forceSyncTypes:  make(map[RecordType]bool, len(config.ForceSyncTypes)),
+		maxSegmentBytes: config.MaxSegmentBytes,
+		maxSegments:     config.MaxSegments,
+		archiveDir:      config.ArchiveDir,
+
+		strictReplay:     config.StrictReplay,
+		compressPayloads: config.CompressPayloads,
+		compressMinBytes: config.CompressMinBytes,
+		aead:             aead,
+		maxRecordSize:    config.MaxRecordSize,
+		maxWindow:        config.MaxExecutionWindow,
+		preallocate:      config.PreallocateBytes,
+		useMmap:          config.UseMmap,
+		decodeCache:      newDecodeCache(config.DecodeCacheSize),
+
+		sequence:       config.SequenceNumbers,
+		strictSequence: config.StrictSequence,
+		nextSeq:        1,
+
+		crashAt:     config.crashAt,
+		rotateFault: config.rotateFault,
+	}
+	wal.syncCond = sync.NewCond(&wal.mu)
+	for _, t := range config.ForceSyncTypes {
+		wal.forceSyncTypes[t] = true
+	}
+
+	if err := wal.loadManifestLocked(); err != nil {
+		file.Close()
+		lockFile.Close()
+		return nil, err
+	}
+	wal.written = wal.manifest.BytesWritten + uint64(max(wal.offset-headerSize, 0))
+
+	if err := wal.openReadFileLocked(); err != nil {
+		file.Close()
+		lockFile.Close()
+		return nil, err
+	}
+
+	if config.RepairOnOpen && !config.ReadOnly {
+		size := wal.offset
+		end, err := wal.repairLocked()
+		if err != nil {
+			wal.readFile.Close()
+			file.Close()
+			lockFile.Close()
+			return nil, fmt.Errorf("failed to repair WAL: %w", err)
+		}
+		if end < size {
+			wal.observer.TailRepaired(size - end)
+		}
+	}
+
+	if !config.ReadOnly {
+		if err := wal.preallocateLocked(); err != nil {
+			wal.readFile.Close()
+			file.Close()
+			lockFile.Close()
+			return nil, err
+		}
+	}
+
+	if config.SequenceNumbers || header.sequenced() {
+		last, err := wal.lastSeqLocked()
+		if err != nil {
+			wal.readFile.Close()
+			file.Close()
+			lockFile.Close()
+			return nil, fmt.Errorf("failed to load sequence number: %w", err)
+		}
+		wal.nextSeq = last + 1
+	}
+
+	if config.TrackRequestIDs {
+		if err := wal.loadRequestIDsLocked(); err != nil {
+			wal.readFile.Close()
+			file.Close()
+			lockFile.Close()
+			return nil, fmt.Errorf("failed to load request IDs: %w", err)
+		}
+	}
+
+	if config.EnforceLifecycle {
+		if err := wal.loadStateLocked(); err != nil {
+			wal.readFile.Close()
+			file.Close()
+			lockFile.Close()
+			return nil, fmt.Errorf("failed to load state: %w", err)
+		}
+	}
+
+	if config.SyncInterval > 0 && !config.ReadOnly {
+		wal.startBackgroundSync(config.SyncInterval)
 	}
 
 	return wal, nil
 }
 
+// preallocateLocked reserves Config.PreallocateBytes for the active file
+// Callers must hold w.mu
+func (w *WAL) preallocateLocked() error {
+	if w.preallocate <= 0 {
+		return nil
+	}
+	return preallocate(w.file, w.preallocate)
+}
+
+// openReadFileLocked opens the read-only handle on the active file,
+// replacing the previous one
+// Reads go through ReadAt on this handle, so replay never moves the write
+// handle's position
+// Callers must hold w.mu
+func (w *WAL) openReadFileLocked() error {
+	readFile, err := os.Open(w.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL read handle: %w", err)
+	}
+
+	if w.readFile != nil {
+		w.readFile.Close()
+	}
+	w.readFile = readFile
+
+	return nil
+}
+
 // Append writes a record to the WAL
-// Records are buffered until Sync() is called or batch size is reached
+// The file is fsynced automatically once SyncBatchSize records have been
+// appended since the last sync, or immediately for ForceSyncTypes
+// A zero CreatedAt, GrantedAt or At is set to the time of the append
 func (w *WAL) Append(record Record) error {
+	_, err := w.AppendAt(record)
+	return err
+}
+
+// AppendAt is Append that also returns the offset at which the record was
+// written. The offset is only meaningful for the current file; it is not
+// stable across rotations
+//
+// Validation and encoding (including compression, encryption and the
+// checksum) happen before the lock is taken, so concurrent appenders only
+// serialize on the buffered write itself, which keeps the log ordered. The
+// fsync of the sync policy runs without the lock, see Sync
+// Large task payloads are the exception: they are written straight from
+// the record under the lock, see streamLocked
+func (w *WAL) AppendAt(record Record) (int64, error) {
+	start := time.Now()
+	record = stampRecord(record, w.clock())
+	if err := w.validateRecord(record); err != nil {
+		return 0, err
+	}
+
+	header, err := w.activeHeader()
+	if err != nil {
+		return 0, err
+	}
+	data, frame, err := w.encodeAppend(header, record)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.writableLocked(); err != nil {
+		return 0, err
+	}
+	if w.header != header {
+		// The active file was replaced by one with a different framing
+		if data, frame, err = w.encodeAppend(w.header, record); err != nil {
+			return 0, fmt.Errorf("failed to encode record: %w", err)
+		}
+	}
+	if err := w.checkSequenceLocked([]Record{record}); err != nil {
+		return 0, err
+	}
+	if err := w.enforceLocked([]Record{record}); err != nil {
+		return 0, err
+	}
+
+	// Large task payloads are streamed rather than encoded into data
+	offset := w.offset
+	size := len(data)
+	if frame != nil {
+		size, err = w.streamLocked(frame)
+	} else {
+		w.sequenceLocked(data, []int{len(data)})
+		err = w.bufferLocked(data, 1)
+	}
+	if err != nil {
+		return offset, err
+	}
+	if err := w.syncPolicyLocked(w.forceSyncTypes[record.Type]); err != nil {
+		return offset, err
+	}
+	w.observer.RecordAppended(record.Type, size)
+	w.trackRequestID(record)
+	w.appendLatency.observe(time.Since(start))
+
+	return offset, nil
+}
+
+// AppendBatch writes several records as a single unit under one lock
+// acquisition, so they are never interleaved with concurrent appends
+// Every record is validated and encoded first, outside the lock as in
+// AppendAt; if any fails, nothing is written
+func (w *WAL) AppendBatch(records []Record) error {
+	start := time.Now()
+	now := w.clock()
+	stamped := make([]Record, len(records))
+	for i, record := range records {
+		stamped[i] = stampRecord(record, now)
+	}
+	records = stamped
+
+	for i, record := range records {
+		if err := w.validateRecord(record); err != nil {
+			return fmt.Errorf("batch record %d: %w", i, err)
+		}
+	}
+
+	header, err := w.activeHeader()
+	if err != nil {
+		return err
+	}
+	data, sizes, err := w.encodeBatch(header, records)
+	if err != nil {
+		return err
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+	if w.header != header {
+		// The active file was replaced by one with a different framing
+		if data, sizes, err = w.encodeBatch(w.header, records); err != nil {
+			return err
+		}
+	}
+	if err := w.checkSequenceLocked(records); err != nil {
+		return err
+	}
+	if err := w.enforceLocked(records); err != nil {
+		return err
+	}
+	w.sequenceLocked(data, sizes)
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	force := false
+	for _, record := range records {
+		force = force || w.forceSyncTypes[record.Type]
+	}
+
+	if err := w.writeLocked(data, len(records), force); err != nil {
+		return err
+	}
+	for i, record := range records {
+		w.observer.RecordAppended(record.Type, sizes[i])
+		w.trackRequestID(record)
+	}
+	w.appendLatency.observe(time.Since(start))
+
+	return nil
+}
+
+// stampRecord returns the record with a zero CreatedAt, GrantedAt or At set
+// to now. Timestamps are UTC without a monotonic reading, so they compare
+// equal to their decoded form
+func stampRecord(record Record, now time.Time) Record {
+	now = now.UTC()
+
+	switch p := record.Payload.(type) {
+	case TaskCreatedPayload:
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = now
+			record.Payload = p
+		}
+	case LeaseGrantedPayload:
+		if p.GrantedAt.IsZero() {
+			p.GrantedAt = now
+			record.Payload = p
+		}
+	case LeaseExtendedPayload:
+		if p.At.IsZero() {
+			p.At = now
+			record.Payload = p
+		}
+	case LeaseExpiredPayload:
+		if p.At.IsZero() {
+			p.At = now
+			record.Payload = p
+		}
+	case LeaseRenewalDeniedPayload:
+		if p.At.IsZero() {
+			p.At = now
+			record.Payload = p
+		}
+	case AnnotationPayload:
+		if p.At.IsZero() {
+			p.At = now
+			record.Payload = p
+		}
+	}
+
+	return record
+}
+
+// encodeBatch encodes records back to back for a file with the given
+// header. Returns the frames and the size of each
+func (w *WAL) encodeBatch(header fileHeader, records []Record) ([]byte, []int, error) {
+	var data []byte
+	sizes := make([]int, len(records))
+	for i, record := range records {
+		encoded, err := w.encodeRecordWith(header, record)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode batch record %d: %w", i, err)
+		}
+		data = append(data, encoded...)
+		sizes[i] = len(encoded)
+	}
+
+	return data, sizes, nil
+}
+
+// newHeader returns the header for a new active file
+func (w *WAL) newHeader() fileHeader {
+	return newFileHeader(w.checksum, w.encoding, w.sequence, w.checksumScope)
+}
+
+// activeHeader returns the header of the active file, so records can be
+// encoded before the lock is taken to write them
+func (w *WAL) activeHeader() (fileHeader, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.writableLocked(); err != nil {
+		return fileHeader{}, err
+	}
+
+	return w.header, nil
+}
+
+// writableLocked returns the error a write must fail with, if any
+// Callers must hold w.mu
+func (w *WAL) writableLocked() error {
 	if w.file == nil {
 		return ErrWALClosed
 	}
+	if w.readOnly {
+		return ErrReadOnly
+	}
 
-	// TODO: Encode the record as bytes
-	// This should include:
-	// - Record length (4 bytes)
-	// - Record type (1 byte)
-	// - Payload (variable)
-	// - Checksum (4 bytes)
+	return nil
+}
 
-	data, err := w.encodeRecord(record)
-	if err != nil {
-		return fmt.Errorf("failed to encode record: %w", err)
+// writeLocked writes encoded records to the buffer and applies the sync
+// policy: critical record types are made durable immediately, otherwise
+// fsync once the batch is full
+// Callers must hold w.mu
+func (w *WAL) writeLocked(data []byte, records int, force bool) error {
+	if err := w.bufferLocked(data, records); err != nil {
+		return err
 	}
 
-	// Write to file
-	n, err := w.file.Write(data)
+	return w.syncPolicyLocked(force)
+}
+
+// syncPolicyLocked applies the sync policy and size-based rotation to
+// records just buffered. A due fsync is group committed as in Sync, so
+// w.mu is released while it runs
+// Callers must hold w.mu
+func (w *WAL) syncPolicyLocked(force bool) error {
+	if force || w.appended-w.synced >= uint64(w.syncBatchSize) {
+		if err := w.syncThroughLocked(w.appended); err != nil {
+			return err
+		}
+	}
+
+	if w.maxSegmentBytes > 0 && w.offset >= w.maxSegmentBytes {
+		// Another append may rotate while a group sync is awaited
+		w.waitSyncLocked()
+		if w.file != nil && w.offset >= w.maxSegmentBytes {
+			return w.rotateLocked()
+		}
+	}
+
+	return nil
+}
+
+// bufferLocked writes encoded records to the buffer without applying the
+// sync policy; they reach the file on flush
+// Callers must hold w.mu
+func (w *WAL) bufferLocked(data []byte, records int) error {
+	if w.crashDue(len(data)) {
+		return w.crashLocked(data)
+	}
+
+	// A full buffer is flushed by Write itself, which may then fail part way
+	end := w.offset - int64(w.writer.Buffered())
+	n, err := w.writer.Write(data)
 	if err != nil {
-		return fmt.Errorf("failed to write record: %w", err)
+		return w.rollbackLocked(end, fmt.Errorf("failed to write record: %w", err))
 	}
 
 	if n != len(data) {
-		return ErrPartialWrite
+		return w.rollbackLocked(end, ErrPartialWrite)
 	}
 
 	w.offset += int64(n)
-
-	// TODO: implement batched sync logic
-	// For now, just note that sync should be called explicitly or after batch
+	w.written += uint64(n)
+	w.appended += uint64(records)
 
 	return nil
 }
 
-// Sync forces durability by calling fsync
+// Offset returns the current append offset, i.e. where the next record
+// will be written. It can be passed to ReplayFrom as a checkpoint
+// The offset is only meaningful for the current file; it is not stable
+// across rotations
+func (w *WAL) Offset() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.offset
+}
+
+// LastSyncedOffset returns the offset in the active file through which
+// records are durable, i.e. covered by an fsync. Records appended since
+// the last Sync lie between it and Offset; callers that acknowledge work
+// only up to this offset never acknowledge a record a crash can lose
+// Like Offset, it is only meaningful for the current file
+func (w *WAL) LastSyncedOffset() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.syncedOffset
+}
+
+// Sync flushes buffered records to the file and forces durability by calling fsync
 // All records appended before this call are guaranteed to be durable
+//
+// Concurrent calls are group committed: the fsync runs without holding the
+// lock, so appends continue meanwhile, and a caller whose records were
+// already covered by an fsync in flight waits for it instead of issuing
+// its own
 func (w *WAL) Sync() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if w.file == nil {
-		return ErrWALClosed
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+
+	return w.syncThroughLocked(w.appended)
+}
+
+// syncThroughLocked returns once the first target records appended are
+// durable, joining a group sync in flight or starting one
+// Callers must hold w.mu, which is released while waiting or syncing
+func (w *WAL) syncThroughLocked(target uint64) error {
+	for w.synced < target {
+		if w.file == nil {
+			return ErrWALClosed
+		}
+		if w.syncing {
+			w.syncCond.Wait()
+			continue
+		}
+		if err := w.groupSyncLocked(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush hands buffered records to the OS without fsyncing, making them
+// visible to other readers of the file
+// Flushed records survive a crash of this process but not of the machine:
+// only Sync makes them durable
+func (w *WAL) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.writableLocked(); err != nil {
+		return err
+	}
+
+	return w.flushLocked()
+}
+
+// groupSyncLocked flushes the write buffer and fsyncs the file with w.mu
+// released, so that appends and other Sync callers can proceed
+// Callers must hold w.mu and check that no group sync is running
+func (w *WAL) groupSyncLocked() error {
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+
+	file, covered, end := w.file, w.appended, w.offset
+	w.syncing = true
+	w.mu.Unlock()
+	start := time.Now()
+	err := w.syncer.Sync(file)
+	w.mu.Lock()
+	w.syncing = false
+	w.syncCond.Broadcast()
+
+	if err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	elapsed := time.Since(start)
+	w.syncLatency.observe(elapsed)
+	w.observer.SyncCompleted(elapsed)
+	if covered > w.synced {
+		w.synced = covered
+	}
+	if file == w.file && end > w.syncedOffset {
+		w.syncedOffset = end
+	}
+
+	return nil
+}
+
+// waitSyncLocked blocks until no group sync is running, so the active file
+// can be closed or replaced. w.mu is released while waiting
+// Callers must hold w.mu
+func (w *WAL) waitSyncLocked() {
+	for w.syncing {
+		w.syncCond.Wait()
 	}
+}
 
-	if err := w.file.Sync(); err != nil {
+// syncLocked flushes the write buffer, fsyncs the file and resets the
+// batch counter
+// Callers must hold w.mu
+func (w *WAL) syncLocked() error {
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := w.syncer.Sync(w.file); err != nil {
 		return fmt.Errorf("failed to sync WAL: %w", err)
 	}
+	elapsed := time.Since(start)
+	w.syncLatency.observe(elapsed)
+	w.observer.SyncCompleted(elapsed)
+	w.synced = w.appended
+	w.syncedOffset = w.offset
+
+	return nil
+}
+
+// flushLocked hands buffered records to the OS without fsyncing
+// Callers must hold w.mu
+func (w *WAL) flushLocked() error {
+	end := w.offset - int64(w.writer.Buffered())
+	if err := w.writer.Flush(); err != nil {
+		return w.rollbackLocked(end, fmt.Errorf("failed to flush WAL buffer: %w", err))
+	}
 
 	return nil
 }
 
+// rollbackLocked undoes a failed write, e.g. a short write on a full disk,
+// so the log still ends on a record boundary: the file is cut back to end,
+// where it held only whole records, and the records still buffered are
+// dropped. Returns cause, which keeps the underlying error, e.g. ENOSPC
+// Dropped records were never synced, so no durability promise is broken
+// Callers must hold w.mu
+func (w *WAL) rollbackLocked(end int64, cause error) error {
+	w.writer.Reset(w.file)
+	if err := w.file.Truncate(end); err != nil {
+		return fmt.Errorf("%w (rollback to offset %d failed: %v)", cause, end, err)
+	}
+	w.written -= uint64(w.offset - end)
+	w.offset = end
+	w.syncedOffset = min(w.syncedOffset, end)
+	w.decodeCache.clear()
+
+	// Dropped records may have taken sequence numbers and request IDs
+	if w.header.sequenced() {
+		last, err := w.lastSeqLocked()
+		if err != nil {
+			return fmt.Errorf("%w (failed to reload sequence number: %v)", cause, err)
+		}
+		w.nextSeq = last + 1
+	}
+	if w.requestIDs != nil {
+		if err := w.loadRequestIDsLocked(); err != nil {
+			return fmt.Errorf("%w (failed to reload request IDs: %v)", cause, err)
+		}
+	}
+	if w.state != nil {
+		if err := w.loadStateLocked(); err != nil {
+			return fmt.Errorf("%w (failed to reload state: %v)", cause, err)
+		}
+	}
+
+	return cause
+}
+
 // Replay reads all records from the WAL and calls the apply function for each
 // This is used during recovery to reconstruct coordinator state
 // Replay is deterministic and sequential
 func (w *WAL) Replay(applyFn func(Record) error) error {
+	return w.ReplayContext(context.Background(), applyFn)
+}
+
+// ReplayContext is Replay with cancellation
+// The context is checked before each record is read; if it is cancelled,
+// replay stops and the context error is returned
+func (w *WAL) ReplayContext(ctx context.Context, applyFn func(Record) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.replayAllLocked(ctx, applyOnly(applyFn))
+}
+
+// ReplayWithOffset is Replay that also passes the offset at which each
+// record starts, e.g. to build an index or a precise checkpoint
+// Offsets of records in sealed segments are relative to their segment file;
+// only those of the active file can be passed to ReplayFrom or Truncate
+func (w *WAL) ReplayWithOffset(applyFn func(offset int64, record Record) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.replayAllLocked(context.Background(), func(record Record, offset, _ int64) error {
+		return applyFn(offset, record)
+	})
+}
+
+// ReplayN is Replay that stops after applying at most n records, for
+// head-like inspection without scanning the whole log
+// A replay bounded by time instead is ReplayContext with a deadline; to know
+// where replay stopped, use ReplayWithOffset
+func (w *WAL) ReplayN(n int, applyFn func(Record) error) error {
+	if n <= 0 {
+		return nil
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	applied := 0
+	err := w.replayAllLocked(context.Background(), func(record Record, _, _ int64) error {
+		if err := applyFn(record); err != nil {
+			return err
+		}
+		if applied++; applied == n {
+			return errReplayLimit
+		}
+		return nil
+	})
+	if errors.Is(err, errReplayLimit) {
+		return nil
+	}
+
+	return err
+}
+
+// ReplayCollectErrors is Replay that does not stop when applyFn fails: each
+// failure is collected as a ReplayError locating the record, and replay
+// goes on with the next one, e.g. for a best-effort analytics pass
+// As in ReplayWithOffset, offsets and indexes of records in sealed
+// segments are relative to their segment file
+// The error reports a log that cannot be read, such as corruption, in
+// which case the failures collected so far are returned with it
+func (w *WAL) ReplayCollectErrors(applyFn func(Record) error) ([]ReplayError, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var failures []ReplayError
+	index := 0
+	err := w.replayAllLocked(context.Background(), func(record Record, offset, _ int64) error {
+		if offset == headerSize {
+			index = 0 // first record of the next file
+		}
+		if err := applyFn(record); err != nil {
+			failures = append(failures, ReplayError{
+				Offset:      offset,
+				RecordIndex: index,
+				Err:         fmt.Errorf("failed to apply record: %w", err),
+			})
+		}
+		index++
+		return nil
+	})
+
+	return failures, err
+}
+
+// errReplayLimit stops ReplayN once enough records were applied
+var errReplayLimit = errors.New("replay limit reached")
+
+// replayAllLocked replays the sealed segments, then the active file
+// Callers must hold w.mu
+func (w *WAL) replayAllLocked(ctx context.Context, scanFn scanFunc) error {
+	_, err := w.replayAllEndLocked(ctx, scanFn)
+	return err
+}
+
+// replayAllEndLocked is replayAllLocked that also returns the offset just
+// past the last record applied from the active file
+// Callers must hold w.mu
+func (w *WAL) replayAllEndLocked(ctx context.Context, scanFn scanFunc) (int64, error) {
 	if w.file == nil {
-		return ErrWALClosed
+		return 0, ErrWALClosed
+	}
+
+	scanFn, done := w.observeReplay(w.strictSequenceCheck(scanFn))
+	defer done()
+
+	if err := w.replaySegmentsLocked(ctx, scanFn); err != nil {
+		return 0, err
+	}
+
+	return w.replayFromLocked(ctx, 0, scanFn)
+}
+
+// ReplayFrom replays the records of the active file starting at offset,
+// which must be a record boundary previously returned by ReplayFrom or Offset,
+// or 0 for the first record
+// Sealed segments are not replayed
+// Returns the offset just past the last record applied, suitable as the
+// next checkpoint
+func (w *WAL) ReplayFrom(offset int64, applyFn func(Record) error) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.replayFromLocked(context.Background(), offset, w.strictSequenceCheck(applyOnly(applyFn)))
+}
+
+// replayFromLocked replays the active file from offset to the end of the log
+// through the read handle
+// Callers must hold w.mu
+func (w *WAL) replayFromLocked(ctx context.Context, offset int64, scanFn scanFunc) (int64, error) {
+	if w.file == nil {
+		return offset, ErrWALClosed
+	}
+
+	// Buffered records must be visible to the reader
+	if err := w.flushLocked(); err != nil {
+		return offset, err
+	}
+
+	// Offset 0 means the first record, just past the header
+	if offset == 0 {
+		offset = headerSize
+	}
+
+	if err := w.checkBoundaryLocked(offset); err != nil {
+		return offset, err
+	}
+
+	// The mapping, if any, is released before the lock is, so nothing can
+	// truncate the file beneath it
+	ra, release, err := w.readerAt(w.readFile, w.offset)
+	if err != nil {
+		return offset, err
+	}
+	defer release()
+
+	r := bufio.NewReader(io.NewSectionReader(ra, offset, w.offset-offset))
+	return replayRecords(ctx, r, w.format(w.header), offset, scanFn, w.activeTailPolicy())
+}
+
+// checkBoundaryLocked rejects offsets that cannot be the start of a record:
+// outside the file, or whose length prefix claims more bytes than remain
+// Callers must hold w.mu
+func (w *WAL) checkBoundaryLocked(offset int64) error {
+	if offset < headerSize || offset > w.offset {
+		return fmt.Errorf("%w: offset %d outside log of %d bytes", ErrCorruptedLog, offset, w.offset)
+	}
+	if w.offset-offset < lengthPrefixSize {
+		return nil // at the end, or a torn prefix that replay discards
+	}
+	if offset == headerSize {
+		return nil // the first record, which may be torn
+	}
+
+	var prefix [lengthPrefixSize]byte
+	if _, err := w.readFile.ReadAt(prefix[:], offset); err != nil {
+		return fmt.Errorf("failed to read record at offset %d: %w", offset, err)
+	}
+	length := int64(binary.LittleEndian.Uint32(prefix[:]))
+	if length < int64(recordTypeSize+w.header.checksum.size()) || length > w.offset-offset-lengthPrefixSize {
+		return fmt.Errorf("%w: offset %d is not a record boundary", ErrCorruptedLog, offset)
 	}
 
-	// Seek to beginning
-	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("failed to seek WAL: %w", err)
+	return nil
+}
+
+// scanFunc receives each record read during replay along with the offset
+// and size of its frame in the file it was read from
+type scanFunc func(record Record, offset, size int64) error
+
+// applyOnly adapts a Replay apply function to a scanFunc
+func applyOnly(applyFn func(Record) error) scanFunc {
+	return func(record Record, _, _ int64) error {
+		return applyFn(record)
 	}
+}
+
+// tailPolicy decides which bad final records replay may discard
+type tailPolicy int
 
+const (
+	// tailNone treats any bad record as corruption (sealed segments)
+	tailNone tailPolicy = iota
+	// tailTorn discards a short or checksum-failing final record
+	tailTorn
+	// tailShortOnly discards only a short final record (StrictReplay)
+	tailShortOnly
+)
+
+// tolerates reports whether err on the final record may be discarded
+func (p tailPolicy) tolerates(err error) bool {
+	switch p {
+	case tailTorn:
+		return errors.Is(err, ErrPartialWrite) || errors.Is(err, ErrInvalidChecksum)
+	case tailShortOnly:
+		return errors.Is(err, ErrPartialWrite)
+	}
+	return false
+}
+
+// activeTailPolicy returns the tail policy for the active file
+func (w *WAL) activeTailPolicy() tailPolicy {
+	if w.strictReplay {
+		return tailShortOnly
+	}
+	return tailTorn
+}
+
+// replayRecords reads and applies records from r, whose position is offset
+// A bad record is discarded only if it is the last thing in the file and
+// the tail policy allows it; with more data after it, it is corruption
+// Returns the offset just past the last record applied
+func replayRecords(ctx context.Context, r *bufio.Reader, format frameFormat, offset int64, scanFn scanFunc, tail tailPolicy) (int64, error) {
 	// Read and apply records one by one
-	for {
-		record, err := w.readNextRecord()
+	for index := 0; ; index++ {
+		if err := ctx.Err(); err != nil {
+			return offset, err
+		}
+
+		record, size, err := readRecord(r, format)
 		if err == io.EOF {
-			break
+			return offset, nil
 		}
 		if err != nil {
 			// Partial write at end of log is tolerable
+			if tail.tolerates(err) && atEOF(r) {
+				// Discard partial final record
+				return offset, nil
+			}
 			if errors.Is(err, ErrPartialWrite) || errors.Is(err, ErrInvalidChecksum) {
-				// Discard partial final record and continue
-				break
+				err = fmt.Errorf("%w: bad record: %w", ErrCorruptedLog, err)
 			}
-			return fmt.Errorf("failed to read record during replay: %w", err)
+			return offset, &ReplayError{Offset: offset, RecordIndex: index, Err: fmt.Errorf("failed to read record: %w", err)}
 		}
 
 		// Apply the record
-		if err := applyFn(record); err != nil {
-			return fmt.Errorf("failed to apply record during replay: %w", err)
+		if err := scanFn(record, offset, size); err != nil {
+			return offset, &ReplayError{Offset: offset, RecordIndex: index, Err: fmt.Errorf("failed to apply record: %w", err)}
 		}
+		offset += size
 	}
+}
 
-	// Seek back to end for future appends
-	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
-		return fmt.Errorf("failed to seek to end after replay: %w", err)
-	}
+// ReplayError reports the record on which replay failed. Offset is the
+// start of its frame in the file being replayed, so passing it to Truncate
+// drops that record and everything after it; RecordIndex counts the records
+// before it in the same file, from where replay of that file started
+// The underlying error, sentinel errors included, is available through
+// errors.Is and errors.As
+type ReplayError struct {
+	Offset      int64
+	RecordIndex int
+	Err         error
+}
 
-	return nil
+// Error describes the failing record and the cause
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("replay failed at record %d, offset %d: %v", e.RecordIndex, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// atEOF reports whether r has no more data
+func atEOF(r *bufio.Reader) bool {
+	_, err := r.Peek(1)
+	return err == io.EOF
 }
 
 // Close closes the WAL file
 // Any unflushed data should be synced before closing
 func (w *WAL) Close() error {
+	w.stopBackgroundSync()
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	w.waitSyncLocked()
 	if w.file == nil {
+		// Whatever closed the active file may have left the lock behind
+		w.releaseLocked()
 		return nil
 	}
 
-	// Sync before closing
-	if err := w.file.Sync(); err != nil {
-		w.file.Close()
-		return fmt.Errorf("failed to sync before close: %w", err)
+	// Flush and sync before closing; the handles are released either way
+	var syncErr error
+	if !w.readOnly {
+		syncErr = w.syncLocked()
 	}
+	closeErr := w.file.Close()
+	w.file = nil
+	// The lock is released last, once no more writes can happen
+	w.releaseLocked()
+	w.writer = nil
 
-	if err := w.file.Close(); err != nil {
-		return fmt.Errorf("failed to close WAL: %w", err)
+	if syncErr != nil {
+		return fmt.Errorf("failed to sync before close: %w", syncErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close WAL: %w", closeErr)
 	}
-
-	w.file = nil
 	return nil
 }
 
+// releaseLocked closes the read handle and releases the file lock once the
+// active file is closed, so the log can be opened again. Every path that
+// leaves the WAL closed must call it
+// Callers must hold w.mu
+func (w *WAL) releaseLocked() {
+	if w.readFile != nil {
+		w.readFile.Close()
+	}
+	if w.lockFile != nil {
+		w.lockFile.Close()
+	}
+	w.readFile = nil
+	w.lockFile = nil
+}
+
 // encodeRecord serializes a record to bytes
 // Format:
 // - Length (4 bytes, uint32): total length excluding length field
-// - Type (1 byte): record type
-// - Payload (variable): serialized payload
-// - Checksum (4 bytes, uint32): CRC32 of type + payload
+// - Type (1 byte): record type, with record flags in the high bits
+// - Seq (8 bytes, uint64): sequence number, only in sequenced files
+// - Payload (variable): serialized payload, see encodePayload, or a JSON
+// object for EncodingJSON files; may be compressed and then encrypted
+// - Checksum (4 or 8 bytes): checksum of type + seq + payload, and of the
+// length too under ChecksumScopeFrame, see ChecksumType
+// All integers are little-endian
 func (w *WAL) encodeRecord(record Record) ([]byte, error) {
-	// TODO: Implement proper encoding
-	// This is a placeholder that needs to be implemented based on:
-	// - Chosen serialization format (e.g., protobuf, JSON, custom binary)
-	// - Checksum algorithm (e.g., CRC32)
+	return w.encodeRecordWith(w.header, record)
+}
+
+// encodeRecordWith is encodeRecord for a file with the given header
+// It only reads configuration fixed at Open, so it is safe without w.mu
+func (w *WAL) encodeRecordWith(header fileHeader, record Record) ([]byte, error) {
+	payload, err := encodePayloadAs(header.encoding(), header.version, record)
+	if err != nil {
+		return nil, err
+	}
+
+	typeByte := byte(record.Type)
+	if w.shouldCompress(record) {
+		// Readers refuse to inflate a payload past the record size limit
+		if w.maxRecordSize > 0 && len(payload) > w.maxRecordSize {
+			return nil, fmt.Errorf("%w: %d byte payload, limit %d", ErrRecordTooLarge, len(payload), w.maxRecordSize)
+		}
+		if payload, err = compressPayload(payload); err != nil {
+			return nil, err
+		}
+		typeByte |= recordFlagCompressed
+	}
+	if w.aead != nil {
+		typeByte |= recordFlagEncrypted
+		if payload, err = encryptPayload(w.aead, typeByte, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.frameRecord(header, typeByte, record.Seq, payload)
+}
+
+// frameRecord frames an encoded payload, as stored after compression and
+// encryption, for a file with the given header
+func (w *WAL) frameRecord(header fileHeader, typeByte byte, seq uint64, payload []byte) ([]byte, error) {
+	checksum := header.checksum
+	length := recordTypeSize + len(payload) + checksum.size()
+	if header.sequenced() {
+		length += sequenceSize
+	}
+	if w.maxRecordSize > 0 && length > w.maxRecordSize {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrRecordTooLarge, length, w.maxRecordSize)
+	}
+	data := make([]byte, lengthPrefixSize, lengthPrefixSize+length)
+	binary.LittleEndian.PutUint32(data, uint32(length))
+	data = append(data, typeByte)
+	if header.sequenced() {
+		data = binary.LittleEndian.AppendUint64(data, seq)
+	}
+	data = append(data, payload...)
+	data = checksum.appendSum(data, header.checksumScope().covered(data))
 
-	return nil, errors.New("encodeRecord not yet implemented")
+	return data, nil
 }
 
 // readNextRecord reads the next record from the current file position
-func (w *WAL) readNextRecord() (Record, error) {
-	// Read length prefix (4 bytes)
-	var length uint32
-	if err := binary.Read(w.file, binary.LittleEndian, &length); err != nil {
-		return Record{}, err
+// Returns the record and the size of its frame on disk
+func (w *WAL) readNextRecord() (Record, int64, error) {
+	return readRecord(w.file, w.format(w.header))
+}
+
+// format returns the frame format of a file with the given header
+func (w *WAL) format(header fileHeader) frameFormat {
+	format := header.frameFormat()
+	format.aead = w.aead
+	format.maxRecordSize = w.maxRecordSize
+	format.types = w.replayTypes
+	return format
+}
+
+// readRecord reads and verifies one record frame from r
+// Returns the frame size also when a complete frame fails verification or
+// decoding, so callers can skip over it
+func readRecord(r io.Reader, format frameFormat) (Record, int64, error) {
+	body, size, err := readFrame(r, format)
+	if err != nil {
+		return Record{}, size, err
 	}
 
-	// Read the rest of the record
-	data := make([]byte, length)
-	if _, err := io.ReadFull(w.file, data); err != nil {
-		return Record{}, ErrPartialWrite
+	recordType := RecordType(body[0] &^ recordFlagMask)
+	raw := body[recordTypeSize:]
+	var seq uint64
+	if format.sequenced {
+		seq = binary.LittleEndian.Uint64(raw)
+		raw = raw[sequenceSize:]
+	}
+	if format.types != nil && !format.types[recordType] {
+		// Framing and checksum are verified, the payload is not needed
+		return Record{Type: recordType, Seq: seq}, size, nil
+	}
+	if body[0]&recordFlagEncrypted != 0 {
+		var err error
+		if raw, err = decryptPayload(format.aead, body[0], raw); err != nil {
+			return Record{}, size, err
+		}
+	}
+	if body[0]&recordFlagCompressed != 0 {
+		var err error
+		if raw, err = decompressPayload(raw, format.recordSizeLimit()); err != nil {
+			return Record{}, size, err
+		}
 	}
 
-	// TODO: Decode and validate the record
-	// This should:
-	// - Extract record type
-	// - Verify checksum
-	// - Deserialize payload
-	// - Return the Record struct
+	payload, err := decodePayloadAs(format.encoding, format.version, recordType, raw)
+	if err != nil {
+		return Record{}, size, err
+	}
 
-	return Record{}, errors.New("readNextRecord not yet implemented")
+	return Record{Type: recordType, Payload: payload, Seq: seq}, size, nil
+}
+
+// readFrame reads one record frame from r and verifies its length and
+// checksum, without decoding the payload
+// Returns the checksummed body (type byte onwards) and the frame size
+func readFrame(r io.Reader, format frameFormat) ([]byte, int64, error) {
+	checksum := format.checksum
+
+	length, err := readLength(r, format)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Read the rest of the record after the length prefix it is framed with
+	frame := make([]byte, lengthPrefixSize+int(length))
+	binary.LittleEndian.PutUint32(frame, length)
+	if _, err := io.ReadFull(r, frame[lengthPrefixSize:]); err != nil {
+		return nil, 0, ErrPartialWrite
+	}
+
+	// Once the whole frame is read its size is known, even if it turns
+	// out to be invalid
+	size := int64(len(frame))
+
+	// Verify the checksum over type + payload, and the length if in scope
+	sumAt := len(frame) - checksum.size()
+	if !checksum.verify(format.scope.covered(frame[:sumAt]), frame[sumAt:]) {
+		return nil, size, ErrInvalidChecksum
+	}
+
+	return frame[lengthPrefixSize:sumAt], size, nil
+}
+
+// readLength reads the length prefix of the next frame from r and checks
+// it is plausible for the format
+func readLength(r io.Reader, format frameFormat) (uint32, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// Torn length prefix at the end of the log
+			return 0, ErrPartialWrite
+		}
+		return 0, err
+	}
+
+	minLength := recordTypeSize + format.checksum.size()
+	if format.sequenced {
+		minLength += sequenceSize
+	}
+	if length < uint32(minLength) {
+		return 0, fmt.Errorf("%w: frame length %d too short", ErrCorruptedLog, length)
+	}
+	if int64(length) > int64(format.recordSizeLimit()) {
+		return 0, fmt.Errorf("%w: frame length %d exceeds limit %d", ErrCorruptedLog, length, format.recordSizeLimit())
+	}
+
+	return length, nil
 }
 
 // Helper methods for validation and invariant checking
 
 // ValidateRecord checks if a record is well-formed
+// Returns ErrInvalidRecord wrapped with the name of the offending field
 func ValidateRecord(record Record) error {
-	// TODO: Implement validation logic for each record type
-	// Check that required fields are present and valid
-	return errors.New("ValidateRecord not yet implemented")
-}
-
-// ApplyRecord applies a record to coordinator state
-// This is called during replay and ensures invariants are preserved
-func ApplyRecord(record Record, state interface{}) error {
-	// TODO: Implement state application logic
-	// This should:
-	// - Check invariants before applying
-	// - Update state based on record type
-	// - Validate state transitions
-	// Note: The actual implementation will depend on coordinator state structure
-	return errors.New("ApplyRecord not yet implemented")
+	switch record.Type {
+	case RecordTypeTaskCreated:
+		p, ok := record.Payload.(TaskCreatedPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if p.TaskID == "" {
+			return missingField(record, "TaskID")
+		}
+		if p.Payload == nil {
+			return missingField(record, "Payload")
+		}
+		if p.ExecutionWindow <= 0 {
+			return invalidField(record, "ExecutionWindow", "must be positive")
+		}
+		if p.RetryPolicy.MaxRetries < 0 {
+			return invalidField(record, "RetryPolicy.MaxRetries", "must not be negative")
+		}
+
+	case RecordTypeTaskCompleted:
+		p, ok := record.Payload.(TaskCompletedPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		return requireTaskAndLease(record, p.TaskID, p.LeaseID)
+
+	case RecordTypeTaskFailed:
+		p, ok := record.Payload.(TaskFailedPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		return requireTaskAndLease(record, p.TaskID, p.LeaseID)
+
+	case RecordTypeTaskCancelled:
+		p, ok := record.Payload.(TaskCancelledPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		return requireTaskAndLease(record, p.TaskID, p.LeaseID)
+
+	case RecordTypeLeaseGranted:
+		p, ok := record.Payload.(LeaseGrantedPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if err := requireTaskAndLease(record, p.TaskID, p.LeaseID); err != nil {
+			return err
+		}
+		if p.WorkerID == "" {
+			return missingField(record, "WorkerID")
+		}
+		if p.Attempt < 0 {
+			return invalidField(record, "Attempt", "must not be negative")
+		}
+		if p.LeaseExpiry.IsZero() {
+			return missingField(record, "LeaseExpiry")
+		}
+
+	case RecordTypeLeaseExtended:
+		p, ok := record.Payload.(LeaseExtendedPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if p.LeaseID == "" {
+			return missingField(record, "LeaseID")
+		}
+		if p.NewLeaseExpiry.IsZero() {
+			return missingField(record, "NewLeaseExpiry")
+		}
+
+	case RecordTypeLeaseExpired:
+		p, ok := record.Payload.(LeaseExpiredPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		return requireTaskAndLease(record, p.TaskID, p.LeaseID)
+
+	case RecordTypeTaskDead:
+		p, ok := record.Payload.(TaskDeadPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if p.TaskID == "" {
+			return missingField(record, "TaskID")
+		}
+
+	case RecordTypeTaskRetried:
+		p, ok := record.Payload.(TaskRetriedPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if err := requireTaskAndLease(record, p.TaskID, p.PreviousLeaseID); err != nil {
+			return err
+		}
+		if p.Attempt < 1 {
+			return invalidField(record, "Attempt", "must be positive")
+		}
+
+	case RecordTypeTaskRescheduled:
+		p, ok := record.Payload.(TaskRescheduledPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if p.TaskID == "" {
+			return missingField(record, "TaskID")
+		}
+		if p.NextAttemptAt.IsZero() {
+			return missingField(record, "NextAttemptAt")
+		}
+		if p.Attempt < 1 {
+			return invalidField(record, "Attempt", "must be positive")
+		}
+		if p.Backoff < 0 {
+			return invalidField(record, "Backoff", "must not be negative")
+		}
+
+	case RecordTypeCheckpoint:
+		p, ok := record.Payload.(CheckpointPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if p.Offset < 0 {
+			return invalidField(record, "Offset", "must not be negative")
+		}
+
+	case RecordTypeLeaseRenewalDenied:
+		p, ok := record.Payload.(LeaseRenewalDeniedPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		return requireTaskAndLease(record, p.TaskID, p.LeaseID)
+
+	case RecordTypeAnnotation:
+		p, ok := record.Payload.(AnnotationPayload)
+		if !ok {
+			return payloadMismatch(record)
+		}
+		if p.Text == "" {
+			return missingField(record, "Text")
+		}
+
+	default:
+		if _, ok := lookupCodec(record.Type); ok {
+			return nil // checked by its codec on encode
+		}
+		return fmt.Errorf("%w: unknown record type %d", ErrInvalidRecord, record.Type)
+	}
+
+	return nil
+}
+
+// validateRecord is ValidateRecord plus the limits set in Config
+func (w *WAL) validateRecord(record Record) error {
+	if err := ValidateRecord(record); err != nil {
+		return err
+	}
+	if p, ok := record.Payload.(TaskCreatedPayload); ok && p.ExecutionWindow > w.maxWindow {
+		return invalidField(record, "ExecutionWindow", fmt.Sprintf("must not exceed %s", w.maxWindow))
+	}
+
+	return nil
+}
+
+// requireTaskAndLease checks the TaskID/LeaseID pair shared by most records
+func requireTaskAndLease(record Record, taskID, leaseID string) error {
+	if taskID == "" {
+		return missingField(record, "TaskID")
+	}
+	if leaseID == "" {
+		return missingField(record, "LeaseID")
+	}
+	return nil
+}
+
+func missingField(record Record, field string) error {
+	return fmt.Errorf("%w: %T.%s is required", ErrInvalidRecord, record.Payload, field)
+}
+
+func invalidField(record Record, field, reason string) error {
+	return fmt.Errorf("%w: %T.%s %s", ErrInvalidRecord, record.Payload, field, reason)
 }
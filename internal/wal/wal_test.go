@@ -0,0 +1,678 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestForceSyncTypesFlushBatch(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{
+		SyncBatchSize:  10,
+		ForceSyncTypes: []RecordType{RecordTypeTaskDead},
+		syncer:         syncer,
+	})
+
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"), taskCreated("c"))
+	if got := syncer.count(); got != 0 {
+		t.Fatalf("syncs after 3 routine appends = %d, want 0", got)
+	}
+
+	mustAppend(t, w, taskDead("a", "operator"))
+	if got := syncer.count(); got != 1 {
+		t.Fatalf("syncs after TaskDead = %d, want 1", got)
+	}
+	if got, want := w.LastSyncedOffset(), w.Offset(); got != want {
+		t.Fatalf("LastSyncedOffset = %d, want %d", got, want)
+	}
+
+	// The forced sync resets the batch: nine more appends stay buffered,
+	// the tenth fills the batch
+	for i := 0; i < 9; i++ {
+		mustAppend(t, w, annotation("routine"))
+	}
+	if got := syncer.count(); got != 1 {
+		t.Fatalf("syncs after 9 more appends = %d, want 1", got)
+	}
+	mustAppend(t, w, annotation("routine"))
+	if got := syncer.count(); got != 2 {
+		t.Fatalf("syncs after a full batch = %d, want 2", got)
+	}
+}
+
+func TestForceSyncTypesInBatch(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{
+		SyncBatchSize:  100,
+		ForceSyncTypes: []RecordType{RecordTypeTaskDead},
+		syncer:         syncer,
+	})
+
+	if err := w.AppendBatch([]Record{taskCreated("a"), annotation("note")}); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+	if got := syncer.count(); got != 0 {
+		t.Fatalf("syncs after a routine batch = %d, want 0", got)
+	}
+	if err := w.AppendBatch([]Record{taskCreated("b"), taskDead("b", "operator")}); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+	if got := syncer.count(); got != 1 {
+		t.Fatalf("syncs after a batch with TaskDead = %d, want 1", got)
+	}
+}
+
+func TestSyncBatchSize(t *testing.T) {
+	const batch = 5
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: batch, syncer: syncer})
+
+	for i := 0; i < batch-1; i++ {
+		mustAppend(t, w, annotation("routine"))
+	}
+	if got := syncer.count(); got != 0 {
+		t.Fatalf("syncs after %d appends = %d, want 0", batch-1, got)
+	}
+	mustAppend(t, w, annotation("routine"))
+	if got := syncer.count(); got != 1 {
+		t.Fatalf("syncs after %d appends = %d, want 1", batch, got)
+	}
+}
+
+func TestSyncResetsBatch(t *testing.T) {
+	const batch = 5
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: batch, syncer: syncer})
+
+	mustAppend(t, w, annotation("a"), annotation("b"), annotation("c"))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := syncer.count(); got != 1 {
+		t.Fatalf("syncs after Sync = %d, want 1", got)
+	}
+
+	// The explicit sync leaves no stale count behind
+	for i := 0; i < batch-1; i++ {
+		mustAppend(t, w, annotation("routine"))
+	}
+	if got := syncer.count(); got != 1 {
+		t.Fatalf("syncs after %d more appends = %d, want 1", batch-1, got)
+	}
+	mustAppend(t, w, annotation("routine"))
+	if got := syncer.count(); got != 2 {
+		t.Fatalf("syncs after a full batch = %d, want 2", got)
+	}
+
+	// Nothing is pending, so Sync has nothing to do
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := syncer.count(); got != 2 {
+		t.Fatalf("syncs after an idle Sync = %d, want 2", got)
+	}
+}
+
+func TestSyncFailureIsReported(t *testing.T) {
+	syncer := &countingSyncer{err: errors.New("injected")}
+	w := openTestWAL(t, Config{syncer: syncer})
+
+	if err := w.Append(annotation("a")); err == nil || !strings.Contains(err.Error(), "injected") {
+		t.Fatalf("Append = %v, want the sync error", err)
+	}
+}
+
+func TestAppendBatchPreservesOrder(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	batch := []Record{
+		taskCreated("a"),
+		leaseGranted("a", "l1", "w1", 1),
+		taskCompleted("a", "l1"),
+	}
+
+	if err := w.AppendBatch(batch); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+	if got, want := recordTypes(mustReplay(t, w)), recordTypes(batch); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestAppendBatchInvalidRecordWritesNothing(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	before, err := os.ReadFile(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := []Record{taskCreated("b"), taskCreated("c"), taskCompleted("c", ""), taskCreated("d")}
+	err = w.AppendBatch(batch)
+	if !errors.Is(err, ErrInvalidRecord) || !strings.Contains(err.Error(), "batch record 2") {
+		t.Fatalf("AppendBatch = %v, want ErrInvalidRecord for record 2", err)
+	}
+
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	after, err := os.ReadFile(w.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatalf("file changed from %d to %d bytes", len(before), len(after))
+	}
+}
+
+func TestAppendBatchNotInterleaved(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 64})
+
+	const writers, batches = 4, 50
+	var wg sync.WaitGroup
+	for g := 0; g < writers; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < batches; i++ {
+				batch := make([]Record, 3)
+				for k := range batch {
+					batch[k] = annotation(fmt.Sprintf("%d-%d-%d", g, i, k))
+				}
+				if err := w.AppendBatch(batch); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < batches; i++ {
+				if err := w.Append(annotation("single")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	records := mustReplay(t, w)
+	if len(records) != writers*batches*4 {
+		t.Fatalf("replayed %d records, want %d", len(records), writers*batches*4)
+	}
+	for i := 0; i < len(records); i++ {
+		text := records[i].Payload.(AnnotationPayload).Text
+		if text == "single" {
+			continue
+		}
+		// A batch starts with its record 0 and runs unbroken
+		prefix := strings.TrimSuffix(text, "-0")
+		if prefix == text {
+			t.Fatalf("record %d (%s) does not follow the start of its batch", i, text)
+		}
+		for k := 1; k < 3; k++ {
+			if got, want := records[i+k].Payload.(AnnotationPayload).Text, fmt.Sprintf("%s-%d", prefix, k); got != want {
+				t.Fatalf("record %d = %s, want %s", i+k, got, want)
+			}
+		}
+		i += 2
+	}
+}
+
+func TestAppendAtOffsetAdvancesByFrameSize(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	if got := w.Offset(); got != headerSize {
+		t.Fatalf("Offset of an empty log = %d, want %d", got, headerSize)
+	}
+
+	for _, record := range sampleRecords() {
+		encoded, err := w.encodeRecord(stampRecord(record, testTime))
+		if err != nil {
+			t.Fatalf("encodeRecord: %v", err)
+		}
+		before := w.Offset()
+		offset, err := w.AppendAt(record)
+		if err != nil {
+			t.Fatalf("AppendAt %s: %v", record.Type, err)
+		}
+		if offset != before {
+			t.Fatalf("AppendAt %s = %d, want %d", record.Type, offset, before)
+		}
+		if got, want := w.Offset(), before+int64(len(encoded)); got != want {
+			t.Fatalf("Offset after %s = %d, want %d", record.Type, got, want)
+		}
+	}
+}
+
+func TestAppendBatchOffsetIsStableForFile(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, taskCreated("a"))
+	offset := w.Offset()
+
+	// The offset recorded before a write locates the records written after
+	if err := w.AppendBatch([]Record{taskCreated("b"), taskCreated("c")}); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+	n := 0
+	if _, err := w.ReplayFrom(offset, func(Record) error { n++; return nil }); err != nil {
+		t.Fatalf("ReplayFrom: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("replayed %d records from the recorded offset, want 2", n)
+	}
+}
+
+func TestGroupCommitCoalescesSyncs(t *testing.T) {
+	syncer := &countingSyncer{delay: 2 * time.Millisecond}
+	w := openTestWAL(t, Config{SyncBatchSize: 1000, syncer: syncer})
+
+	const writers = 50
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if err := w.Append(taskCreated(fmt.Sprintf("t%d", i))); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := w.Sync(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if got := syncer.count(); got > writers/5 {
+		t.Fatalf("%d fsyncs for %d concurrent Syncs", got, writers)
+	}
+	if got := w.LastSyncedOffset(); got != w.Offset() {
+		t.Fatalf("LastSyncedOffset = %d, want %d", got, w.Offset())
+	}
+	if got := len(mustReplay(t, w)); got != writers {
+		t.Fatalf("replayed %d records, want %d", got, writers)
+	}
+}
+
+func TestSyncerFailureNotDurable(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, syncer: syncer})
+	mustAppend(t, w, taskCreated("a"))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	durable := w.LastSyncedOffset()
+
+	injected := errors.New("injected fsync failure")
+	syncer.fail(injected)
+	mustAppend(t, w, taskCreated("b"))
+	if err := w.Sync(); !errors.Is(err, injected) {
+		t.Fatalf("Sync = %v, want the injected error", err)
+	}
+	if got := w.LastSyncedOffset(); got != durable {
+		t.Fatalf("LastSyncedOffset = %d after a failed sync, want %d", got, durable)
+	}
+
+	// The record is still pending, so the next sync covers it
+	syncer.fail(nil)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := w.LastSyncedOffset(); got != w.Offset() {
+		t.Fatalf("LastSyncedOffset = %d, want %d", got, w.Offset())
+	}
+}
+
+func TestCloseSyncsThroughSyncer(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, syncer: syncer})
+	mustAppend(t, w, taskCreated("a"))
+
+	injected := errors.New("injected fsync failure")
+	syncer.fail(injected)
+	if err := w.Close(); !errors.Is(err, injected) {
+		t.Fatalf("Close = %v, want the injected error", err)
+	}
+}
+
+func TestConcurrentAppendsKeepPerWriterOrder(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 8, syncer: &countingSyncer{}})
+
+	const writers, perWriter = 8, 50
+	offsets := make([][]int64, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perWriter; j++ {
+				offset, err := w.AppendAt(taskCreated(fmt.Sprintf("w%d-%d", i, j)))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				offsets[i] = append(offsets[i], offset)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every writer's records appear in the order it appended them, at the
+	// offsets AppendAt returned
+	at := make(map[int64]string)
+	next := make([]int, writers)
+	err := w.ReplayWithOffset(func(offset int64, record Record) error {
+		id := record.Payload.(TaskCreatedPayload).TaskID
+		var i, j int
+		if _, err := fmt.Sscanf(id, "w%d-%d", &i, &j); err != nil {
+			return err
+		}
+		if j != next[i] {
+			return fmt.Errorf("writer %d record %d replayed before record %d", i, j, next[i])
+		}
+		next[i]++
+		at[offset] = id
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayWithOffset: %v", err)
+	}
+	for i := range offsets {
+		if next[i] != perWriter {
+			t.Fatalf("replayed %d records of writer %d, want %d", next[i], i, perWriter)
+		}
+		for j, offset := range offsets[i] {
+			if want := fmt.Sprintf("w%d-%d", i, j); at[offset] != want {
+				t.Fatalf("record at offset %d = %q, want %s", offset, at[offset], want)
+			}
+		}
+	}
+}
+
+// blockingSyncer holds every fsync until released
+type blockingSyncer struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSyncer) Sync(file *os.File) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return file.Sync()
+}
+
+func TestSyncPolicyReleasesLockDuringFsync(t *testing.T) {
+	syncer := &blockingSyncer{entered: make(chan struct{}, 16), release: make(chan struct{})}
+	w := openTestWAL(t, Config{SyncBatchSize: 2, syncer: syncer})
+	defer close(syncer.release)
+
+	mustAppend(t, w, taskCreated("a"))
+	first := make(chan error, 1)
+	go func() { first <- w.Append(taskCreated("b")) }()
+	<-syncer.entered
+
+	// The batch fsync is in flight, yet the lock is free
+	offsetRead := make(chan int64, 1)
+	go func() { offsetRead <- w.Offset() }()
+	select {
+	case <-offsetRead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Offset blocked by the batch fsync")
+	}
+
+	// With a full batch unsynced, further appends wait for the disk: a
+	// writer cannot run ahead of it by more than a batch
+	second := make(chan error, 1)
+	go func() { second <- w.Append(taskCreated("c")) }()
+	select {
+	case err := <-second:
+		t.Fatalf("Append = %v while a full batch was still syncing", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	syncer.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	// The first fsync only covered a and b
+	<-syncer.entered
+	syncer.release <- struct{}{}
+	if err := <-second; err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if got := w.LastSyncedOffset(); got != w.Offset() {
+		t.Fatalf("LastSyncedOffset = %d, want %d", got, w.Offset())
+	}
+}
+
+// BenchmarkConcurrentAppend compares parallel durable appenders with
+// appenders serialized by an outer mutex, which is how the log behaved
+// while encoding and the policy fsync ran under its lock. Concurrent
+// appenders share fsyncs, each of which is slowed to model a real disk
+func BenchmarkConcurrentAppend(b *testing.B) {
+	for _, bench := range []struct {
+		name       string
+		serialized bool
+	}{
+		{"serialized", true},
+		{"concurrent", false},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			w := openTestWAL(b, Config{SyncBatchSize: 1, syncer: &countingSyncer{delay: 100 * time.Microsecond}})
+			record := taskCreated("t")
+			var outer sync.Mutex
+
+			b.ReportAllocs()
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if bench.serialized {
+						outer.Lock()
+					}
+					err := w.Append(record)
+					if bench.serialized {
+						outer.Unlock()
+					}
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// halfWriter writes only the first half of each write to file, as a full
+// disk does, and then fails with err
+type halfWriter struct {
+	file *os.File
+	err  error
+}
+
+func (h halfWriter) Write(p []byte) (int, error) {
+	n, err := h.file.Write(p[:len(p)/2])
+	if err != nil {
+		return n, err
+	}
+	return n, h.err
+}
+
+// fillDisk makes the next writes of w to its file short, until a rollback
+// resets the write buffer to the file. The write buffer must be empty
+func fillDisk(w *WAL, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writer = bufio.NewWriterSize(halfWriter{file: w.file, err: err}, DefaultWriteBufferSize)
+}
+
+// fileSize returns the size of the file at path
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestAppendDiskFullRollsBack(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		record Record
+		err    error
+	}{
+		{"buffered ENOSPC", taskCreated("full"), syscall.ENOSPC},
+		{"buffered short write", taskCreated("full"), nil},
+		{"streamed ENOSPC", bigTask("full", streamMinBytes), syscall.ENOSPC},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := openTestWAL(t, Config{SequenceNumbers: true})
+			mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+			end := w.Offset()
+
+			fillDisk(w, tt.err)
+			err := w.Append(tt.record)
+			if err == nil {
+				t.Fatal("Append on a full disk succeeded")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("Append = %v, want it to wrap %v", err, tt.err)
+			}
+			if tt.err == nil && !errors.Is(err, io.ErrShortWrite) {
+				t.Fatalf("Append = %v, want io.ErrShortWrite", err)
+			}
+
+			// The partial bytes are gone and the log ends on a boundary
+			if w.Offset() != end {
+				t.Fatalf("offset = %d after the failed append, want %d", w.Offset(), end)
+			}
+			if size := fileSize(t, w.filePath); size != end {
+				t.Fatalf("file holds %d bytes after the failed append, want %d", size, end)
+			}
+			report, err := Verify(w.filePath)
+			if err != nil || report.TornTail || !report.Healthy() || report.ValidRecords != 2 {
+				t.Fatalf("Verify = %+v, %v, want two whole records", report, err)
+			}
+
+			// Appends resume where the failed one would have been
+			mustAppend(t, w, taskCreated("c"))
+			w = reopenTestWAL(t, w, Config{})
+			records := mustReplay(t, w)
+			if got, want := taskIDs(records), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("replayed %v, want %v", got, want)
+			}
+			if got, want := seqs(records), []uint64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("sequence numbers = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBufferedAppendsDroppedOnDiskFull(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	mustAppend(t, w, taskCreated("a"))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	synced := w.Offset()
+	fillDisk(w, syscall.ENOSPC)
+	mustAppend(t, w, taskCreated("b"), taskCreated("c"))
+
+	// The flush fails part way, so the unsynced records are dropped whole
+	if err := w.Sync(); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Sync on a full disk = %v, want ENOSPC", err)
+	}
+	if size := fileSize(t, w.filePath); size != synced || w.Offset() != synced {
+		t.Fatalf("file holds %d bytes, offset %d after the failed flush, want %d", size, w.Offset(), synced)
+	}
+	if got, want := taskIDs(mustReplay(t, w)), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestLastSyncedOffsetTracksSync(t *testing.T) {
+	syncer := &countingSyncer{}
+	w := openTestWAL(t, Config{SyncBatchSize: 100, syncer: syncer})
+	if got := w.LastSyncedOffset(); got != headerSize {
+		t.Fatalf("LastSyncedOffset of a new log = %d, want %d", got, headerSize)
+	}
+
+	// Buffered appends advance the written offset only
+	mustAppend(t, w, taskCreated("a"), taskCreated("b"))
+	written := w.Offset()
+	if got := w.LastSyncedOffset(); got != headerSize || written <= headerSize {
+		t.Fatalf("LastSyncedOffset = %d with %d bytes written, want %d", got, written, headerSize)
+	}
+
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := w.LastSyncedOffset(); got != written || syncer.count() != 1 {
+		t.Fatalf("LastSyncedOffset = %d after %d syncs, want %d", got, syncer.count(), written)
+	}
+
+	// A failed sync leaves it where it was
+	mustAppend(t, w, taskCreated("c"))
+	syncer.fail(errors.New("injected fsync failure"))
+	if err := w.Sync(); err == nil {
+		t.Fatal("Sync succeeded with a failing fsync")
+	}
+	if got := w.LastSyncedOffset(); got != written {
+		t.Fatalf("LastSyncedOffset = %d after a failed sync, want %d", got, written)
+	}
+}
+
+func TestLastSyncedOffsetAfterRewrites(t *testing.T) {
+	w := openTestWAL(t, Config{SyncBatchSize: 100})
+	offsets := appendTasksAt(t, w, 3)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Truncation pulls it back to the cut
+	if err := w.Truncate(offsets[1]); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := w.LastSyncedOffset(); got != offsets[1] {
+		t.Fatalf("LastSyncedOffset after Truncate = %d, want %d", got, offsets[1])
+	}
+
+	// A reopened, rotated or compacted file is durable as a whole
+	mustAppend(t, w, taskCreated("unsynced"))
+	w = reopenTestWAL(t, w, Config{SyncBatchSize: 100})
+	if got := w.LastSyncedOffset(); got != w.Offset() {
+		t.Fatalf("LastSyncedOffset after reopening = %d, want %d", got, w.Offset())
+	}
+	mustAppend(t, w, taskCreated("unsynced"))
+	if err := w.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got := w.LastSyncedOffset(); got != w.Offset() {
+		t.Fatalf("LastSyncedOffset after Compact = %d, want %d", got, w.Offset())
+	}
+	mustAppend(t, w, taskCreated("unsynced"))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if got := w.LastSyncedOffset(); got != headerSize {
+		t.Fatalf("LastSyncedOffset after Rotate = %d, want %d", got, headerSize)
+	}
+}
@@ -0,0 +1,107 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// clockSkewTolerance is how far a record's timestamp may trail an earlier
+// record's, or lead the replaying host's clock, before it is flagged
+const clockSkewTolerance = time.Minute
+
+// Warning flags a record that replayed fine but looks wrong, such as a
+// timestamp that points at a clock bug on the host that wrote it
+// As in ReplayError, the offset of a record in a sealed segment is
+// relative to its segment file
+type Warning struct {
+	Offset  int64
+	Type    RecordType
+	Message string
+}
+
+// String describes the record and what is suspicious about it
+func (w Warning) String() string {
+	return fmt.Sprintf("%s record at offset %d: %s", w.Type, w.Offset, w.Message)
+}
+
+// ReplayWithWarnings is Replay that also checks record timestamps and
+// collects a Warning, rather than failing, for each one that is
+// implausible: more than clockSkewTolerance behind an earlier record or
+// ahead of Config.Clock, a lease granted before its task was created, or
+// expiring before it was granted. Zero timestamps, as in version 1 files,
+// are not checked
+func (w *WAL) ReplayWithWarnings(applyFn func(Record) error) ([]Warning, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var warnings []Warning
+	var latest time.Time
+	now := w.clock()
+	created := make(map[string]time.Time)
+
+	err := w.replayAllLocked(context.Background(), func(record Record, offset, _ int64) error {
+		if err := applyFn(record); err != nil {
+			return err
+		}
+
+		warn := func(format string, args ...interface{}) {
+			warnings = append(warnings, Warning{
+				Offset:  offset,
+				Type:    record.Type,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+
+		at := recordTime(record)
+		if at.IsZero() {
+			return nil
+		}
+		if at.Before(latest.Add(-clockSkewTolerance)) {
+			warn("timestamp %s is %s behind an earlier record", at.Format(time.RFC3339Nano), latest.Sub(at))
+		}
+		if at.After(now.Add(clockSkewTolerance)) {
+			warn("timestamp %s is %s in the future", at.Format(time.RFC3339Nano), at.Sub(now))
+		}
+		if at.After(latest) {
+			latest = at
+		}
+
+		switch p := record.Payload.(type) {
+		case TaskCreatedPayload:
+			created[p.TaskID] = p.CreatedAt
+		case LeaseGrantedPayload:
+			if c, ok := created[p.TaskID]; ok && p.GrantedAt.Before(c) {
+				warn("lease %s granted %s before task %s was created", p.LeaseID, c.Sub(p.GrantedAt), p.TaskID)
+			}
+			if !p.LeaseExpiry.IsZero() && p.LeaseExpiry.Before(p.GrantedAt) {
+				warn("lease %s expires %s before it was granted", p.LeaseID, p.GrantedAt.Sub(p.LeaseExpiry))
+			}
+		}
+		return nil
+	})
+
+	return warnings, err
+}
+
+// recordTime returns the time a record was appended, as stamped by
+// stampRecord or carried by a checkpoint, or the zero time if it has none
+func recordTime(record Record) time.Time {
+	switch p := record.Payload.(type) {
+	case TaskCreatedPayload:
+		return p.CreatedAt
+	case LeaseGrantedPayload:
+		return p.GrantedAt
+	case LeaseExtendedPayload:
+		return p.At
+	case LeaseExpiredPayload:
+		return p.At
+	case LeaseRenewalDeniedPayload:
+		return p.At
+	case AnnotationPayload:
+		return p.At
+	case CheckpointPayload:
+		return p.Timestamp
+	}
+	return time.Time{}
+}
@@ -0,0 +1,127 @@
+package wal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createdAt is taskCreated stamped with at
+func createdAt(taskID string, at time.Time) Record {
+	record := taskCreated(taskID)
+	p := record.Payload.(TaskCreatedPayload)
+	p.CreatedAt = at
+	record.Payload = p
+	return record
+}
+
+// grantedAt is leaseGranted stamped with at, expiring at expiry
+func grantedAt(taskID, leaseID string, at, expiry time.Time) Record {
+	record := leaseGranted(taskID, leaseID, "w", 1)
+	p := record.Payload.(LeaseGrantedPayload)
+	p.GrantedAt = at
+	p.LeaseExpiry = expiry
+	record.Payload = p
+	return record
+}
+
+// replayWithWarnings returns the warnings of a full replay of w, checking
+// that every record is applied
+func replayWithWarnings(t *testing.T, w *WAL) []Warning {
+	t.Helper()
+	applied := 0
+	warnings, err := w.ReplayWithWarnings(func(Record) error { applied++; return nil })
+	if err != nil {
+		t.Fatalf("ReplayWithWarnings: %v", err)
+	}
+	if want := len(mustReplay(t, w)); applied != want {
+		t.Fatalf("applied %d records, want %d", applied, want)
+	}
+	return warnings
+}
+
+func TestReplayWithWarningsCleanLog(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mixedLog(t, w)
+	// Jitter within the tolerance is not flagged
+	mustAppend(t, w, createdAt("jitter", testTime.Add(-clockSkewTolerance/2)))
+
+	if warnings := replayWithWarnings(t, w); len(warnings) != 0 {
+		t.Fatalf("warnings for a clean log: %v", warnings)
+	}
+}
+
+func TestReplayWithWarningsBackwardJump(t *testing.T) {
+	w := openTestWAL(t, Config{Clock: func() time.Time { return testTime.Add(time.Hour) }})
+	mustAppend(t, w, createdAt("a", testTime.Add(30*time.Minute)))
+	offset, err := w.AppendAt(createdAt("b", testTime))
+	if err != nil {
+		t.Fatalf("AppendAt: %v", err)
+	}
+	mustAppend(t, w, createdAt("c", testTime.Add(31*time.Minute)))
+
+	warnings := replayWithWarnings(t, w)
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want one for the backward jump", warnings)
+	}
+	warning := warnings[0]
+	if warning.Offset != offset || warning.Type != RecordTypeTaskCreated || !strings.Contains(warning.Message, "30m0s behind an earlier record") {
+		t.Fatalf("warning = %+v, want the jump of record b at offset %d", warning, offset)
+	}
+	if s := warning.String(); !strings.HasPrefix(s, "TaskCreated record at offset ") {
+		t.Fatalf("String() = %q", s)
+	}
+}
+
+func TestReplayWithWarningsImplausibleTimes(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w,
+		createdAt("future", testTime.Add(2*time.Hour)),
+		createdAt("a", testTime),
+		grantedAt("a", "early", testTime.Add(-30*time.Second), testTime.Add(time.Minute)),
+		grantedAt("a", "backwards", testTime, testTime.Add(-time.Minute)),
+	)
+
+	var messages []string
+	for _, warning := range replayWithWarnings(t, w) {
+		messages = append(messages, warning.Message)
+	}
+	got := strings.Join(messages, "\n")
+	for _, want := range []string{
+		"is 2h0m0s in the future",
+		"lease early granted 30s before task a was created",
+		"lease backwards expires 1m0s before it was granted",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("warnings:\n%s\nwant one containing %q", got, want)
+		}
+	}
+}
+
+func TestReplayWithWarningsSkipsZeroTimes(t *testing.T) {
+	// Version 1 files carry no lease times
+	path := testPath(t)
+	writeVersionedLog(t, path, versionInitial,
+		createdAt("a", testTime),
+		leaseGranted("a", "l1", "w", 1),
+		leaseExtended("l1", testTime.Add(time.Hour)),
+		leaseExpired("a", "l1"),
+		taskCompleted("a", "l1"),
+	)
+	w := openTestWAL(t, Config{FilePath: path, ReadOnly: true})
+	if warnings := replayWithWarnings(t, w); len(warnings) != 0 {
+		t.Fatalf("warnings for a version 1 log: %v", warnings)
+	}
+}
+
+func TestReplayWithWarningsApplyError(t *testing.T) {
+	w := openTestWAL(t, Config{})
+	mustAppend(t, w, createdAt("a", testTime), createdAt("b", testTime.Add(-time.Hour)))
+
+	stop := errors.New("stop")
+	warnings, err := w.ReplayWithWarnings(func(Record) error { return stop })
+	if !errors.Is(err, stop) || len(warnings) != 0 {
+		t.Fatalf("ReplayWithWarnings = %v, %v, want stop before any warning", warnings, err)
+	}
+}